@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// The classification cache remembers what the LLM (or the user) decided for
+// a vendor, so the next charge from the same vendor, in the same amount band
+// on the same card, is classified without another LLM call
+type cachedClassification struct {
+	Key            string
+	Classification ExpenseClassification
+	Source         string // Provider that produced it, or "manual"
+}
+
+func createClassificationCacheTable() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS classification_cache (
+			cache_key TEXT PRIMARY KEY,
+			vendor TEXT NOT NULL,
+			amount_band TEXT NOT NULL,
+			card TEXT DEFAULT '',
+			category TEXT NOT NULL,
+			schedule_c_line INTEGER NOT NULL,
+			expensable BOOLEAN DEFAULT FALSE,
+			purpose TEXT DEFAULT '',
+			confidence REAL DEFAULT 0,
+			source TEXT DEFAULT '',
+			hits INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`)
+	if err != nil {
+		return fmt.Errorf("error creating classification_cache table: %v", err)
+	}
+	return nil
+}
+
+// cacheVendor normalizes a vendor for the cache key, dropping words with
+// digits (store numbers, phone numbers, order IDs)
+func cacheVendor(vendor string) string {
+	var words []string
+	for _, word := range strings.Fields(normalizeText(vendor)) {
+		if !strings.ContainsAny(word, "0123456789") {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// amountBand groups amounts by order of magnitude: "0-9", "10-99",
+// "100-999", ... dollars, with a "-" prefix for credits
+func amountBand(amount Cents) string {
+	low, high := int64(0), int64(9)
+	for dollars := int64(amount.Abs()) / 100; dollars > high; {
+		low, high = high+1, high*10+9
+	}
+	band := fmt.Sprintf("%d-%d", low, high)
+	if amount < 0 {
+		band = "-" + band
+	}
+	return band
+}
+
+// classificationCacheKey returns the cache key for a transaction, or "" if
+// its vendor has nothing to key on
+func classificationCacheKey(tx Transaction) string {
+	vendor := cacheVendor(tx.Vendor)
+	if vendor == "" {
+		return ""
+	}
+	return vendor + "|" + amountBand(tx.Amount) + "|" + strings.ToLower(strings.TrimSpace(tx.Card))
+}
+
+// loadClassificationCache returns the cached classifications for the given keys
+func loadClassificationCache(keys []string) (map[string]*cachedClassification, error) {
+	cache := make(map[string]*cachedClassification)
+	if len(keys) == 0 {
+		return cache, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(keys)), ",")
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+
+	rows, err := db.Query(`
+		SELECT cache_key, category, schedule_c_line, expensable, purpose, confidence, source
+		FROM classification_cache
+		WHERE cache_key IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load classification cache: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry cachedClassification
+		c := &entry.Classification
+		if err := rows.Scan(&entry.Key, &c.Category, &c.ScheduleCLine, &c.Expensable, &c.Purpose, &c.Confidence, &entry.Source); err != nil {
+			return nil, fmt.Errorf("failed to scan classification cache: %v", err)
+		}
+		cache[entry.Key] = &entry
+	}
+	return cache, rows.Err()
+}
+
+// saveClassificationCache stores or replaces the classification for the
+// transaction's cache key
+func saveClassificationCache(tx Transaction, classification *ExpenseClassification, source string) error {
+	key := classificationCacheKey(tx)
+	if key == "" {
+		return nil
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO classification_cache (cache_key, vendor, amount_band, card, category, schedule_c_line, expensable, purpose, confidence, source)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(cache_key) DO UPDATE SET
+			category = excluded.category,
+			schedule_c_line = excluded.schedule_c_line,
+			expensable = excluded.expensable,
+			purpose = excluded.purpose,
+			confidence = excluded.confidence,
+			source = excluded.source,
+			updated_at = CURRENT_TIMESTAMP`,
+		key, cacheVendor(tx.Vendor), amountBand(tx.Amount), strings.TrimSpace(tx.Card),
+		classification.Category, classification.ScheduleCLine, classification.Expensable,
+		classification.Purpose, classification.Confidence, source)
+	return err
+}
+
+// recordCacheHits adds this run's hits to each entry's lifetime count
+func recordCacheHits(hits map[string]int) {
+	for key, count := range hits {
+		if _, err := db.Exec("UPDATE classification_cache SET hits = hits + ? WHERE cache_key = ?", count, key); err != nil {
+			log.Printf("Warning: Could not record cache hits for %s: %v", key, err)
+		}
+	}
+}
+
+// CategorizeStats reports what one categorization run did and how much the
+// local classifier and the cache saved
+type CategorizeStats struct {
+	Total           int     `json:"total"`            // Uncategorized business transactions at the start
+	Classified      int     `json:"classified"`       // By any means
+	LocalClassified int     `json:"local_classified"` // By the local classifier
+	CacheLookups    int     `json:"cache_lookups"`    // Transactions left after the local classifier
+	CacheHits       int     `json:"cache_hits"`       // Served from the classification cache
+	CacheHitRate    float64 `json:"cache_hit_rate"`   // CacheHits / CacheLookups
+	Deduplicated    int     `json:"deduplicated"`     // Same vendor, band and card as another transaction sent in this run
+	LLMClassified   int     `json:"llm_classified"`
+	LLMCalls        int     `json:"llm_calls"`       // Requests made to the provider, including single-transaction retries
+	LLMCallsSaved   int     `json:"llm_calls_saved"` // Batch requests avoided by the cache and deduplication
+	Failed          int     `json:"failed"`          // Transactions the provider or the database failed on
+
+	Errors []string `json:"-"` // First maxCategorizeErrors failures, reported on the job
+}
+
+// maxCategorizeErrors caps the error messages kept for one run
+const maxCategorizeErrors = 20
+
+// fail records one failed transaction
+func (stats *CategorizeStats) fail(format string, args ...interface{}) {
+	stats.failGroup(nil, format, args...)
+}
+
+// failGroup records a failure that covers every transaction grouped under
+// one cache key (at least one)
+func (stats *CategorizeStats) failGroup(group []Transaction, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Printf("⚠️ %s", message)
+	stats.Failed += max(1, len(group))
+	if len(stats.Errors) < maxCategorizeErrors {
+		stats.Errors = append(stats.Errors, message)
+	}
+}
+
+// progress returns the stats with Classified brought up to date
+func (stats CategorizeStats) progress() CategorizeStats {
+	stats.Classified = stats.LocalClassified + stats.CacheHits + stats.LLMClassified
+	return stats
+}
+
+func clearClassificationCache(w http.ResponseWriter, r *http.Request) {
+	result, err := db.Exec("DELETE FROM classification_cache")
+	if err != nil {
+		log.Printf("Failed to clear classification cache: %v", err)
+		http.Error(w, "Failed to clear classification cache", http.StatusInternalServerError)
+		return
+	}
+
+	cleared, _ := result.RowsAffected()
+	log.Printf("🧹 Cleared %d classification cache entries", cleared)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Classification cache cleared",
+		"cleared": cleared,
+	})
+}
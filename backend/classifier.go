@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Classifier assigns Schedule C categories to business transactions. The
+// provider is chosen at startup from CLASSIFIER_PROVIDER.
+type Classifier interface {
+	Name() string
+	// ClassifyBatch returns classifications keyed by transaction ID; some
+	// transactions may be missing from the result
+	ClassifyBatch(transactions []Transaction) (map[string]*ExpenseClassification, error)
+	Classify(tx Transaction) (*ExpenseClassification, error)
+}
+
+var classifier Classifier = noneClassifier{}
+
+// errClassifierDisabled is returned by the "none" provider
+var errClassifierDisabled = errors.New("automatic categorization is disabled (CLASSIFIER_PROVIDER=none)")
+
+const (
+	openRouterBaseURL = "https://openrouter.ai/api/v1"
+	openRouterModel   = "anthropic/claude-3.5-sonnet"
+)
+
+// newClassifierFromEnv builds the classifier from the environment:
+//
+//	CLASSIFIER_PROVIDER     openrouter, openai (any OpenAI-compatible server) or none;
+//	                        default openrouter when OPENROUTER_API_KEY is set, else none
+//	CLASSIFIER_MODEL        model name; default anthropic/claude-3.5-sonnet on OpenRouter
+//	CLASSIFIER_BASE_URL     API base URL, e.g. http://localhost:11434/v1 for Ollama
+//	CLASSIFIER_API_KEY      bearer token; OpenRouter falls back to OPENROUTER_API_KEY
+//	CLASSIFIER_TIMEOUT_SECONDS  per-request timeout; default 60 for a batch, 30 for one
+func newClassifierFromEnv() (Classifier, error) {
+	provider := strings.ToLower(strings.TrimSpace(os.Getenv("CLASSIFIER_PROVIDER")))
+	if provider == "" {
+		provider = "openrouter"
+		if os.Getenv("OPENROUTER_API_KEY") == "" {
+			log.Printf("⚠️ OPENROUTER_API_KEY not set and no CLASSIFIER_PROVIDER given; automatic categorization is disabled")
+			provider = "none"
+		}
+	}
+
+	var timeout time.Duration
+	if value := os.Getenv("CLASSIFIER_TIMEOUT_SECONDS"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("CLASSIFIER_TIMEOUT_SECONDS must be a positive number of seconds, got %q", value)
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	model := os.Getenv("CLASSIFIER_MODEL")
+	baseURL := strings.TrimSuffix(os.Getenv("CLASSIFIER_BASE_URL"), "/")
+	apiKey := os.Getenv("CLASSIFIER_API_KEY")
+
+	switch provider {
+	case "none":
+		return noneClassifier{}, nil
+	case "openrouter":
+		if apiKey == "" {
+			apiKey = os.Getenv("OPENROUTER_API_KEY")
+		}
+		if apiKey == "" {
+			return nil, fmt.Errorf("the openrouter provider needs OPENROUTER_API_KEY or CLASSIFIER_API_KEY")
+		}
+		if model == "" {
+			model = openRouterModel
+		}
+		if baseURL == "" {
+			baseURL = openRouterBaseURL
+		}
+		return &chatClassifier{
+			provider: "openrouter",
+			baseURL:  baseURL,
+			model:    model,
+			apiKey:   apiKey,
+			headers: map[string]string{
+				"HTTP-Referer": "https://github.com/jgabriele321/Schedule_C_Calculator",
+				"X-Title":      "Schedule C Calculator",
+			},
+			timeout: timeout,
+		}, nil
+	case "openai":
+		if baseURL == "" {
+			return nil, fmt.Errorf("the openai provider needs CLASSIFIER_BASE_URL, e.g. http://localhost:11434/v1")
+		}
+		if model == "" {
+			return nil, fmt.Errorf("the openai provider needs CLASSIFIER_MODEL")
+		}
+		return &chatClassifier{provider: "openai", baseURL: baseURL, model: model, apiKey: apiKey, timeout: timeout}, nil
+	default:
+		return nil, fmt.Errorf("CLASSIFIER_PROVIDER must be openrouter, openai or none, got %q", provider)
+	}
+}
+
+// noneClassifier leaves every transaction for manual classification
+type noneClassifier struct{}
+
+func (noneClassifier) Name() string { return "none" }
+
+func (noneClassifier) ClassifyBatch(transactions []Transaction) (map[string]*ExpenseClassification, error) {
+	return nil, errClassifierDisabled
+}
+
+func (noneClassifier) Classify(tx Transaction) (*ExpenseClassification, error) {
+	return nil, errClassifierDisabled
+}
+
+// OpenAI chat completions API structures, spoken by OpenRouter, Ollama,
+// llama.cpp server and LM Studio alike
+type ChatRequest struct {
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages"`
+}
+
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ChatResponse struct {
+	Choices []Choice `json:"choices"`
+}
+
+type Choice struct {
+	Message Message `json:"message"`
+}
+
+// chatClassifier asks a chat completions endpoint to classify transactions
+type chatClassifier struct {
+	provider string
+	baseURL  string // e.g. https://openrouter.ai/api/v1; /chat/completions is appended
+	model    string
+	apiKey   string // Optional for local servers
+	headers  map[string]string
+	timeout  time.Duration // Zero = 60s for a batch, 30s for one transaction
+}
+
+func (c *chatClassifier) Name() string { return c.provider + ":" + c.model }
+
+// complete sends one user message and returns the reply with any markdown
+// code fence removed
+func (c *chatClassifier) complete(prompt string, timeout time.Duration) (string, error) {
+	requestBody := ChatRequest{
+		Model: c.model,
+		Messages: []Message{
+			{
+				Role:    "user",
+				Content: prompt,
+			},
+		},
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", c.baseURL+"/chat/completions", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	for name, value := range c.headers {
+		req.Header.Set(name, value)
+	}
+
+	if c.timeout > 0 {
+		timeout = c.timeout
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("%s API error %d: %s", c.provider, resp.StatusCode, string(body))
+	}
+
+	var chatResp ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return "", fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if len(chatResp.Choices) == 0 {
+		return "", fmt.Errorf("no response from LLM")
+	}
+
+	content := chatResp.Choices[0].Message.Content
+
+	// Clean up the content - remove markdown code blocks if present
+	content = strings.TrimSpace(content)
+	if strings.HasPrefix(content, "```json") {
+		content = strings.TrimPrefix(content, "```json")
+	}
+	if strings.HasPrefix(content, "```") {
+		content = strings.TrimPrefix(content, "```")
+	}
+	if strings.HasSuffix(content, "```") {
+		content = strings.TrimSuffix(content, "```")
+	}
+	return strings.TrimSpace(content), nil
+}
+
+// Batch classify multiple transactions for better performance
+func (c *chatClassifier) ClassifyBatch(transactions []Transaction) (map[string]*ExpenseClassification, error) {
+	if len(transactions) == 0 {
+		return make(map[string]*ExpenseClassification), nil
+	}
+
+	content, err := c.complete(batchClassificationPrompt(transactions), 60*time.Second) // Longer timeout for batch processing
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse as array of classifications
+	type BatchClassification struct {
+		TransactionID string  `json:"transaction_id"`
+		Category      string  `json:"category"`
+		ScheduleCLine int     `json:"schedule_c_line"`
+		Expensable    bool    `json:"expensable"`
+		Purpose       string  `json:"purpose"`
+		Confidence    float64 `json:"confidence"`
+	}
+
+	var batchResults []BatchClassification
+	if err := json.Unmarshal([]byte(content), &batchResults); err != nil {
+		return nil, fmt.Errorf("failed to parse batch classification JSON: %v", err)
+	}
+
+	// Convert to map and validate
+	results := make(map[string]*ExpenseClassification)
+	for _, result := range batchResults {
+		classification := &ExpenseClassification{
+			Category:      result.Category,
+			ScheduleCLine: result.ScheduleCLine,
+			Expensable:    result.Expensable,
+			Purpose:       result.Purpose,
+			Confidence:    result.Confidence,
+		}
+		validateScheduleCLine(classification, "transaction "+result.TransactionID)
+		results[result.TransactionID] = classification
+	}
+
+	return results, nil
+}
+
+// Individual transaction classification (fallback for batch failures)
+func (c *chatClassifier) Classify(tx Transaction) (*ExpenseClassification, error) {
+	content, err := c.complete(classificationPrompt(tx), 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	var classification ExpenseClassification
+	if err := json.Unmarshal([]byte(content), &classification); err != nil {
+		return nil, fmt.Errorf("failed to parse LLM response: %v", err)
+	}
+
+	validateScheduleCLine(&classification, tx.Vendor)
+	return &classification, nil
+}
+
+// validateScheduleCLine never allows Line 0 or a line outside 8-27
+func validateScheduleCLine(classification *ExpenseClassification, subject string) {
+	if classification.ScheduleCLine < 8 || classification.ScheduleCLine > 27 {
+		log.Printf("⚠️ Invalid schedule_c_line %d for %s, converting to Line 27 (Other business expenses)",
+			classification.ScheduleCLine, subject)
+		classification.ScheduleCLine = 27
+		classification.Category = "Other business expenses"
+	}
+}
+
+func batchClassificationPrompt(transactions []Transaction) string {
+	var transactionList strings.Builder
+	for i, tx := range transactions {
+		transactionList.WriteString(fmt.Sprintf(`
+Transaction %d:
+- ID: %s
+- Vendor: %s
+- Amount: $%s
+- Description: %s
+- Statement description: %s`, i+1, tx.ID, tx.Vendor, tx.Amount, tx.Purpose, tx.Description))
+	}
+
+	return fmt.Sprintf(`You are an expert tax accountant specializing in Schedule C business expenses. 
+
+Please categorize these %d business transactions and provide the corresponding IRS Schedule C line numbers:
+%s
+
+For EACH transaction, provide a JSON object with:
+1. transaction_id: The exact ID provided
+2. category: Must be one of the exact categories listed below
+3. schedule_c_line: IRS Schedule C line number (MUST be 8-27, NEVER use 0)
+4. expensable: true/false if this is a legitimate business expense
+5. purpose: Brief business purpose description
+6. confidence: 0.0-1.0 confidence score
+
+REQUIRED CATEGORIES (use exact names):
+- Line 8: "Advertising"
+- Line 9: "Car and truck"
+- Line 10: "Commissions and fees"
+- Line 11: "Contractors"
+- Line 15: "Insurance"
+- Line 16: "Interest paid"
+- Line 17: "Legal fees and professional services"
+- Line 18: "Office expenses"
+- Line 20: "Rent and lease"
+- Line 21: "Repairs and maintenance"
+- Line 22: "Supplies"
+- Line 23: "Taxes and licenses"
+- Line 24: "Meals"
+- Line 25: "Travel expenses"
+- Line 26: "Utilities"
+- Line 27: "Other business expenses"
+
+CRITICAL RULES:
+- NEVER use Line 0 or any number outside 8-27
+- If uncertain about the category, ALWAYS use "Other business expenses" (Line 27)
+- If you think it's not a business expense, still use Line 27 and set expensable: false
+- The schedule_c_line MUST be between 8 and 27 (inclusive)
+
+Return a JSON array with one object per transaction:
+[
+  {
+    "transaction_id": "exact_id_from_input",
+    "category": "category_name",
+    "schedule_c_line": number,
+    "expensable": boolean,
+    "purpose": "description",
+    "confidence": number
+  }
+]`, len(transactions), transactionList.String())
+}
+
+func classificationPrompt(tx Transaction) string {
+	return fmt.Sprintf(`You are an expert tax accountant specializing in Schedule C business expenses. 
+
+Please categorize this business transaction and provide the corresponding IRS Schedule C line number:
+
+Vendor: %s
+Amount: $%s
+Description: %s
+Statement description: %s
+
+Based on this information, provide a JSON response with:
+1. category: Must be one of the exact categories listed below
+2. schedule_c_line: IRS Schedule C line number (MUST be 8-27, NEVER use 0)
+3. expensable: true/false if this is a legitimate business expense
+4. purpose: Brief business purpose description
+5. confidence: 0.0-1.0 confidence score
+
+REQUIRED CATEGORIES (use exact names):
+- Line 8: "Advertising"
+- Line 9: "Car and truck"
+- Line 10: "Commissions and fees"
+- Line 11: "Contractors"
+- Line 15: "Insurance"
+- Line 16: "Interest paid"
+- Line 17: "Legal fees and professional services"
+- Line 18: "Office expenses"
+- Line 20: "Rent and lease"
+- Line 21: "Repairs and maintenance"
+- Line 22: "Supplies"
+- Line 23: "Taxes and licenses"
+- Line 24: "Meals"
+- Line 25: "Travel expenses"
+- Line 26: "Utilities"
+- Line 27: "Other business expenses"
+
+CRITICAL RULES:
+- NEVER use Line 0 or any number outside 8-27
+- If uncertain about the category, ALWAYS use "Other business expenses" (Line 27)
+- If you think it's not a business expense, still use Line 27 and set expensable: false
+- The schedule_c_line MUST be between 8 and 27 (inclusive)
+
+Use the exact category name from the list above. If unsure, use "Other business expenses".
+
+Respond with ONLY valid JSON:`, tx.Vendor, tx.Amount, tx.Purpose, tx.Description)
+}
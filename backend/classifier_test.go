@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setClassifierEnv clears the classifier settings and applies the given ones
+func setClassifierEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	for _, name := range []string{"CLASSIFIER_PROVIDER", "CLASSIFIER_MODEL", "CLASSIFIER_BASE_URL",
+		"CLASSIFIER_API_KEY", "CLASSIFIER_TIMEOUT_SECONDS", "OPENROUTER_API_KEY"} {
+		t.Setenv(name, env[name])
+	}
+}
+
+// chatStub is a local OpenAI-compatible server that records the last
+// request and answers with reply
+type chatStub struct {
+	*httptest.Server
+	path    string
+	headers http.Header
+	request ChatRequest
+	status  int
+	reply   string
+	delay   time.Duration
+}
+
+func newChatStub(t *testing.T) *chatStub {
+	stub := &chatStub{status: http.StatusOK}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stub.path = r.Method + " " + r.URL.Path
+		stub.headers = r.Header.Clone()
+		if err := json.NewDecoder(r.Body).Decode(&stub.request); err != nil {
+			t.Errorf("stub: invalid request body: %v", err)
+		}
+		time.Sleep(stub.delay)
+
+		if stub.status != http.StatusOK {
+			http.Error(w, "model not loaded", stub.status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ChatResponse{Choices: []Choice{{Message: Message{Role: "assistant", Content: stub.reply}}}})
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func TestOpenAICompatibleClassifier(t *testing.T) {
+	stub := newChatStub(t)
+	setClassifierEnv(t, map[string]string{
+		"CLASSIFIER_PROVIDER": "openai",
+		"CLASSIFIER_BASE_URL": stub.URL + "/v1/",
+		"CLASSIFIER_MODEL":    "llama3.1:8b",
+	})
+
+	c, err := newClassifierFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name() != "openai:llama3.1:8b" {
+		t.Errorf("name = %s, want openai:llama3.1:8b", c.Name())
+	}
+
+	stub.reply = "```json\n" + `[
+		{"transaction_id": "tx-1", "category": "Supplies", "schedule_c_line": 22, "expensable": true, "purpose": "Printer paper", "confidence": 0.9},
+		{"transaction_id": "tx-2", "category": "Parking", "schedule_c_line": 0, "expensable": true, "purpose": "Parking", "confidence": 0.4}
+	]` + "\n```"
+	transactions := []Transaction{
+		{ID: "tx-1", Vendor: "STAPLES", Amount: 2599, Description: "STAPLES 00123"},
+		{ID: "tx-2", Vendor: "SP PARKING", Amount: 1200},
+	}
+
+	results, err := c.ClassifyBatch(transactions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stub.path != "POST /v1/chat/completions" {
+		t.Errorf("request = %s, want POST /v1/chat/completions", stub.path)
+	}
+	if got := stub.headers.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := stub.headers.Get("Authorization"); got != "" {
+		t.Errorf("Authorization = %q, want none without CLASSIFIER_API_KEY", got)
+	}
+	if stub.request.Model != "llama3.1:8b" {
+		t.Errorf("model = %q, want llama3.1:8b", stub.request.Model)
+	}
+	if len(stub.request.Messages) != 1 || stub.request.Messages[0].Role != "user" {
+		t.Fatalf("messages = %+v, want one user message", stub.request.Messages)
+	}
+	prompt := stub.request.Messages[0].Content
+	for _, want := range []string{"ID: tx-1", "Vendor: STAPLES", "Amount: $25.99", "Statement description: STAPLES 00123", "ID: tx-2"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt is missing %q", want)
+		}
+	}
+
+	if got := results["tx-1"]; got == nil || got.Category != "Supplies" || got.ScheduleCLine != 22 || !got.Expensable || got.Confidence != 0.9 {
+		t.Errorf("tx-1 = %+v, want Supplies on line 22", got)
+	}
+	// Line 0 is never accepted
+	if got := results["tx-2"]; got == nil || got.ScheduleCLine != 27 || got.Category != "Other business expenses" {
+		t.Errorf("tx-2 = %+v, want moved to line 27", got)
+	}
+
+	stub.reply = `{"category": "Meals", "schedule_c_line": 24, "expensable": true, "purpose": "Client lunch", "confidence": 0.8}`
+	single, err := c.Classify(Transaction{ID: "tx-3", Vendor: "CHIPOTLE", Amount: 1850})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if single.Category != "Meals" || single.ScheduleCLine != 24 {
+		t.Errorf("single = %+v, want Meals on line 24", single)
+	}
+	if !strings.Contains(stub.request.Messages[0].Content, "Vendor: CHIPOTLE") {
+		t.Errorf("single prompt doesn't name the vendor")
+	}
+}
+
+func TestOpenRouterClassifier(t *testing.T) {
+	stub := newChatStub(t)
+	setClassifierEnv(t, map[string]string{
+		"OPENROUTER_API_KEY":  "sk-or-test",
+		"CLASSIFIER_BASE_URL": stub.URL,
+	})
+
+	c, err := newClassifierFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name() != "openrouter:"+openRouterModel {
+		t.Errorf("name = %s, want the default OpenRouter model", c.Name())
+	}
+
+	stub.reply = "[]"
+	if _, err := c.ClassifyBatch([]Transaction{{ID: "tx-1", Vendor: "GITHUB"}}); err != nil {
+		t.Fatal(err)
+	}
+	if stub.path != "POST /chat/completions" {
+		t.Errorf("request = %s, want POST /chat/completions", stub.path)
+	}
+	if got := stub.headers.Get("Authorization"); got != "Bearer sk-or-test" {
+		t.Errorf("Authorization = %q, want the OpenRouter key", got)
+	}
+	if stub.headers.Get("X-Title") == "" || stub.headers.Get("HTTP-Referer") == "" {
+		t.Errorf("OpenRouter attribution headers missing: %v", stub.headers)
+	}
+	if stub.request.Model != openRouterModel {
+		t.Errorf("model = %q, want %q", stub.request.Model, openRouterModel)
+	}
+}
+
+func TestClassifierErrors(t *testing.T) {
+	stub := newChatStub(t)
+	c := &chatClassifier{provider: "openai", baseURL: stub.URL, model: "local", apiKey: "secret"}
+	transactions := []Transaction{{ID: "tx-1", Vendor: "STAPLES"}}
+
+	stub.status = http.StatusServiceUnavailable
+	_, err := c.ClassifyBatch(transactions)
+	if err == nil || !strings.Contains(err.Error(), "openai API error 503") || !strings.Contains(err.Error(), "model not loaded") {
+		t.Errorf("err = %v, want the status and body reported", err)
+	}
+	if got := stub.headers.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("Authorization = %q, want Bearer secret", got)
+	}
+
+	stub.status = http.StatusOK
+	stub.reply = "Sorry, I can't help with that."
+	if _, err := c.ClassifyBatch(transactions); err == nil || !strings.Contains(err.Error(), "failed to parse") {
+		t.Errorf("err = %v, want a parse error", err)
+	}
+	if _, err := c.Classify(transactions[0]); err == nil || !strings.Contains(err.Error(), "failed to parse") {
+		t.Errorf("err = %v, want a parse error", err)
+	}
+
+	stub.reply = ""
+	if results, err := c.ClassifyBatch(nil); err != nil || len(results) != 0 {
+		t.Errorf("empty batch = %v, %v; want no request and no results", results, err)
+	}
+
+	stub.reply = "[]"
+	stub.delay = 200 * time.Millisecond
+	c.timeout = 20 * time.Millisecond
+	if _, err := c.ClassifyBatch(transactions); err == nil {
+		t.Error("slow response didn't time out")
+	}
+
+	unreachable := &chatClassifier{provider: "openai", baseURL: "http://127.0.0.1:1", model: "local"}
+	if _, err := unreachable.Classify(transactions[0]); err == nil || !strings.Contains(err.Error(), "failed to make request") {
+		t.Errorf("err = %v, want a connection error", err)
+	}
+}
+
+func TestNoneClassifier(t *testing.T) {
+	setClassifierEnv(t, nil)
+
+	c, err := newClassifierFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name() != "none" {
+		t.Fatalf("name = %s, want none without any key", c.Name())
+	}
+	if _, err := c.ClassifyBatch([]Transaction{{ID: "tx-1"}}); !errors.Is(err, errClassifierDisabled) {
+		t.Errorf("batch err = %v, want errClassifierDisabled", err)
+	}
+	if _, err := c.Classify(Transaction{ID: "tx-1"}); !errors.Is(err, errClassifierDisabled) {
+		t.Errorf("err = %v, want errClassifierDisabled", err)
+	}
+
+	// An explicit none wins over a key being set
+	setClassifierEnv(t, map[string]string{"CLASSIFIER_PROVIDER": "None", "OPENROUTER_API_KEY": "sk-or-test"})
+	if c, err := newClassifierFromEnv(); err != nil || c.Name() != "none" {
+		t.Errorf("classifier = %v, %v; want none", c, err)
+	}
+}
+
+func TestClassifierConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"openai without base URL", map[string]string{"CLASSIFIER_PROVIDER": "openai", "CLASSIFIER_MODEL": "llama3"}, "CLASSIFIER_BASE_URL"},
+		{"openai without model", map[string]string{"CLASSIFIER_PROVIDER": "openai", "CLASSIFIER_BASE_URL": "http://localhost:11434/v1"}, "CLASSIFIER_MODEL"},
+		{"openrouter without key", map[string]string{"CLASSIFIER_PROVIDER": "openrouter"}, "OPENROUTER_API_KEY"},
+		{"unknown provider", map[string]string{"CLASSIFIER_PROVIDER": "bard"}, "must be openrouter, openai or none"},
+		{"invalid timeout", map[string]string{"CLASSIFIER_PROVIDER": "none", "CLASSIFIER_TIMEOUT_SECONDS": "soon"}, "CLASSIFIER_TIMEOUT_SECONDS"},
+		{"zero timeout", map[string]string{"CLASSIFIER_PROVIDER": "none", "CLASSIFIER_TIMEOUT_SECONDS": "0"}, "CLASSIFIER_TIMEOUT_SECONDS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setClassifierEnv(t, tt.env)
+			_, err := newClassifierFromEnv()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want one mentioning %s", err, tt.want)
+			}
+		})
+	}
+}
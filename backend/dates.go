@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Day/month order of numeric dates such as 03/04/2024. An empty order means
+// month-first, falling back to day-first only when month-first can't parse.
+const (
+	dateOrderMonthFirst = "month_first"
+	dateOrderDayFirst   = "day_first"
+)
+
+func validDateOrder(order string) bool {
+	return order == "" || order == dateOrderMonthFirst || order == dateOrderDayFirst
+}
+
+// numericDatePattern matches 3/4/2024, 03-04-24, 03.04.2024, capturing the
+// first two fields for date order inference
+var numericDatePattern = regexp.MustCompile(`^(\d{1,2})[/.-](\d{1,2})[/.-](\d{2}|\d{4})$`)
+
+// timeOfDayPattern matches a trailing time after a date: "T13:45:00Z",
+// " 13:45", " 1:45 PM", "T13:45:00.000-05:00", " 13:45:00 +0000"
+var timeOfDayPattern = regexp.MustCompile(`(?i)[T ]\d{1,2}:\d{2}(:\d{2}(\.\d+)?)?\s*([AP]M)?\s*(Z|[+-]\d{2}:?\d{2}|[A-Z]{3,4})?$`)
+
+// Numeric layouts for each order. Go's "1" and "2" accept one or two digits.
+var (
+	monthFirstLayouts  = []string{"1/2/2006", "1-2-2006", "1.2.2006", "1/2/06", "1-2-06", "1.2.06"}
+	dayFirstLayouts    = []string{"2/1/2006", "2-1-2006", "2.1.2006", "2/1/06", "2-1-06", "2.1.06"}
+	unambiguousLayouts = []string{
+		"2006-01-02",
+		"2006-1-2",
+		"2006/1/2",
+		"January 2, 2006",
+		"Jan 2, 2006",
+		"Jan 2 2006",
+		"2 January 2006",
+		"2 Jan 2006",
+		"02-Jan-2006",
+		"2-Jan-06",
+	}
+)
+
+// stripTimeOfDay drops a trailing time (and zone) from a timestamp. Only the
+// calendar date is kept, as written, so timestamped exports line up with
+// date-only ones.
+func stripTimeOfDay(value string) string {
+	if !strings.Contains(value, ":") {
+		return strings.TrimSpace(value) // Most cells; skips the regexp
+	}
+	return strings.TrimSpace(timeOfDayPattern.ReplaceAllString(value, ""))
+}
+
+func parseDate(dateStr string) (time.Time, error) {
+	return parseDateInOrder(dateStr, "")
+}
+
+// parseDateInOrder parses a date using order for numeric day/month dates.
+// Two-digit years follow Go's rule: 69-99 are 19xx, 00-68 are 20xx.
+func parseDateInOrder(dateStr, order string) (time.Time, error) {
+	value := stripTimeOfDay(dateStr)
+
+	var layouts []string
+	switch order {
+	case dateOrderDayFirst:
+		layouts = dayFirstLayouts
+	case dateOrderMonthFirst:
+		layouts = monthFirstLayouts
+	default:
+		layouts = append(append([]string{}, monthFirstLayouts...), dayFirstLayouts...)
+	}
+	layouts = append(layouts, unambiguousLayouts...)
+
+	for _, layout := range layouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unable to parse date: %s", dateStr)
+}
+
+// dateOrderScan infers a file's date order from its data rows, one record
+// at a time. In numeric dates, a first field above 12 means day-first and a
+// second field above 12 means month-first.
+type dateOrderScan struct {
+	numeric, dayFirst, monthFirst int
+}
+
+func (s *dateOrderScan) add(record []string) {
+	for _, cell := range record {
+		match := numericDatePattern.FindStringSubmatch(stripTimeOfDay(cell))
+		if match == nil {
+			continue
+		}
+		s.numeric++
+		first, _ := strconv.Atoi(match[1])
+		second, _ := strconv.Atoi(match[2])
+		if first > 12 && second <= 12 {
+			s.dayFirst++
+		} else if second > 12 && first <= 12 {
+			s.monthFirst++
+		}
+	}
+}
+
+// result returns the inferred order. ambiguous is true when the file has
+// numeric dates but none decides the order (or they disagree); month-first
+// is assumed then.
+func (s *dateOrderScan) result() (order string, ambiguous bool) {
+	switch {
+	case s.numeric == 0:
+		return "", false
+	case s.dayFirst > 0 && s.monthFirst == 0:
+		return dateOrderDayFirst, false
+	case s.monthFirst > 0 && s.dayFirst == 0:
+		return dateOrderMonthFirst, false
+	default:
+		return dateOrderMonthFirst, true
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// nearDuplicateWindow is how far apart two charges with the same amount and
+// vendor can be and still be reported as a possible duplicate
+const nearDuplicateWindow = 2 * 24 * time.Hour
+
+// NearDuplicate pairs a newly imported transaction with an existing one that
+// has the same amount and vendor within nearDuplicateWindow
+type NearDuplicate struct {
+	TransactionID      string    `json:"transaction_id"`
+	Date               time.Time `json:"date"`
+	Vendor             string    `json:"vendor"`
+	Amount             Cents     `json:"amount"`
+	Card               string    `json:"card"`
+	ExistingID         string    `json:"existing_id"`
+	ExistingDate       time.Time `json:"existing_date"`
+	ExistingCard       string    `json:"existing_card"`
+	ExistingSourceFile string    `json:"existing_source_file"`
+}
+
+// createRemovedDuplicatesTable creates the tombstones of rows removed as
+// duplicates. Their fingerprints still count as imported, so uploading the
+// file again doesn't bring the rows back.
+func createRemovedDuplicatesTable() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS removed_duplicates (
+			fingerprint TEXT PRIMARY KEY,
+			source_file TEXT,
+			duplicate_of TEXT,
+			removed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`)
+	if err != nil {
+		return fmt.Errorf("error creating removed_duplicates table: %v", err)
+	}
+	return nil
+}
+
+// normalizeDescription reduces a bank description to lowercase letters and
+// digits separated by single spaces, so spacing and punctuation changes
+// between exports don't change the fingerprint
+func normalizeDescription(description string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(description) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteRune(r)
+			space = false
+		} else {
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// fingerprintPrefix marks fingerprints built with the current key. Rows whose
+// fingerprint lacks it are fingerprinted again at startup.
+const fingerprintPrefix = "v3:"
+
+// legacyVendorPrefixes are the processor prefixes vendor names were stripped
+// of before the raw description was stored
+var legacyVendorPrefixes = []string{"AplPay ", "TST* ", "SQC*", "GOOGLE *", "PAYPAL *"}
+
+// fingerprintName is the name a fingerprint is keyed on. Rows imported before
+// the raw description was stored only have the vendor name derived from it
+// back then: the description without one processor prefix or a trailing
+// state code, cut at 50 bytes. Rows with a description derive the same name
+// from it, so re-importing a statement matches the rows it created either way.
+func fingerprintName(tx Transaction) string {
+	if tx.Description == "" {
+		return normalizeDescription(tx.Vendor)
+	}
+
+	name := strings.TrimSpace(tx.Description)
+	for _, prefix := range legacyVendorPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			name = name[len(prefix):]
+			break
+		}
+	}
+	words := strings.Fields(name)
+	if len(words) > 1 {
+		if last := words[len(words)-1]; len(last) == 2 && strings.ToUpper(last) == last {
+			name = strings.Join(words[:len(words)-1], " ")
+		}
+	}
+	if len(name) > 50 {
+		name = name[:50]
+	}
+	return normalizeDescription(name)
+}
+
+// fingerprintKey is the part of the fingerprint shared by identical rows.
+// The same charge on two cards is two charges, so the card is part of it; a
+// statement uploaded again under another filename gets another card and is
+// reported as near-duplicates instead of being skipped.
+func fingerprintKey(tx Transaction) string {
+	return fmt.Sprintf("%s|%s|%s|%s", strings.ToLower(tx.Card), tx.Date.Format("2006-01-02"), tx.Amount, fingerprintName(tx))
+}
+
+func hashFingerprint(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return fingerprintPrefix + hex.EncodeToString(sum[:])
+}
+
+// assignFingerprints sets a deterministic fingerprint on every transaction.
+// Identical rows within one file get increasing occurrence indexes, so two
+// real $5 coffees on the same day both import, while uploading the same (or
+// an overlapping) statement again reproduces the same fingerprints. Rows with
+// a bank-assigned ID (OFX FITID, PayPal transaction ID) are keyed by that ID,
+// and keep their content fingerprint to match rows imported without the ID.
+func assignFingerprints(transactions []Transaction) {
+	occurrences := make(map[string]int)
+	for i := range transactions {
+		tx := &transactions[i]
+		key := fingerprintKey(*tx)
+		tx.Fingerprint = hashFingerprint(fmt.Sprintf("%s|%d", key, occurrences[key]))
+		occurrences[key]++
+
+		if tx.ExternalID != "" {
+			tx.contentFingerprint = tx.Fingerprint
+			tx.Fingerprint = hashFingerprint(fmt.Sprintf("%s|id:%s", strings.ToLower(tx.Card), tx.ExternalID))
+		}
+	}
+}
+
+// backfillFingerprints fingerprints transactions imported before fingerprints
+// existed, or before the current fingerprint key
+func backfillFingerprints() error {
+	rows, err := db.Query(`
+		SELECT id, date, vendor, amount_cents, card, COALESCE(external_id, ''), COALESCE(description, '')
+		FROM transactions
+		WHERE fingerprint IS NULL OR fingerprint NOT LIKE ?
+		ORDER BY rowid
+	`, fingerprintPrefix+"%")
+	if err != nil {
+		return fmt.Errorf("failed to query transactions: %v", err)
+	}
+
+	var transactions []Transaction
+	for rows.Next() {
+		var tx Transaction
+		if err := rows.Scan(&tx.ID, &tx.Date, &tx.Vendor, &tx.Amount, &tx.Card, &tx.ExternalID, &tx.Description); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan transaction: %v", err)
+		}
+		transactions = append(transactions, tx)
+	}
+	rows.Close()
+
+	if len(transactions) == 0 {
+		return nil
+	}
+
+	assignFingerprints(transactions)
+
+	for _, tx := range transactions {
+		if _, err := db.Exec("UPDATE OR IGNORE transactions SET fingerprint = ? WHERE id = ?", tx.Fingerprint, tx.ID); err != nil {
+			return fmt.Errorf("failed to update transaction %s: %v", tx.ID, err)
+		}
+	}
+
+	log.Printf("✅ Fingerprinted %d existing transactions", len(transactions))
+	return nil
+}
+
+// checkDuplicates compares parsed transactions with the database. It returns
+// the IDs of rows whose fingerprint is already stored or was removed as a
+// duplicate (these will be skipped) and the near-duplicates of the remaining
+// rows.
+func checkDuplicates(transactions []Transaction) (map[string]bool, []NearDuplicate, error) {
+	exact := make(map[string]bool)
+	var near []NearDuplicate
+
+	// The fingerprint index is partial, so the query repeats its condition
+	// for SQLite to use it
+	fingerprintStmt, err := db.Prepare(`
+		SELECT 1 FROM transactions WHERE fingerprint = ? AND fingerprint <> ''
+		UNION ALL
+		SELECT 1 FROM removed_duplicates WHERE fingerprint = ?
+		LIMIT 1
+	`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to prepare fingerprint check: %v", err)
+	}
+	defer fingerprintStmt.Close()
+
+	imported := func(fingerprint string) (bool, error) {
+		var found int
+		err := fingerprintStmt.QueryRow(fingerprint, fingerprint).Scan(&found)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	nearStmt, err := db.Prepare(`
+		SELECT id, date, card, source_file
+		FROM transactions
+		WHERE amount_cents = ? AND LOWER(vendor) = LOWER(?) AND date BETWEEN ? AND ?
+		ORDER BY date
+		LIMIT 1
+	`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to prepare near-duplicate check: %v", err)
+	}
+	defer nearStmt.Close()
+
+	for _, tx := range transactions {
+		found, err := imported(tx.Fingerprint)
+		if err == nil && !found && tx.contentFingerprint != "" {
+			found, err = imported(tx.contentFingerprint)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to check fingerprint: %v", err)
+		}
+		if found {
+			exact[tx.ID] = true
+			continue
+		}
+
+		var match NearDuplicate
+		err = nearStmt.QueryRow(tx.Amount, tx.Vendor, tx.Date.Add(-nearDuplicateWindow), tx.Date.Add(nearDuplicateWindow)).Scan(
+			&match.ExistingID, &match.ExistingDate, &match.ExistingCard, &match.ExistingSourceFile)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to check near duplicates: %v", err)
+		}
+
+		match.TransactionID = tx.ID
+		match.Date = tx.Date
+		match.Vendor = tx.Vendor
+		match.Amount = tx.Amount
+		match.Card = tx.Card
+		near = append(near, match)
+	}
+
+	return exact, near, nil
+}
+
+// getDuplicates lists imported transactions flagged as possible duplicates
+// alongside the transaction they resemble
+func getDuplicates(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT t.id, t.date, t.vendor, t.amount_cents, t.card, e.id, e.date, e.card, e.source_file
+		FROM transactions t
+		JOIN transactions e ON e.id = t.duplicate_of
+		WHERE t.duplicate_of <> ''
+		ORDER BY t.date DESC
+	`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		log.Printf("Error querying duplicates: %v", err)
+		http.Error(w, "Failed to fetch duplicates", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var duplicates []NearDuplicate
+	for rows.Next() {
+		var d NearDuplicate
+		err := rows.Scan(&d.TransactionID, &d.Date, &d.Vendor, &d.Amount, &d.Card,
+			&d.ExistingID, &d.ExistingDate, &d.ExistingCard, &d.ExistingSourceFile)
+		if err != nil {
+			log.Printf("Error scanning duplicate: %v", err)
+			continue
+		}
+		duplicates = append(duplicates, d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"duplicates": duplicates,
+		"count":      len(duplicates),
+	})
+}
+
+// resolveDuplicate either keeps a flagged transaction (clearing the flag) or
+// removes it as a true duplicate, leaving a tombstone (see removeDuplicate)
+func resolveDuplicate(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		TransactionID string `json:"transaction_id"`
+		Action        string `json:"action"` // "keep" or "remove"
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if request.TransactionID == "" {
+		http.Error(w, "transaction_id is required", http.StatusBadRequest)
+		return
+	}
+
+	var result sql.Result
+	var err error
+	switch request.Action {
+	case "keep":
+		result, err = db.Exec("UPDATE transactions SET duplicate_of = '' WHERE id = ? AND duplicate_of <> ''", request.TransactionID)
+	case "remove":
+		result, err = removeDuplicate(request.TransactionID)
+	default:
+		http.Error(w, "action must be keep or remove", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to resolve duplicate: %v", err)
+		http.Error(w, "Failed to resolve duplicate", http.StatusInternalServerError)
+		return
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		http.Error(w, "Flagged duplicate not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("🔁 Duplicate %s resolved: %s", request.TransactionID, request.Action)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":        true,
+		"message":        "Duplicate resolved",
+		"transaction_id": request.TransactionID,
+		"action":         request.Action,
+	})
+}
+
+// removeDuplicate deletes a flagged transaction and keeps its fingerprint in
+// removed_duplicates, so importing its file again doesn't bring it back
+func removeDuplicate(id string) (sql.Result, error) {
+	dbTx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer dbTx.Rollback()
+
+	_, err = dbTx.Exec(`
+		INSERT OR REPLACE INTO removed_duplicates (fingerprint, source_file, duplicate_of)
+		SELECT fingerprint, source_file, duplicate_of FROM transactions
+		WHERE id = ? AND duplicate_of <> '' AND fingerprint <> ''
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record removed duplicate: %v", err)
+	}
+
+	result, err := dbTx.Exec("DELETE FROM transactions WHERE id = ? AND duplicate_of <> ''", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete duplicate: %v", err)
+	}
+
+	if err := dbTx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit: %v", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFingerprints(t *testing.T) {
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	fingerprint := func(tx Transaction) string {
+		t.Helper()
+		transactions := []Transaction{tx}
+		assignFingerprints(transactions)
+		return transactions[0].Fingerprint
+	}
+
+	fresh := Transaction{Date: date, Amount: 1299, Card: "amex_march", Vendor: "UBER POSTMATE", Description: "AplPay UBER POSTMATEHTTPS://HELP.UBER.COCA"}
+
+	// The same charge on another card is another charge
+	otherCard := fresh
+	otherCard.Card = "Chase Export (1)"
+	if fingerprint(fresh) == fingerprint(otherCard) {
+		t.Error("identical charges on two cards share a fingerprint")
+	}
+
+	// Rows imported before the description was stored only have the vendor
+	// name derived from it back then
+	legacy := fresh
+	legacy.Description = ""
+	legacy.Vendor = "UBER POSTMATEHTTPS://HELP.UBER.COCA"
+	if fingerprint(fresh) != fingerprint(legacy) {
+		t.Error("a backfilled row fingerprints differently from a fresh import of it")
+	}
+
+	// A row keyed by its reference still matches the row imported before
+	// references were read
+	referenced := []Transaction{fresh}
+	referenced[0].ExternalID = "320240310123456789"
+	assignFingerprints(referenced)
+	if referenced[0].Fingerprint == fingerprint(fresh) || referenced[0].contentFingerprint != fingerprint(legacy) {
+		t.Error("a row with a reference isn't keyed by it, or lost its content fingerprint")
+	}
+
+	// Identical rows in one file are both kept
+	coffees := []Transaction{fresh, fresh}
+	assignFingerprints(coffees)
+	if coffees[0].Fingerprint == coffees[1].Fingerprint {
+		t.Error("identical rows in one file share a fingerprint")
+	}
+}
+
+// statementRows builds the rows of one upload of a statement
+func statementRows(fileID, card string) []Transaction {
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	var transactions []Transaction
+	for i, vendor := range []string{"GITHUB", "SHELL OIL"} {
+		transactions = append(transactions, Transaction{
+			ID:          fmt.Sprintf("%s-%d", fileID, i),
+			Date:        date,
+			Vendor:      vendor,
+			Description: vendor + " 12345",
+			Amount:      4000,
+			Card:        card,
+			Type:        "expense",
+			SourceFile:  fileID,
+		})
+	}
+	assignFingerprints(transactions)
+	return transactions
+}
+
+func TestCheckDuplicates(t *testing.T) {
+	openTestDB(t)
+	insertTransactions(t, statementRows("march", "amex_march"))
+
+	// The same upload again is skipped
+	exact, near, err := checkDuplicates(statementRows("again", "amex_march"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exact) != 2 || len(near) != 0 {
+		t.Errorf("re-upload: %d exact and %d near duplicates, want 2 and 0", len(exact), len(near))
+	}
+
+	// Under another filename (or on another card) the rows may be real
+	// charges, so they're reported for review rather than skipped
+	renamed := statementRows("renamed", "Amex Gold")
+	exact, near, err = checkDuplicates(renamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exact) != 0 || len(near) != 2 {
+		t.Fatalf("renamed upload: %d exact and %d near duplicates, want 0 and 2", len(exact), len(near))
+	}
+	if near[0].ExistingID != "march-0" || near[0].ExistingCard != "amex_march" {
+		t.Errorf("near duplicate = %+v, want the row from the first upload", near[0])
+	}
+
+	// A row removed as a duplicate stays removed when its file is uploaded again
+	renamed[0].DuplicateOf = near[0].ExistingID
+	insertTransactions(t, renamed)
+	if result, err := removeDuplicate(renamed[0].ID); err != nil {
+		t.Fatal(err)
+	} else if removed, _ := result.RowsAffected(); removed != 1 {
+		t.Fatalf("removed %d rows, want 1", removed)
+	}
+
+	exact, _, err = checkDuplicates(statementRows("renamed-again", "Amex Gold"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exact["renamed-again-0"] || !exact["renamed-again-1"] {
+		t.Errorf("exact = %v, want the removed row and the kept row skipped", exact)
+	}
+
+	// Deleting the file forgets its removed rows
+	if _, err := deleteFileTransactions("renamed"); err != nil {
+		t.Fatal(err)
+	}
+	exact, _, err = checkDuplicates(statementRows("renamed-again", "Amex Gold"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exact) != 0 {
+		t.Errorf("exact = %v after deleting the file, want none", exact)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types published on GET /events
+const (
+	EventImportStarted   = "import.started"
+	EventImportFinished  = "import.finished"
+	EventImportFailed    = "import.failed"
+	EventJobStarted      = "job.started"
+	EventJobFinished     = "job.finished" // Completed or cancelled
+	EventJobFailed       = "job.failed"
+	EventBatchClassified = "batch.classified"
+	EventRuleApplied     = "rule.applied"
+)
+
+const (
+	eventReplayBufferSize = 200 // Events kept for clients reconnecting with Last-Event-ID
+	eventSubscriberBuffer = 64
+	eventKeepAlive        = 15 * time.Second
+)
+
+// Event is one server-sent event. ID increases by one per event, so a
+// client that reconnects with Last-Event-ID gets what it missed.
+type Event struct {
+	ID   int64       `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// ImportEvent describes an upload being written to the database
+type ImportEvent struct {
+	FileID             string `json:"file_id"`
+	Filename           string `json:"filename"`
+	Source             string `json:"source"`
+	Format             string `json:"format,omitempty"`
+	TransactionsParsed int    `json:"transactions_parsed"`
+	DuplicatesSkipped  int    `json:"duplicates_skipped,omitempty"`
+	NearDuplicates     int    `json:"near_duplicates,omitempty"`
+	CategorizeJobID    string `json:"categorize_job_id,omitempty"`
+	Error              string `json:"error,omitempty"`
+}
+
+// ClassifiedTransaction is one transaction's new classification
+type ClassifiedTransaction struct {
+	ID            string `json:"id"`
+	Vendor        string `json:"vendor"`
+	Category      string `json:"category"`
+	ScheduleCLine int    `json:"schedule_c_line"`
+	Expensable    bool   `json:"expensable"`
+	Purpose       string `json:"purpose"`
+	ClassifiedBy  string `json:"classified_by"`
+}
+
+// BatchClassifiedEvent lists the transactions a job classified in one step
+// (the local classifier, the cache, or one LLM batch)
+type BatchClassifiedEvent struct {
+	JobID        string                  `json:"job_id"`
+	Transactions []ClassifiedTransaction `json:"transactions"`
+	Processed    int                     `json:"processed"` // So far in the job
+	Total        int                     `json:"total"`
+}
+
+// RuleAppliedEvent reports a vendor rule or alias changing existing transactions
+type RuleAppliedEvent struct {
+	RuleType       string   `json:"rule_type"` // "vendor_rule" or "vendor_alias"
+	Rule           string   `json:"rule"`      // Vendor or pattern matched
+	Category       string   `json:"category,omitempty"`
+	ScheduleCLine  int      `json:"schedule_c_line,omitempty"`
+	Vendor         string   `json:"vendor,omitempty"` // Canonical vendor, for aliases
+	Count          int      `json:"count"`
+	TransactionIDs []string `json:"transaction_ids,omitempty"`
+}
+
+// eventBroker fans events out to the connected clients. Publishing never
+// blocks: a client that falls a full buffer behind misses events, which it
+// can notice from the gap in IDs.
+type eventBroker struct {
+	mu          sync.Mutex
+	nextID      int64
+	recent      []Event // Last eventReplayBufferSize events, oldest first
+	subscribers map[chan Event]map[string]bool
+}
+
+var events = &eventBroker{subscribers: make(map[chan Event]map[string]bool)}
+
+// publishEvent sends an event to every subscriber interested in its type
+func publishEvent(eventType string, data interface{}) {
+	events.publish(eventType, data)
+}
+
+func (b *eventBroker) publish(eventType string, data interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	event := Event{ID: b.nextID, Type: eventType, Time: time.Now(), Data: data}
+
+	b.recent = append(b.recent, event)
+	if len(b.recent) > eventReplayBufferSize {
+		b.recent = b.recent[len(b.recent)-eventReplayBufferSize:]
+	}
+
+	for ch, types := range b.subscribers {
+		if !wantsEvent(types, eventType) {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// subscribe registers a client for the given types (all when empty) and
+// returns the buffered events after lastID
+func (b *eventBroker) subscribe(types map[string]bool, lastID int64) (chan Event, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Event, eventSubscriberBuffer)
+	b.subscribers[ch] = types
+
+	var missed []Event
+	if lastID > 0 {
+		for _, event := range b.recent {
+			if event.ID > lastID && wantsEvent(types, event.Type) {
+				missed = append(missed, event)
+			}
+		}
+	}
+	return ch, missed
+}
+
+func (b *eventBroker) unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, ch)
+}
+
+// wantsEvent matches exact types and prefixes such as "job" for every job.* event
+func wantsEvent(types map[string]bool, eventType string) bool {
+	if len(types) == 0 || types[eventType] {
+		return true
+	}
+	prefix, _, _ := strings.Cut(eventType, ".")
+	return types[prefix]
+}
+
+func writeEvent(w http.ResponseWriter, event Event) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+// streamEvents serves GET /events as a server-sent event stream. ?types=
+// takes a comma-separated list of event types or prefixes ("job,import").
+// A reconnecting client's Last-Event-ID header (or ?last_event_id=) replays
+// recent events it missed.
+func streamEvents(w http.ResponseWriter, r *http.Request) {
+	types := make(map[string]bool)
+	for _, name := range strings.Split(r.URL.Query().Get("types"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			types[name] = true
+		}
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	var lastID int64
+	if lastEventID != "" {
+		id, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	}
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Don't let a proxy hold events back
+	w.WriteHeader(http.StatusOK)
+
+	ch, missed := events.subscribe(types, lastID)
+	defer events.unsubscribe(ch)
+
+	// Tell the browser how long to wait before reconnecting
+	fmt.Fprintf(w, "retry: 3000\n\n")
+	for _, event := range missed {
+		if err := writeEvent(w, event); err != nil {
+			return
+		}
+	}
+	if err := controller.Flush(); err != nil {
+		log.Printf("Event stream can't be flushed: %v", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-ch:
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprintf(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
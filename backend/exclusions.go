@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ExclusionRule identifies card payments and transfers between the user's own
+// accounts, which are neither income nor expenses. Matching rows are still
+// imported, as type "transfer", so they can be reviewed and reinstated.
+type ExclusionRule struct {
+	ID        int    `json:"id" db:"id"`
+	Pattern   string `json:"pattern" db:"pattern"`
+	MatchType string `json:"match_type" db:"match_type"` // "keyword" (case-insensitive substring) or "regex"
+	Card      string `json:"card" db:"card"`             // Only applies to this card; empty = every card
+	Enabled   bool   `json:"enabled" db:"enabled"`
+	CreatedAt string `json:"created_at" db:"created_at"`
+
+	regex *regexp.Regexp
+}
+
+// defaultExclusionKeywords seed the rules table on first start. Zelle only
+// matches outgoing payments; "Zelle payment from ..." is usually a client.
+var defaultExclusionKeywords = []string{
+	"online payment",
+	"payment thank you",
+	"payment - thank you",
+	"autopay",
+	"automatic payment",
+	"paypal transfer",
+	"venmo payment",
+	"zelle payment to",
+	"wire transfer",
+	"transfer to",
+	"transfer from",
+}
+
+// createExclusionRulesTable creates the exclusion_rules table, seeding it with
+// the default keywords the first time so deleted defaults stay deleted
+func createExclusionRulesTable() error {
+	var existing int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'exclusion_rules'").Scan(&existing)
+	if err != nil {
+		return fmt.Errorf("error checking exclusion_rules table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS exclusion_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			pattern TEXT NOT NULL,
+			match_type TEXT NOT NULL DEFAULT 'keyword',
+			card TEXT DEFAULT '',
+			enabled BOOLEAN DEFAULT TRUE,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`)
+	if err != nil {
+		return fmt.Errorf("error creating exclusion_rules table: %v", err)
+	}
+
+	if existing > 0 {
+		return nil
+	}
+
+	for _, keyword := range defaultExclusionKeywords {
+		_, err := db.Exec("INSERT INTO exclusion_rules (pattern, match_type) VALUES (?, 'keyword')", keyword)
+		if err != nil {
+			return fmt.Errorf("error seeding exclusion rules: %v", err)
+		}
+	}
+
+	log.Printf("✅ Seeded %d default payment/transfer exclusion rules", len(defaultExclusionKeywords))
+	return nil
+}
+
+func validateExclusionRule(rule *ExclusionRule) error {
+	rule.Pattern = strings.TrimSpace(rule.Pattern)
+	rule.Card = strings.TrimSpace(rule.Card)
+	if rule.Pattern == "" {
+		return fmt.Errorf("pattern is required")
+	}
+	if rule.MatchType == "" {
+		rule.MatchType = "keyword"
+	}
+
+	switch rule.MatchType {
+	case "keyword":
+	case "regex":
+		if _, err := regexp.Compile("(?i)" + rule.Pattern); err != nil {
+			return fmt.Errorf("invalid regex: %v", err)
+		}
+	default:
+		return fmt.Errorf("match_type must be keyword or regex")
+	}
+	return nil
+}
+
+// loadExclusionRules returns the enabled rules, with regex patterns compiled
+func loadExclusionRules() ([]ExclusionRule, error) {
+	rows, err := db.Query(`
+		SELECT id, pattern, match_type, COALESCE(card, ''), enabled, created_at
+		FROM exclusion_rules
+		WHERE enabled = true
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query exclusion rules: %v", err)
+	}
+	defer rows.Close()
+
+	var rules []ExclusionRule
+	for rows.Next() {
+		var rule ExclusionRule
+		if err := rows.Scan(&rule.ID, &rule.Pattern, &rule.MatchType, &rule.Card, &rule.Enabled, &rule.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan exclusion rule: %v", err)
+		}
+
+		if rule.MatchType == "regex" {
+			rule.regex, err = regexp.Compile("(?i)" + rule.Pattern)
+			if err != nil {
+				log.Printf("Skipping exclusion rule %d with invalid regex %q: %v", rule.ID, rule.Pattern, err)
+				continue
+			}
+		} else {
+			rule.Pattern = strings.ToLower(rule.Pattern)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// matchExclusionRule returns the first rule that matches the transaction's
+// description on its card, or nil
+func matchExclusionRule(rules []ExclusionRule, transaction Transaction) *ExclusionRule {
+	description := transaction.Description
+	if description == "" {
+		description = transaction.Vendor
+	}
+
+	for i, rule := range rules {
+		if rule.Card != "" && !strings.EqualFold(rule.Card, transaction.Card) {
+			continue
+		}
+		if rule.regex != nil {
+			if rule.regex.MatchString(description) {
+				return &rules[i]
+			}
+		} else if strings.Contains(strings.ToLower(description), rule.Pattern) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// excludeTransfer applies the exclusion rules to a parsed row. Rows the
+// format itself flagged as payments (isPayment) and rows matching a rule
+// become transfers; the returned reason is empty for everything else.
+func excludeTransfer(rules []ExclusionRule, transaction *Transaction, isPayment bool) string {
+	reason := ""
+	if isPayment {
+		reason = "payment or transfer"
+	} else if rule := matchExclusionRule(rules, *transaction); rule != nil {
+		reason = fmt.Sprintf("matched exclusion rule %d (%s %q)", rule.ID, rule.MatchType, rule.Pattern)
+	}
+
+	if reason != "" {
+		transaction.Type = "transfer"
+		transaction.Expensable = false
+	}
+	return reason
+}
+
+// createExclusionRule adds a rule, or updates the rule with the given id
+func createExclusionRule(w http.ResponseWriter, r *http.Request) {
+	rule := ExclusionRule{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateExclusionRule(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if rule.ID > 0 {
+		result, err := db.Exec("UPDATE exclusion_rules SET pattern = ?, match_type = ?, card = ?, enabled = ? WHERE id = ?",
+			rule.Pattern, rule.MatchType, rule.Card, rule.Enabled, rule.ID)
+		if err != nil {
+			log.Printf("Failed to update exclusion rule: %v", err)
+			http.Error(w, "Failed to save exclusion rule", http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			http.Error(w, "Exclusion rule not found", http.StatusNotFound)
+			return
+		}
+	} else {
+		result, err := db.Exec("INSERT INTO exclusion_rules (pattern, match_type, card, enabled) VALUES (?, ?, ?, ?)",
+			rule.Pattern, rule.MatchType, rule.Card, rule.Enabled)
+		if err != nil {
+			log.Printf("Failed to create exclusion rule: %v", err)
+			http.Error(w, "Failed to save exclusion rule", http.StatusInternalServerError)
+			return
+		}
+		id, _ := result.LastInsertId()
+		rule.ID = int(id)
+	}
+
+	db.QueryRow("SELECT created_at FROM exclusion_rules WHERE id = ?", rule.ID).Scan(&rule.CreatedAt)
+
+	log.Printf("🚫 Saved exclusion rule: %s %q (ID: %d)", rule.MatchType, rule.Pattern, rule.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Exclusion rule saved successfully",
+		"rule":    rule,
+	})
+}
+
+func getExclusionRules(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`
+		SELECT id, pattern, match_type, COALESCE(card, ''), enabled, created_at
+		FROM exclusion_rules
+		ORDER BY id
+	`)
+	if err != nil {
+		log.Printf("Error querying exclusion rules: %v", err)
+		http.Error(w, "Failed to fetch exclusion rules", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var rules []ExclusionRule
+	for rows.Next() {
+		var rule ExclusionRule
+		if err := rows.Scan(&rule.ID, &rule.Pattern, &rule.MatchType, &rule.Card, &rule.Enabled, &rule.CreatedAt); err != nil {
+			log.Printf("Error scanning exclusion rule: %v", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"rules":   rules,
+		"count":   len(rules),
+	})
+}
+
+func deleteExclusionRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM exclusion_rules WHERE id = ?", id)
+	if err != nil {
+		log.Printf("Failed to delete exclusion rule: %v", err)
+		http.Error(w, "Failed to delete exclusion rule", http.StatusInternalServerError)
+		return
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		http.Error(w, "Exclusion rule not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Exclusion rule deleted successfully",
+	})
+}
+
+// reinstateTransaction turns an excluded transfer back into income or an
+// expense. Without an explicit type it takes the type its upload would have
+// given it.
+func reinstateTransaction(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		TransactionID string `json:"transaction_id"`
+		Type          string `json:"type,omitempty"` // "income", "expense" or "uncategorized"
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if request.TransactionID == "" {
+		http.Error(w, "Transaction ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var transaction Transaction
+	var source string
+	err := db.QueryRow(`
+		SELECT t.id, t.amount_cents, COALESCE(f.source, '')
+		FROM transactions t
+		LEFT JOIN csv_files f ON f.id = t.source_file
+		WHERE t.id = ? AND t.type = 'transfer'
+	`, request.TransactionID).Scan(&transaction.ID, &transaction.Amount, &source)
+	if err != nil {
+		http.Error(w, "Transfer not found", http.StatusNotFound)
+		return
+	}
+
+	transaction.Type = request.Type
+	if transaction.Type == "" {
+		switch source {
+		case "income":
+			transaction.Type = "income"
+		case "expenses":
+			transaction.Type = "expense"
+		default:
+			transaction.Type = "uncategorized"
+		}
+	}
+	if transaction.Type != "income" && transaction.Type != "expense" && transaction.Type != "uncategorized" {
+		http.Error(w, "type must be income, expense or uncategorized", http.StatusBadRequest)
+		return
+	}
+
+	transaction.Expensable = (transaction.Amount > 0 && transaction.Type == "expense")
+	markRefund(&transaction)
+
+	_, err = db.Exec("UPDATE transactions SET type = ?, expensable = ? WHERE id = ?",
+		transaction.Type, transaction.Expensable, transaction.ID)
+	if err != nil {
+		log.Printf("Failed to reinstate transaction: %v", err)
+		http.Error(w, "Failed to reinstate transaction", http.StatusInternalServerError)
+		return
+	}
+
+	if transaction.Type == "refund" {
+		if _, err := linkRefunds(); err != nil {
+			log.Printf("Error linking refunds: %v", err)
+		}
+	}
+
+	log.Printf("↪️ Reinstated transfer %s as %s", transaction.ID, transaction.Type)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Transaction reinstated successfully",
+		"type":    transaction.Type,
+	})
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// FileSummary is an uploaded file together with what it currently
+// contributes to the transactions table
+type FileSummary struct {
+	CSVFile
+	TransactionCount int    `json:"transaction_count"`
+	FirstDate        string `json:"first_date"` // YYYY-MM-DD, empty when no transactions remain
+	LastDate         string `json:"last_date"`
+	ExpenseTotal     Cents  `json:"expense_total"`
+	IncomeTotal      Cents  `json:"income_total"`
+	Total            Cents  `json:"total"`
+}
+
+const fileSummaryQuery = `
+	SELECT f.id, f.filename, f.uploaded, COALESCE(f.source, ''), COALESCE(f.path, ''), COALESCE(f.format, ''),
+	       COALESCE(f.transactions_parsed, 0), COALESCE(f.payments_excluded, 0),
+	       COALESCE(f.rows_rejected, 0), COALESCE(f.duplicates_skipped, 0),
+	       COALESCE(f.date_order, ''), COALESCE(f.date_order_ambiguous, false), COALESCE(f.business_miles, 0),
+	       COUNT(t.id), MIN(t.date), MAX(t.date),
+	       COALESCE(SUM(CASE WHEN t.type = 'expense' THEN t.amount_cents ELSE 0 END), 0),
+	       COALESCE(SUM(CASE WHEN t.type = 'income' THEN t.amount_cents ELSE 0 END), 0),
+	       COALESCE(SUM(t.amount_cents), 0)
+	FROM csv_files f
+	LEFT JOIN transactions t ON t.source_file = f.id
+`
+
+func scanFileSummary(scanner interface{ Scan(...interface{}) error }) (*FileSummary, error) {
+	var f FileSummary
+	var firstDate, lastDate sql.NullString
+	err := scanner.Scan(&f.ID, &f.Filename, &f.Uploaded, &f.Source, &f.Path, &f.Format,
+		&f.TransactionsParsed, &f.PaymentsExcluded, &f.RowsRejected, &f.DuplicatesSkipped,
+		&f.DateOrder, &f.DateOrderAmbiguous, &f.BusinessMiles,
+		&f.TransactionCount, &firstDate, &lastDate, &f.ExpenseTotal, &f.IncomeTotal, &f.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	// MIN/MAX come back as SQLite's stored text, e.g. "2024-01-05 00:00:00+00:00"
+	if len(firstDate.String) >= 10 {
+		f.FirstDate = firstDate.String[:10]
+	}
+	if len(lastDate.String) >= 10 {
+		f.LastDate = lastDate.String[:10]
+	}
+	return &f, nil
+}
+
+// getFiles lists uploaded files, newest first
+func getFiles(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(fileSummaryQuery + " GROUP BY f.id ORDER BY f.uploaded DESC")
+	if err != nil {
+		log.Printf("Error querying files: %v", err)
+		http.Error(w, "Failed to fetch files", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var files []FileSummary
+	for rows.Next() {
+		f, err := scanFileSummary(rows)
+		if err != nil {
+			log.Printf("Error scanning file: %v", err)
+			continue
+		}
+		files = append(files, *f)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"files":   files,
+		"count":   len(files),
+	})
+}
+
+// getFile returns one uploaded file with its row counts, date range and totals
+func getFile(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	f, err := scanFileSummary(db.QueryRow(fileSummaryQuery+" WHERE f.id = ? GROUP BY f.id", id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying file %s: %v", id, err)
+		http.Error(w, "Failed to fetch file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"file":    f,
+	})
+}
+
+// deleteFile rolls back an upload: its transactions and file record are
+// removed in one database transaction, then the stored copy is deleted
+func deleteFile(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var path string
+	err := db.QueryRow("SELECT COALESCE(path, '') FROM csv_files WHERE id = ?", id).Scan(&path)
+	if err == sql.ErrNoRows {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying file %s: %v", id, err)
+		http.Error(w, "Failed to delete file", http.StatusInternalServerError)
+		return
+	}
+
+	deleted, err := deleteFileTransactions(id)
+	if err != nil {
+		log.Printf("Error deleting file %s: %v", id, err)
+		http.Error(w, "Failed to delete file", http.StatusInternalServerError)
+		return
+	}
+
+	for _, p := range storedUploadPaths(id, path) {
+		removeUpload(p)
+	}
+
+	// Payouts that were matched to this file's deposits may match another
+	if _, err := reconcilePayouts(); err != nil {
+		log.Printf("Error reconciling payouts: %v", err)
+	}
+
+	log.Printf("🗑️ File %s deleted with %d transactions", id, deleted)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":              true,
+		"message":              "File deleted successfully",
+		"file_id":              id,
+		"transactions_deleted": deleted,
+	})
+}
+
+// deleteFileTransactions removes the transactions imported from a file and its
+// csv_files row, returning how many transactions were deleted
+func deleteFileTransactions(fileID string) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Rows from other files flagged against these ones no longer have a match
+	_, err = tx.Exec(`
+		UPDATE transactions SET duplicate_of = ''
+		WHERE duplicate_of IN (SELECT id FROM transactions WHERE source_file = ?)
+	`, fileID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to clear duplicate flags: %v", err)
+	}
+
+	// Refunds and processor fees linked to these rows fall back to their own classification
+	_, err = tx.Exec(`
+		UPDATE transactions SET refund_of = ''
+		WHERE refund_of IN (SELECT id FROM transactions WHERE source_file = ?)
+	`, fileID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to clear refund links: %v", err)
+	}
+	_, err = tx.Exec(`
+		UPDATE transactions SET fee_of = ''
+		WHERE fee_of IN (SELECT id FROM transactions WHERE source_file = ?)
+	`, fileID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to clear fee links: %v", err)
+	}
+
+	if err := unlinkFilePayouts(tx, fileID); err != nil {
+		return 0, err
+	}
+
+	// Mileage imported with the file comes off the vehicle deduction
+	var businessMiles int
+	if err := tx.QueryRow("SELECT COALESCE(business_miles, 0) FROM csv_files WHERE id = ?", fileID).Scan(&businessMiles); err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to read file mileage: %v", err)
+	}
+	if businessMiles > 0 {
+		if err := addBusinessMiles(tx, -businessMiles); err != nil {
+			return 0, err
+		}
+	}
+
+	result, err := tx.Exec("DELETE FROM transactions WHERE source_file = ?", fileID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete transactions: %v", err)
+	}
+	deleted, _ := result.RowsAffected()
+
+	// Rows removed as duplicates import again if the file is uploaded again
+	if _, err := tx.Exec("DELETE FROM removed_duplicates WHERE source_file = ?", fileID); err != nil {
+		return 0, fmt.Errorf("failed to delete removed duplicates: %v", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM csv_files WHERE id = ?", fileID); err != nil {
+		return 0, fmt.Errorf("failed to delete file record: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit: %v", err)
+	}
+	return deleted, nil
+}
+
+// removeUpload deletes a stored upload, ignoring files that are already gone
+func removeUpload(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing upload %s: %v", path, err)
+	}
+}
+
+// storedUploadPaths returns the stored copy of a csv_files record. Files
+// uploaded before the path was recorded are named "<id>_<name>".
+func storedUploadPaths(id, path string) []string {
+	if path != "" {
+		return []string{path}
+	}
+	paths, _ := filepath.Glob(filepath.Join("uploads", id+"_*"))
+	return paths
+}
+
+// removeOrphanUploads deletes files in uploads/ that belong to neither a
+// csv_files record nor a pending preview. It only runs at startup: while the
+// server is handling requests, an upload's file is stored before its record
+// or preview exists.
+func removeOrphanUploads() {
+	entries, err := os.ReadDir("uploads")
+	if err != nil {
+		log.Printf("Error reading uploads directory: %v", err)
+		return
+	}
+
+	keep := make(map[string]bool)
+	rows, err := db.Query("SELECT id, COALESCE(path, '') FROM csv_files")
+	if err != nil {
+		log.Printf("Error querying files: %v", err)
+		return
+	}
+	for rows.Next() {
+		var id, path string
+		if err := rows.Scan(&id, &path); err != nil {
+			rows.Close()
+			log.Printf("Error scanning file: %v", err)
+			return
+		}
+		keep[filepath.Base(path)] = true
+		keep[id] = true // Files uploaded before the path was recorded are named "<id>_<name>"
+	}
+	rows.Close()
+
+	pendingUploadsMu.Lock()
+	for _, pending := range pendingUploads {
+		keep[filepath.Base(pending.TempPath)] = true
+	}
+	pendingUploadsMu.Unlock()
+
+	removed := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		id, _, _ := strings.Cut(name, "_")
+		if entry.IsDir() || keep[name] || keep[id] {
+			continue
+		}
+		removeUpload(filepath.Join("uploads", name))
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("🧹 Removed %d orphaned uploads", removed)
+	}
+}
@@ -0,0 +1,932 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FormatParser knows how to recognize and read one bank's CSV export.
+// Adding a bank means implementing this interface and registering it in
+// formatParsers; parseCSVFile picks the parser with the highest Detect score.
+type FormatParser interface {
+	// Name identifies the format, e.g. "chase"
+	Name() string
+	// Detect scores how well the header row matches this format (0 = no match)
+	Detect(headers []string) int
+	// Parse fills in the prepared transaction from one record. Returning
+	// isPayment=true marks the row as a card payment or transfer by the
+	// format's own type column; the transaction is still returned so it can
+	// be stored as a transfer. Keyword exclusions are applied afterwards.
+	Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error)
+}
+
+// headerlessFormat is implemented by parsers for exports without a header
+// row, so the first record is parsed as data instead of skipped.
+type headerlessFormat interface {
+	Headerless() bool
+}
+
+// raggedFormat is implemented by formats whose rows may be shorter than the
+// header row, so short rows aren't rejected as malformed
+type raggedFormat interface {
+	Ragged() bool
+}
+
+// fileFormat is implemented by formats that need earlier rows to read later
+// ones, such as tax summaries grouped under section headings. Each file is
+// parsed with its own copy from newFile.
+type fileFormat interface {
+	newFile() FormatParser
+}
+
+// mileageFormat is implemented by driver exports. BusinessMiles is read once
+// every row of the file has been parsed.
+type mileageFormat interface {
+	BusinessMiles() float64
+}
+
+// errSkipRecord is returned by Parse for rows that hold no transaction, such
+// as section headings, totals and mileage lines. They aren't reported as
+// rejected.
+var errSkipRecord = errors.New("not a transaction row")
+
+// formatParsers is the registry of known CSV formats. genericFormat always
+// scores 1, so it's only used when nothing more specific matches.
+var formatParsers = []FormatParser{
+	chaseFormat{},
+	amexFormat{},
+	capitalOneFormat{},
+	citiFormat{},
+	discoverFormat{},
+	bankOfAmericaFormat{},
+	wellsFargoFormat{},
+	usBankFormat{},
+	appleCardFormat{},
+	payPalFormat{},
+	stripeFormat{},
+	squareFormat{},
+	&driverSummaryFormat{},
+	&doorDashFormat{},
+	etsyOrdersFormat{},
+	etsyStatementFormat{},
+	amazonSettlementFormat{},
+	genericFormat{},
+}
+
+// detectCSVFormat returns the registered parser that best matches the headers
+func detectCSVFormat(headers []string) FormatParser {
+	var best FormatParser = genericFormat{}
+	bestScore := 0
+
+	for _, parser := range formatParsers {
+		if score := parser.Detect(headers); score > bestScore {
+			best = parser
+			bestScore = score
+		}
+	}
+
+	return best
+}
+
+// columnMapper is implemented by parsers that know which columns they read.
+// The rest of each row is kept on the transaction as Extra so nothing in the
+// export is lost.
+type columnMapper interface {
+	MappedColumns() []string // Normalized header names
+}
+
+// ExtraFields holds source columns no Transaction field covers, keyed by
+// header. It is stored as a JSON object.
+type ExtraFields map[string]string
+
+func (e ExtraFields) Value() (driver.Value, error) {
+	if len(e) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(map[string]string(e))
+	return string(data), err
+}
+
+func (e *ExtraFields) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("unsupported extra fields value %T", src)
+	}
+
+	*e = nil
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, (*map[string]string)(e))
+}
+
+// extraColumns returns the non-empty cells whose header isn't in mapped
+func extraColumns(record []string, headers []string, mapped []string) ExtraFields {
+	skip := make(map[string]bool)
+	for _, name := range mapped {
+		skip[name] = true
+	}
+
+	extra := make(ExtraFields)
+	for i, header := range headers {
+		if i >= len(record) || skip[normalizeHeader(header)] {
+			continue
+		}
+		if value := strings.TrimSpace(record[i]); value != "" {
+			extra[strings.TrimSpace(strings.TrimPrefix(header, "\ufeff"))] = value
+		}
+	}
+	if len(extra) == 0 {
+		return nil
+	}
+	return extra
+}
+
+// joinLines flattens a multi-line cell such as Amex's "AUSTIN\nTX" to "AUSTIN, TX"
+func joinLines(value string) string {
+	var parts []string
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			parts = append(parts, line)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func isHeaderless(parser FormatParser) bool {
+	h, ok := parser.(headerlessFormat)
+	return ok && h.Headerless()
+}
+
+func isRagged(parser FormatParser) bool {
+	r, ok := parser.(raggedFormat)
+	return ok && r.Ragged()
+}
+
+// normalizeHeader lowercases and trims a header cell, dropping any UTF-8 BOM
+func normalizeHeader(header string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(header, "\ufeff")))
+}
+
+// matchHeaders scores 10 points per required header when all of them are
+// present (case-insensitive), and 0 otherwise
+func matchHeaders(headers []string, required ...string) int {
+	present := make(map[string]bool)
+	for _, header := range headers {
+		present[normalizeHeader(header)] = true
+	}
+
+	for _, name := range required {
+		if !present[name] {
+			return 0
+		}
+	}
+	return len(required) * 10
+}
+
+// csvRow gives name-based access to the cells of one record
+type csvRow struct {
+	record  []string
+	columns map[string]int
+}
+
+func newCSVRow(record []string, headers []string) csvRow {
+	columns := make(map[string]int)
+	for i, header := range headers {
+		name := normalizeHeader(header)
+		if _, exists := columns[name]; !exists {
+			columns[name] = i
+		}
+	}
+	return csvRow{record: record, columns: columns}
+}
+
+// get returns the trimmed value of the first named column that exists
+func (r csvRow) get(names ...string) string {
+	for _, name := range names {
+		if idx, ok := r.columns[name]; ok && idx < len(r.record) {
+			return strings.TrimSpace(r.record[idx])
+		}
+	}
+	return ""
+}
+
+// parseAmountField parses an amount cell; empty cells are treated as 0
+func parseAmountField(value string) (Cents, error) {
+	return parseAmountFieldWith(value, "")
+}
+
+// parseAmountFieldWith is parseAmountField with a known decimal separator
+func parseAmountFieldWith(value, decimalSeparator string) (Cents, error) {
+	if strings.TrimSpace(value) == "" {
+		return 0, nil
+	}
+	return parseMoney(value, decimalSeparator)
+}
+
+// accountAmount converts an amount signed from the bank account's point of
+// view (withdrawals negative). Expense rows are stored positive like card
+// charges; income uploads keep deposits positive.
+func accountAmount(amount Cents, transaction Transaction) Cents {
+	if transaction.Type == "income" {
+		return amount
+	}
+	return -amount
+}
+
+// platformFee turns a row into a Line 10 "Commissions and fees" expense,
+// whatever the upload's source. fee keeps its sign; the caller's markRefund
+// turns a negative fee (a credit) into a refund.
+func platformFee(transaction *Transaction, vendor string, fee Cents) {
+	transaction.Type = "expense"
+	transaction.Vendor = vendor
+	transaction.Amount = fee
+	transaction.Category = "Commissions and fees"
+	transaction.ScheduleCLine = 10
+}
+
+// platformSale turns a row into business income, whatever the upload's
+// source. amount is signed from the seller's side: sales positive, refunds
+// to customers negative, which go to Line 2.
+func platformSale(transaction *Transaction, amount Cents) {
+	transaction.Type = "income"
+	transaction.Amount = amount
+	transaction.Expensable = true
+}
+
+// finishTransaction applies the defaults every parser sets once the amount is
+// known. Income counts toward Line 1 once classified, unless the parser
+// already knows it is business income (platformSale).
+func finishTransaction(transaction *Transaction) {
+	if transaction.Category == "" {
+		transaction.Category = "uncategorized"
+	}
+	transaction.Purpose = ""
+	if transaction.Type != "income" {
+		transaction.Expensable = (transaction.Amount > 0 && transaction.Type == "expense")
+	}
+}
+
+// Chase: Status,Date,Description,Debit,Credit
+type chaseFormat struct{}
+
+func (chaseFormat) Name() string { return "chase" }
+
+func (chaseFormat) Detect(headers []string) int {
+	headerStr := strings.ToLower(strings.Join(headers, ","))
+	if strings.Contains(headerStr, "status") && strings.Contains(headerStr, "debit") && strings.Contains(headerStr, "credit") {
+		return 30
+	}
+	return 0
+}
+
+func (chaseFormat) MappedColumns() []string {
+	return []string{"date", "description", "debit", "credit"}
+}
+
+func (chaseFormat) Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
+	return parseChaseRecord(record, headers, transaction)
+}
+
+// Amex: Date,Description,Amount,Extended Details,...
+type amexFormat struct{}
+
+func (amexFormat) Name() string { return "amex" }
+
+func (amexFormat) Detect(headers []string) int {
+	headerStr := strings.ToLower(strings.Join(headers, ","))
+	if strings.Contains(headerStr, "amount") && strings.Contains(headerStr, "extended details") {
+		return 20
+	}
+	return 0
+}
+
+func (amexFormat) MappedColumns() []string {
+	return []string{"date", "description", "amount", "category", "reference", "address", "city/state", "zip code"}
+}
+
+func (amexFormat) Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
+	return parseAmexRecord(record, headers, transaction)
+}
+
+// Generic: guesses date, description and amount columns by name
+type genericFormat struct{}
+
+func (genericFormat) Name() string { return "generic" }
+
+func (genericFormat) Detect(headers []string) int { return 1 }
+
+func (genericFormat) Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
+	return parseGenericRecord(record, headers, transaction)
+}
+
+// Capital One: Transaction Date,Posted Date,Card No.,Description,Category,Debit,Credit
+type capitalOneFormat struct{}
+
+func (capitalOneFormat) Name() string { return "capital_one" }
+
+func (capitalOneFormat) Detect(headers []string) int {
+	return matchHeaders(headers, "transaction date", "posted date", "card no.", "description", "debit", "credit")
+}
+
+func (capitalOneFormat) MappedColumns() []string {
+	return []string{"transaction date", "posted date", "description", "category", "debit", "credit"}
+}
+
+func (capitalOneFormat) Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
+	row := newCSVRow(record, headers)
+
+	date, err := parseDateInOrder(row.get("transaction date", "posted date"), transaction.dateOrder)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid date: %v", err)
+	}
+	transaction.Date = date
+
+	description := row.get("description")
+	transaction.Description = description
+	transaction.Vendor = extractVendorName(description)
+
+	// Both columns hold positive values: Debit for charges, Credit for payments/refunds
+	debit, err := parseAmountField(row.get("debit"))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid debit: %v", err)
+	}
+	credit, err := parseAmountField(row.get("credit"))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid credit: %v", err)
+	}
+	transaction.Amount = debit - credit
+
+	if category := row.get("category"); category != "" {
+		transaction.Category = category
+	}
+
+	finishTransaction(&transaction)
+	return &transaction, false, nil
+}
+
+// Citi: Status,Date,Description,Debit,Credit,Member Name
+type citiFormat struct{}
+
+func (citiFormat) Name() string { return "citi" }
+
+func (citiFormat) Detect(headers []string) int {
+	return matchHeaders(headers, "status", "date", "description", "debit", "credit", "member name")
+}
+
+func (citiFormat) MappedColumns() []string {
+	return []string{"date", "description", "debit", "credit"}
+}
+
+func (citiFormat) Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
+	row := newCSVRow(record, headers)
+
+	date, err := parseDateInOrder(row.get("date"), transaction.dateOrder)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid date: %v", err)
+	}
+	transaction.Date = date
+
+	description := row.get("description")
+	transaction.Description = description
+	transaction.Vendor = extractVendorName(description)
+
+	debit, err := parseAmountField(row.get("debit"))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid debit: %v", err)
+	}
+	credit, err := parseAmountField(row.get("credit"))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid credit: %v", err)
+	}
+	// Citi writes credits as negative numbers, older exports as positive
+	transaction.Amount = debit - credit.Abs()
+
+	finishTransaction(&transaction)
+	return &transaction, false, nil
+}
+
+// Discover: Trans. Date,Post Date,Description,Amount,Category
+type discoverFormat struct{}
+
+func (discoverFormat) Name() string { return "discover" }
+
+func (discoverFormat) Detect(headers []string) int {
+	return matchHeaders(headers, "trans. date", "post date", "description", "amount", "category")
+}
+
+func (discoverFormat) MappedColumns() []string {
+	return []string{"trans. date", "post date", "description", "amount", "category"}
+}
+
+func (discoverFormat) Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
+	row := newCSVRow(record, headers)
+
+	date, err := parseDateInOrder(row.get("trans. date", "post date"), transaction.dateOrder)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid date: %v", err)
+	}
+	transaction.Date = date
+
+	description := row.get("description")
+	transaction.Description = description
+	transaction.Vendor = extractVendorName(description)
+
+	// Charges are positive, payments and credits negative
+	amount, err := parseAmountField(row.get("amount"))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid amount: %v", err)
+	}
+	transaction.Amount = amount
+
+	if category := row.get("category"); category != "" {
+		transaction.Category = category
+	}
+
+	finishTransaction(&transaction)
+	return &transaction, false, nil
+}
+
+// Bank of America checking: Date,Description,Amount,Running Bal.
+// Bank of America credit card: Posted Date,Reference Number,Payee,Address,Amount
+type bankOfAmericaFormat struct{}
+
+func (bankOfAmericaFormat) Name() string { return "bank_of_america" }
+
+func (bankOfAmericaFormat) Detect(headers []string) int {
+	checking := matchHeaders(headers, "date", "description", "amount", "running bal.")
+	card := matchHeaders(headers, "posted date", "reference number", "payee", "address", "amount")
+	if card > checking {
+		return card
+	}
+	return checking
+}
+
+func (bankOfAmericaFormat) MappedColumns() []string {
+	return []string{"date", "posted date", "description", "payee", "amount", "reference number", "address"}
+}
+
+func (bankOfAmericaFormat) Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
+	row := newCSVRow(record, headers)
+
+	date, err := parseDateInOrder(row.get("date", "posted date"), transaction.dateOrder)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid date: %v", err)
+	}
+	transaction.Date = date
+
+	description := row.get("description", "payee")
+	transaction.Description = description
+	transaction.Vendor = extractVendorName(description)
+
+	// Both exports sign amounts from the account's side (charges negative)
+	amount, err := parseAmountField(row.get("amount"))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid amount: %v", err)
+	}
+	transaction.Amount = accountAmount(amount, transaction)
+	transaction.bankAccount = row.get("payee") == "" // The card export names the merchant in Payee
+	transaction.ExternalID = row.get("reference number")
+	transaction.MerchantAddress = joinLines(row.get("address"))
+
+	finishTransaction(&transaction)
+	return &transaction, false, nil
+}
+
+// Wells Fargo exports have no header row: "Date","Amount","*","Check #","Description"
+type wellsFargoFormat struct{}
+
+func (wellsFargoFormat) Name() string { return "wells_fargo" }
+
+func (wellsFargoFormat) Headerless() bool { return true }
+
+func (wellsFargoFormat) Detect(headers []string) int {
+	if len(headers) != 5 || strings.TrimSpace(headers[2]) != "*" {
+		return 0
+	}
+	if _, err := parseDate(strings.TrimSpace(headers[0])); err != nil {
+		return 0
+	}
+	if _, err := parseAmountField(headers[1]); err != nil {
+		return 0
+	}
+	return 50
+}
+
+func (wellsFargoFormat) Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
+	if len(record) < 5 {
+		return nil, false, fmt.Errorf("expected 5 columns, got %d", len(record))
+	}
+
+	date, err := parseDateInOrder(strings.TrimSpace(record[0]), transaction.dateOrder)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid date: %v", err)
+	}
+	transaction.Date = date
+
+	description := strings.TrimSpace(record[4])
+	transaction.Description = description
+	transaction.Vendor = extractVendorName(description)
+
+	amount, err := parseAmountField(record[1])
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid amount: %v", err)
+	}
+	transaction.Amount = accountAmount(amount, transaction)
+	transaction.bankAccount = true
+
+	finishTransaction(&transaction)
+	return &transaction, false, nil
+}
+
+// US Bank: Date,Transaction,Name,Memo,Amount
+type usBankFormat struct{}
+
+func (usBankFormat) Name() string { return "us_bank" }
+
+func (usBankFormat) Detect(headers []string) int {
+	return matchHeaders(headers, "date", "transaction", "name", "memo", "amount")
+}
+
+func (usBankFormat) MappedColumns() []string {
+	return []string{"date", "name", "amount"}
+}
+
+func (usBankFormat) Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
+	row := newCSVRow(record, headers)
+
+	date, err := parseDateInOrder(row.get("date"), transaction.dateOrder)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid date: %v", err)
+	}
+	transaction.Date = date
+
+	description := row.get("name")
+	transaction.Description = description
+	transaction.Vendor = extractVendorName(description)
+
+	// Debits are negative
+	amount, err := parseAmountField(row.get("amount"))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid amount: %v", err)
+	}
+	transaction.Amount = accountAmount(amount, transaction)
+	transaction.bankAccount = true
+
+	finishTransaction(&transaction)
+	return &transaction, false, nil
+}
+
+// Apple Card: Transaction Date,Clearing Date,Description,Merchant,Category,Type,Amount (USD),Purchased By
+type appleCardFormat struct{}
+
+func (appleCardFormat) Name() string { return "apple_card" }
+
+func (appleCardFormat) Detect(headers []string) int {
+	return matchHeaders(headers, "transaction date", "clearing date", "description", "merchant", "type", "amount (usd)")
+}
+
+func (appleCardFormat) MappedColumns() []string {
+	return []string{"transaction date", "clearing date", "description", "merchant", "category", "type", "amount (usd)"}
+}
+
+func (appleCardFormat) Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
+	row := newCSVRow(record, headers)
+
+	date, err := parseDateInOrder(row.get("transaction date", "clearing date"), transaction.dateOrder)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid date: %v", err)
+	}
+	transaction.Date = date
+
+	description := row.get("description")
+	isPayment := strings.EqualFold(row.get("type"), "payment")
+
+	// Merchant is Apple's cleaned-up name; fall back to the raw description
+	vendor := row.get("merchant")
+	if vendor == "" {
+		vendor = description
+	}
+	transaction.Description = description
+	transaction.Vendor = extractVendorName(vendor)
+
+	// Purchases are positive, credits negative
+	amount, err := parseAmountField(row.get("amount (usd)"))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid amount: %v", err)
+	}
+	transaction.Amount = amount
+
+	if category := row.get("category"); category != "" {
+		transaction.Category = category
+	}
+
+	finishTransaction(&transaction)
+	return &transaction, isPayment, nil
+}
+
+// PayPal activity: "Date","Time","TimeZone","Name","Type","Status","Currency","Gross","Fee","Net",...
+type payPalFormat struct{}
+
+func (payPalFormat) Name() string { return "paypal" }
+
+func (payPalFormat) Detect(headers []string) int {
+	return matchHeaders(headers, "date", "time", "timezone", "name", "type", "status", "gross", "fee", "net")
+}
+
+// payPalTransferTypes are activity types that move money between the user's
+// own balances and bank accounts rather than paying or being paid
+var payPalTransferTypes = []string{
+	"withdrawal",
+	"bank deposit",
+	"transfer",
+	"currency conversion",
+	"hold",
+}
+
+func (payPalFormat) MappedColumns() []string {
+	return []string{"date", "name", "to email address", "from email address", "type", "status", "gross", "fee", "transaction id"}
+}
+
+func (payPalFormat) Processor() string { return "PayPal" }
+
+// Fee is negative on payments received
+func (payPalFormat) Fee(record []string, headers []string) (Cents, error) {
+	fee, err := parseAmountField(newCSVRow(record, headers).get("fee"))
+	return -fee, err
+}
+
+// Payout treats withdrawals to the bank as payouts
+func (payPalFormat) Payout(record []string, headers []string, transaction Transaction) (*Payout, error) {
+	row := newCSVRow(record, headers)
+	if !strings.Contains(strings.ToLower(row.get("type")), "withdrawal") || transaction.ExternalID == "" {
+		return nil, nil
+	}
+
+	gross, err := parseAmountField(row.get("gross"))
+	if err != nil {
+		return nil, err
+	}
+	return &Payout{PayoutID: transaction.ExternalID, Date: transaction.Date, Amount: -gross, total: true}, nil
+}
+
+func (payPalFormat) Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
+	row := newCSVRow(record, headers)
+
+	if status := row.get("status"); status != "" && !strings.EqualFold(status, "completed") {
+		return nil, false, fmt.Errorf("PayPal transaction status is %s", status)
+	}
+
+	date, err := parseDateInOrder(row.get("date"), transaction.dateOrder)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid date: %v", err)
+	}
+	transaction.Date = date
+
+	isPayment := false
+	activityType := strings.ToLower(row.get("type"))
+	for _, transferType := range payPalTransferTypes {
+		if strings.Contains(activityType, transferType) {
+			isPayment = true
+		}
+	}
+
+	name := row.get("name")
+	if name == "" {
+		name = row.get("to email address", "from email address")
+	}
+	transaction.Description = name
+	transaction.Vendor = extractVendorName(name)
+
+	// Gross is signed from the PayPal balance's side (payments sent are negative)
+	gross, err := parseAmountField(row.get("gross"))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid gross amount: %v", err)
+	}
+	isRefund := strings.Contains(activityType, "refund")
+	switch {
+	case isPayment:
+		transaction.Amount = accountAmount(gross, transaction)
+	case gross > 0 && !isRefund, gross < 0 && isRefund:
+		// Payments received, and refunds sent back to the payer
+		platformSale(&transaction, gross)
+	default:
+		// Purchases paid with PayPal, and refunds of them
+		transaction.Type = "expense"
+		transaction.Amount = -gross
+	}
+	transaction.ExternalID = row.get("transaction id")
+
+	finishTransaction(&transaction)
+	return &transaction, isPayment, nil
+}
+
+// Stripe balance history, itemized. Older exports:
+// "id","Type","Source","Amount","Fee","Net","Currency","Created (UTC)","Available On (UTC)","Description",...,"Transfer","Transfer Date (UTC)"
+// Newer ones: "balance_transaction_id","created_utc","available_on_utc","currency","gross","fee","net","reporting_category","source_id","description",...,"automatic_payout_id","automatic_payout_effective_at_utc"
+type stripeFormat struct{}
+
+func (stripeFormat) Name() string { return "stripe" }
+
+func (stripeFormat) Detect(headers []string) int {
+	legacy := matchHeaders(headers, "id", "type", "source", "amount", "fee", "net", "created (utc)")
+	itemized := matchHeaders(headers, "balance_transaction_id", "created_utc", "gross", "fee", "net", "reporting_category")
+	if legacy > itemized {
+		return legacy
+	}
+	return itemized
+}
+
+func (stripeFormat) MappedColumns() []string {
+	return []string{"id", "balance_transaction_id", "type", "reporting_category", "amount", "gross", "fee", "created (utc)", "created_utc", "description"}
+}
+
+func (stripeFormat) Processor() string { return "Stripe" }
+
+// stripeFeeTypes are balance entries for Stripe's own charges (Radar,
+// Billing, Connect), which are themselves commissions and fees
+var stripeFeeTypes = []string{"stripe_fee", "fee", "application_fee", "network_cost"}
+
+func (stripeFormat) Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
+	row := newCSVRow(record, headers)
+
+	date, err := parseDateInOrder(row.get("created (utc)", "created_utc", "created"), transaction.dateOrder)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid date: %v", err)
+	}
+	transaction.Date = date
+
+	// Amount is signed from the Stripe balance's side (payouts and refunds are negative)
+	gross, err := parseAmountField(row.get("amount", "gross"))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid amount: %v", err)
+	}
+
+	kind := strings.ToLower(row.get("type", "reporting_category"))
+	description := row.get("description")
+	if description == "" {
+		description = "Stripe " + kind
+	}
+	transaction.Description = description
+	transaction.Vendor = extractVendorName(description)
+	transaction.ExternalID = row.get("id", "balance_transaction_id")
+
+	isStripeFee := false
+	for _, feeType := range stripeFeeTypes {
+		if kind == feeType {
+			isStripeFee = true
+		}
+	}
+
+	isPayment := false
+	switch {
+	case kind == "payout" || kind == "transfer":
+		isPayment = true
+		transaction.Amount = accountAmount(gross, transaction)
+	case isStripeFee:
+		platformFee(&transaction, "Stripe", -gross)
+	default:
+		// Charges, refunds, disputes and adjustments
+		platformSale(&transaction, gross)
+	}
+
+	finishTransaction(&transaction)
+	return &transaction, isPayment, nil
+}
+
+func (stripeFormat) Fee(record []string, headers []string) (Cents, error) {
+	return parseAmountField(newCSVRow(record, headers).get("fee"))
+}
+
+// Payout reads a payout's own row, or the automatic payout a charge was
+// paid out in
+func (stripeFormat) Payout(record []string, headers []string, transaction Transaction) (*Payout, error) {
+	row := newCSVRow(record, headers)
+
+	kind := strings.ToLower(row.get("type", "reporting_category"))
+	if kind == "payout" {
+		gross, err := parseAmountField(row.get("amount", "gross"))
+		if err != nil {
+			return nil, err
+		}
+		payoutID := row.get("source", "source_id")
+		if payoutID == "" {
+			payoutID = transaction.ExternalID
+		}
+		date, err := optionalDate(row.get("available on (utc)", "available_on_utc"), transaction)
+		if err != nil {
+			return nil, err
+		}
+		return &Payout{PayoutID: payoutID, Date: date, Amount: -gross, total: true}, nil
+	}
+
+	payoutID := row.get("transfer", "automatic_payout_id")
+	if payoutID == "" {
+		return nil, nil
+	}
+	net, err := parseAmountField(row.get("net"))
+	if err != nil {
+		return nil, err
+	}
+	date, err := optionalDate(row.get("transfer date (utc)", "automatic_payout_effective_at_utc"), transaction)
+	if err != nil {
+		return nil, err
+	}
+	return &Payout{PayoutID: payoutID, Date: date, Amount: net}, nil
+}
+
+// Square transactions: "Date","Time","Time Zone","Gross Sales",...,"Total Collected",...,"Fees","Net Total","Transaction ID",...,"Deposit ID","Deposit Date",...
+type squareFormat struct{}
+
+func (squareFormat) Name() string { return "square" }
+
+func (squareFormat) Detect(headers []string) int {
+	return matchHeaders(headers, "date", "time", "gross sales", "total collected", "fees", "net total", "transaction id")
+}
+
+func (squareFormat) MappedColumns() []string {
+	return []string{"date", "total collected", "fees", "transaction id", "description", "customer name"}
+}
+
+func (squareFormat) Processor() string { return "Square" }
+
+func (squareFormat) Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
+	row := newCSVRow(record, headers)
+
+	if status := row.get("transaction status"); status != "" && !strings.EqualFold(status, "complete") {
+		return nil, false, fmt.Errorf("Square transaction status is %s", status)
+	}
+
+	date, err := parseDateInOrder(row.get("date"), transaction.dateOrder)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid date: %v", err)
+	}
+	transaction.Date = date
+
+	// Items sold, e.g. "2 x Latte"; the customer, when known, is the payer
+	description := row.get("description")
+	if description == "" {
+		description = "Square sale"
+	}
+	transaction.Description = description
+	if customer := row.get("customer name"); customer != "" {
+		transaction.Vendor = extractVendorName(customer)
+	} else {
+		transaction.Vendor = extractVendorName(description)
+	}
+
+	// Total Collected includes tax and tips and is negative on refunds
+	total, err := parseAmountField(row.get("total collected"))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid amount: %v", err)
+	}
+	platformSale(&transaction, total)
+	transaction.ExternalID = row.get("transaction id", "payment id")
+
+	finishTransaction(&transaction)
+	return &transaction, false, nil
+}
+
+// Fee is negative on sales
+func (squareFormat) Fee(record []string, headers []string) (Cents, error) {
+	fees, err := parseAmountField(newCSVRow(record, headers).get("fees"))
+	return -fees, err
+}
+
+// Payout adds the sale's net to the deposit it was paid out in
+func (squareFormat) Payout(record []string, headers []string, transaction Transaction) (*Payout, error) {
+	row := newCSVRow(record, headers)
+
+	depositID := row.get("deposit id")
+	if depositID == "" {
+		return nil, nil // Cash, or not deposited yet
+	}
+	net, err := parseAmountField(row.get("net total"))
+	if err != nil {
+		return nil, err
+	}
+	date, err := optionalDate(row.get("deposit date"), transaction)
+	if err != nil {
+		return nil, err
+	}
+	return &Payout{PayoutID: depositID, Date: date, Amount: net}, nil
+}
+
+// optionalDate parses a secondary date column, falling back to the
+// transaction's date when the cell is empty
+func optionalDate(value string, transaction Transaction) (time.Time, error) {
+	if value == "" {
+		return transaction.Date, nil
+	}
+	return parseDateInOrder(value, transaction.dateOrder)
+}
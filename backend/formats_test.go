@@ -0,0 +1,281 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fixtureRow is what one data row of a fixture should import as. Card
+// payments and transfers are imported as type "transfer"; rejected rows
+// have rejected set.
+type fixtureRow struct {
+	date     string // YYYY-MM-DD
+	vendor   string
+	amount   Cents
+	txType   string
+	rejected bool
+	extra    ExtraFields // Unmapped columns the row should keep, when checked
+}
+
+// defaultExclusionRules are the rules a new database is seeded with
+func defaultExclusionRules() []ExclusionRule {
+	rules := make([]ExclusionRule, len(defaultExclusionKeywords))
+	for i, keyword := range defaultExclusionKeywords {
+		rules[i] = ExclusionRule{ID: i + 1, Pattern: keyword, MatchType: "keyword", Enabled: true}
+	}
+	return rules
+}
+
+// parseFixture imports a fixture the way an expense upload of
+// "Sample Card.csv" does, against a new database with the default exclusion
+// rules. Rows come back in file order, with nil for each rejected row.
+func parseFixture(t *testing.T, fixture string) (*ParsedCSVData, []*Transaction) {
+	t.Helper()
+	openTestDB(t)
+
+	path := filepath.Join(t.TempDir(), "Sample Card.csv")
+	if err := os.WriteFile(path, []byte(strings.TrimSpace(fixture)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseCSVFile(path, "file", "expenses", "Sample Card.csv", nil, importOptions{HeaderRow: -1})
+	if err != nil {
+		t.Fatalf("parse fixture: %v", err)
+	}
+
+	var rows []*Transaction
+	rejected := parsed.RejectedRows
+	for i := range parsed.Transactions {
+		tx := &parsed.Transactions[i]
+		for len(rejected) > 0 && rejected[0].Line < tx.line {
+			rows = append(rows, nil)
+			rejected = rejected[1:]
+		}
+		rows = append(rows, tx)
+	}
+	for range rejected {
+		rows = append(rows, nil)
+	}
+	return parsed, rows
+}
+
+// Sanitized rows in each bank's export layout, like those in Sample_Data/
+func TestBankFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		fixture string
+		want    []fixtureRow
+	}{
+		{
+			name:   "chase",
+			format: "chase",
+			fixture: `
+Status,Date,Description,Debit,Credit
+Cleared,12/31/2024,"MONEY FOR THE REST OF TUCSON AZ null XXXXXXXXXXXX1091",25.00,
+Cleared,12/30/2024,"ONLINE PAYMENT, THANK YOU",,-832.00
+`,
+			want: []fixtureRow{
+				{date: "2024-12-31", vendor: "MONEY FOR THE REST OF", amount: 2500, txType: "expense"},
+				{date: "2024-12-30", vendor: "ONLINE PAYMENT, THANK YOU", amount: -83200, txType: "transfer"},
+			},
+		},
+		{
+			name:   "amex",
+			format: "amex",
+			fixture: `
+Date,Description,Amount,Extended Details,Appears On Your Statement As,Address,City/State,Zip Code,Country,Reference,Category
+11/26/2024,ONLINE PAYMENT - THANK YOU,-91.49,ONLINE PAYMENT - THANK YOU,ONLINE PAYMENT - THANK YOU,,,,,'320243310941025489',
+12/31/2024,THE RANGE AT AUSTIN AUSTIN              TX,36.61,"5270553962  5126502734
+THE RANGE AT AUSTIN
+AUSTIN",THE RANGE AT AUSTIN AUSTIN              TX,,"AUSTIN
+TX",78701,UNITED STATES,'320243660000000000',Entertainment-Associations
+`,
+			want: []fixtureRow{
+				{date: "2024-11-26", vendor: "ONLINE PAYMENT - THANK YOU", amount: -9149, txType: "transfer"},
+				{date: "2024-12-31", vendor: "THE RANGE AT AUSTIN", amount: 3661, txType: "expense"},
+			},
+		},
+		{
+			name:   "capital one",
+			format: "capital_one",
+			fixture: `
+Transaction Date,Posted Date,Card No.,Description,Category,Debit,Credit
+2024-03-02,2024-03-04,1234,STARBUCKS STORE 12345,Dining,5.75,
+2024-03-05,2024-03-06,1234,AMAZON MKTPLACE PMTS,Merchandise,,23.99
+2024-03-10,2024-03-10,1234,CAPITAL ONE AUTOPAY PYMT,Payment/Credit,,500.00
+`,
+			want: []fixtureRow{
+				{date: "2024-03-02", vendor: "STARBUCKS STORE", amount: 575, txType: "expense"},
+				{date: "2024-03-05", vendor: "AMAZON MKTPLACE PMTS", amount: -2399, txType: "refund"},
+				{date: "2024-03-10", vendor: "CAPITAL ONE AUTOPAY PYMT", amount: -50000, txType: "transfer"},
+			},
+		},
+		{
+			name:   "citi",
+			format: "citi",
+			fixture: `
+Status,Date,Description,Debit,Credit,Member Name
+Cleared,03/04/2024,UBER TRIP HELP.UBER.COM CA,24.50,,JANE DOE
+Cleared,03/08/2024,DELTA AIR LINES REFUND,,-120.00,JANE DOE
+Cleared,03/15/2024,ONLINE PAYMENT THANK YOU,,-300.00,JANE DOE
+`,
+			want: []fixtureRow{
+				{date: "2024-03-04", vendor: "UBER TRIP HELP.UBER.COM", amount: 2450, txType: "expense"},
+				{date: "2024-03-08", vendor: "DELTA AIR LINES REFUND", amount: -12000, txType: "refund"},
+				{date: "2024-03-15", vendor: "ONLINE PAYMENT THANK YOU", amount: -30000, txType: "transfer"},
+			},
+		},
+		{
+			name:   "discover",
+			format: "discover",
+			fixture: `
+Trans. Date,Post Date,Description,Amount,Category
+03/01/2024,03/01/2024,SHELL OIL 57444 SPRINGFIELD IL,42.10,Gasoline
+03/03/2024,03/03/2024,INTERNET PAYMENT - THANK YOU,-250.00,Payments and Credits
+03/05/2024,03/05/2024,STAPLES RETURN,-15.99,Merchandise
+`,
+			want: []fixtureRow{
+				{date: "2024-03-01", vendor: "SHELL OIL", amount: 4210, txType: "expense"},
+				{date: "2024-03-03", vendor: "INTERNET PAYMENT - THANK YOU", amount: -25000, txType: "transfer"},
+				{date: "2024-03-05", vendor: "STAPLES RETURN", amount: -1599, txType: "refund"},
+			},
+		},
+		{
+			name:   "bank of america checking",
+			format: "bank_of_america",
+			fixture: `
+Date,Description,Amount,Running Bal.
+03/02/2024,ADOBE CREATIVE CLD 408-536-6000 CA,-54.99,945.01
+03/09/2024,Online Banking transfer to SAV 1234 Confirmation# 555,-200.00,745.01
+03/12/2024,AMAZON.COM REFUND,12.00,757.01
+`,
+			want: []fixtureRow{
+				{date: "2024-03-02", vendor: "ADOBE CREATIVE CLD", amount: 5499, txType: "expense"},
+				{date: "2024-03-09", vendor: "Online Banking transfer to SAV", amount: 20000, txType: "transfer"},
+				{date: "2024-03-12", vendor: "AMAZON.COM REFUND", amount: -1200, txType: "uncategorized"},
+			},
+		},
+		{
+			name:   "bank of america card",
+			format: "bank_of_america",
+			fixture: `
+Posted Date,Reference Number,Payee,Address,Amount
+03/03/2024,24431064063000000012345,GITHUB INC,"SAN FRANCISCO  CA ",-4.00
+03/12/2024,24692164072000000054321,PAYMENT - THANK YOU,,150.00
+`,
+			want: []fixtureRow{
+				{date: "2024-03-03", vendor: "GITHUB INC", amount: 400, txType: "expense"},
+				{date: "2024-03-12", vendor: "PAYMENT - THANK YOU", amount: -15000, txType: "transfer"},
+			},
+		},
+		{
+			name:   "wells fargo without a header row",
+			format: "wells_fargo",
+			fixture: `
+"03/01/2024","-12.50","*","","PURCHASE AUTHORIZED ON 02/28 DROPBOX"
+"03/04/2024","-100.00","*","","ONLINE TRANSFER TO SAVINGS XXXXXX1234"
+"03/05/2024","25.00","*","","OFFICE DEPOT RETURN"
+`,
+			want: []fixtureRow{
+				{date: "2024-03-01", vendor: "PURCHASE AUTHORIZED ON 02/28 DROPBOX", amount: 1250, txType: "expense"},
+				{date: "2024-03-04", vendor: "ONLINE TRANSFER TO SAVINGS", amount: 10000, txType: "transfer"},
+				{date: "2024-03-05", vendor: "OFFICE DEPOT RETURN", amount: -2500, txType: "uncategorized"},
+			},
+		},
+		{
+			name:   "us bank",
+			format: "us_bank",
+			fixture: `
+"Date","Transaction","Name","Memo","Amount"
+"2024-03-02","DEBIT","NOTION LABS INC","Download from usbank.com. NOTION LABS","-10.0000"
+"2024-03-07","DEBIT","ELECTRONIC WITHDRAWAL AUTOPAY","Download from usbank.com.","-200.0000"
+`,
+			want: []fixtureRow{
+				{date: "2024-03-02", vendor: "NOTION LABS INC", amount: 1000, txType: "expense"},
+				{date: "2024-03-07", vendor: "ELECTRONIC WITHDRAWAL AUTOPAY", amount: 20000, txType: "transfer"},
+			},
+		},
+		{
+			name:   "apple card",
+			format: "apple_card",
+			fixture: `
+Transaction Date,Clearing Date,Description,Merchant,Category,Type,Amount (USD),Purchased By
+03/01/2024,03/02/2024,"APPLE.COM/BILL ONE APPLE PARK WAY CUPERTINO 95014 CA USA",Apple Services,Other,Purchase,9.99,Jane Doe
+03/05/2024,03/05/2024,"ACH DEPOSIT INTERNET TRANSFER FROM ACCOUNT ENDING IN 1234",Ach Deposit Internet Transfer,Payment,Payment,-200.00,Jane Doe
+03/06/2024,03/07/2024,"BEST BUY 00012345 SAN JOSE 95123 CA USA",Best Buy,Other,Credit,-49.99,Jane Doe
+`,
+			want: []fixtureRow{
+				{date: "2024-03-01", vendor: "Apple Services", amount: 999, txType: "expense", extra: ExtraFields{"Purchased By": "Jane Doe"}},
+				{date: "2024-03-05", vendor: "Ach Deposit Internet Transfer", amount: -20000, txType: "transfer"},
+				{date: "2024-03-06", vendor: "Best Buy", amount: -4999, txType: "refund"},
+			},
+		},
+		{
+			name:   "paypal",
+			format: "paypal",
+			fixture: `
+"Date","Time","TimeZone","Name","Type","Status","Currency","Gross","Fee","Net","From Email Address","To Email Address","Transaction ID"
+"03/01/2024","10:00:00","PST","Canva","Preapproved Payment Bill User Payment","Completed","USD","-12.99","0.00","-12.99","me@example.com","billing@canva.com","1AB"
+"03/02/2024","11:00:00","PST","Jane Client","General Payment","Completed","USD","500.00","-17.99","482.01","client@example.com","me@example.com","2CD"
+"03/03/2024","12:00:00","PST","","General Withdrawal","Completed","USD","-482.01","0.00","-482.01","","","3EF"
+"03/04/2024","12:00:00","PST","Pending Co","General Payment","Pending","USD","-5.00","0.00","-5.00","","","4GH"
+`,
+			want: []fixtureRow{
+				{date: "2024-03-01", vendor: "Canva", amount: 1299, txType: "expense"},
+				{date: "2024-03-02", vendor: "Jane Client", amount: 50000, txType: "income"},
+				{date: "2024-03-02", vendor: "PayPal", amount: 1799, txType: "expense"}, // The sale's fee
+				{date: "2024-03-03", vendor: "", amount: 48201, txType: "transfer"},
+				{rejected: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, transactions := parseFixture(t, tt.fixture)
+			if parsed.Format != tt.format {
+				t.Fatalf("detected %s, want %s", parsed.Format, tt.format)
+			}
+			if len(transactions) != len(tt.want) {
+				t.Fatalf("parsed %d rows, want %d", len(transactions), len(tt.want))
+			}
+
+			for i, want := range tt.want {
+				got := transactions[i]
+				if want.rejected {
+					if got != nil {
+						t.Errorf("row %d: parsed as %+v, want it rejected", i+1, got)
+					}
+					continue
+				}
+				if got == nil {
+					t.Errorf("row %d: rejected, want %+v", i+1, want)
+					continue
+				}
+
+				if date := got.Date.Format("2006-01-02"); date != want.date {
+					t.Errorf("row %d: date = %s, want %s", i+1, date, want.date)
+				}
+				if got.Vendor != want.vendor {
+					t.Errorf("row %d: vendor = %q, want %q", i+1, got.Vendor, want.vendor)
+				}
+				if got.Amount != want.amount {
+					t.Errorf("row %d: amount = %d, want %d", i+1, got.Amount, want.amount)
+				}
+				if got.Type != want.txType {
+					t.Errorf("row %d: type = %s, want %s", i+1, got.Type, want.txType)
+				}
+				if wantExpensable := want.txType == "expense" || want.txType == "income"; got.Expensable != wantExpensable {
+					t.Errorf("row %d: expensable = %v, want %v", i+1, got.Expensable, wantExpensable)
+				}
+				for column, value := range want.extra {
+					if got.Extra[column] != value {
+						t.Errorf("row %d: extra %q = %q, want %q", i+1, column, got.Extra[column], value)
+					}
+				}
+			}
+		})
+	}
+}
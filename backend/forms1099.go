@@ -0,0 +1,497 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Form1099 is an information return a payer filed with the IRS: a 1099-NEC
+// from a client, a 1099-K from a payment processor or marketplace, or a
+// 1099-MISC. Recorded income is reconciled against it before filing.
+type Form1099 struct {
+	ID              int           `json:"id" db:"id"`
+	TaxYear         int           `json:"tax_year" db:"tax_year"`
+	FormType        string        `json:"form_type" db:"form_type"` // "1099-NEC", "1099-K" or "1099-MISC"
+	Payer           string        `json:"payer" db:"payer"`
+	PayerTINLast4   string        `json:"payer_tin_last4" db:"payer_tin_last4"`
+	MatchPattern    string        `json:"match_pattern" db:"match_pattern"` // Text in the vendor or description of the payer's income; empty = payer
+	Boxes           Form1099Boxes `json:"boxes" db:"boxes"`
+	Line2Adjustment Cents         `json:"line2_adjustment" db:"line2_adjustment_cents"` // 1099-K only: part of the gross that isn't business income
+	CreatedAt       string        `json:"created_at" db:"created_at"`
+}
+
+// Form1099Boxes holds a form's box amounts keyed by box number ("1", "1a",
+// "5b"). It is stored as a JSON object.
+type Form1099Boxes map[string]Cents
+
+func (b Form1099Boxes) Value() (driver.Value, error) {
+	if len(b) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(map[string]Cents(b))
+	return string(data), err
+}
+
+func (b *Form1099Boxes) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("unsupported 1099 boxes value %T", src)
+	}
+
+	*b = nil
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, (*map[string]Cents)(b))
+}
+
+// form1099Boxes lists the amount boxes of each form type
+var form1099Boxes = map[string][]string{
+	"1099-NEC":  {"1", "4", "5", "7"},
+	"1099-K":    {"1a", "1b", "4", "5a", "5b", "5c", "5d", "5e", "5f", "5g", "5h", "5i", "5j", "5k", "5l", "8"},
+	"1099-MISC": {"1", "2", "3", "4", "5", "6", "8", "9", "10", "11", "12", "14", "15", "16", "18"},
+}
+
+// form1099IncomeBoxes are the boxes reported as Schedule C gross receipts:
+// nonemployee compensation, payment card and network gross, and MISC other
+// income and medical payments. MISC rents and royalties usually belong on
+// Schedule E and aren't compared.
+var form1099IncomeBoxes = map[string][]string{
+	"1099-NEC":  {"1"},
+	"1099-K":    {"1a"},
+	"1099-MISC": {"3", "6"},
+}
+
+// form1099Tolerance absorbs rounding between a form and the transactions
+const form1099Tolerance = Cents(100)
+
+// incomeAmount totals the form's Schedule C income boxes
+func (f Form1099) incomeAmount() Cents {
+	var total Cents
+	for _, box := range form1099IncomeBoxes[f.FormType] {
+		total += f.Boxes[box]
+	}
+	return total
+}
+
+func (f Form1099) pattern() string {
+	if f.MatchPattern != "" {
+		return f.MatchPattern
+	}
+	return f.Payer
+}
+
+func createForms1099Table() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS forms_1099 (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			tax_year INTEGER NOT NULL,
+			form_type TEXT NOT NULL,
+			payer TEXT NOT NULL,
+			payer_tin_last4 TEXT DEFAULT '',
+			match_pattern TEXT DEFAULT '',
+			boxes TEXT DEFAULT '',
+			line2_adjustment_cents INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`)
+	if err != nil {
+		return fmt.Errorf("error creating forms_1099 table: %v", err)
+	}
+	return nil
+}
+
+func validateForm1099(form *Form1099) error {
+	form.FormType = strings.ToUpper(strings.TrimSpace(form.FormType))
+	form.Payer = strings.TrimSpace(form.Payer)
+	form.PayerTINLast4 = strings.TrimSpace(form.PayerTINLast4)
+	form.MatchPattern = strings.TrimSpace(form.MatchPattern)
+
+	boxes, ok := form1099Boxes[form.FormType]
+	if !ok {
+		return fmt.Errorf("form_type must be 1099-NEC, 1099-K or 1099-MISC")
+	}
+	if form.Payer == "" {
+		return fmt.Errorf("payer is required")
+	}
+	if form.TaxYear < 2000 || form.TaxYear > time.Now().Year() {
+		return fmt.Errorf("tax_year must be between 2000 and %d", time.Now().Year())
+	}
+	if form.PayerTINLast4 != "" {
+		if _, err := strconv.Atoi(form.PayerTINLast4); err != nil || len(form.PayerTINLast4) != 4 {
+			return fmt.Errorf("payer_tin_last4 must be four digits")
+		}
+	}
+
+	allowed := make(map[string]bool, len(boxes))
+	for _, box := range boxes {
+		allowed[box] = true
+	}
+	normalized := make(Form1099Boxes, len(form.Boxes))
+	for box, amount := range form.Boxes {
+		box = strings.ToLower(strings.TrimSpace(box))
+		if !allowed[box] {
+			return fmt.Errorf("%s has no box %q; use one of %s", form.FormType, box, strings.Join(boxes, ", "))
+		}
+		if amount < 0 {
+			return fmt.Errorf("box %s can't be negative", box)
+		}
+		normalized[box] = amount
+	}
+	form.Boxes = normalized
+
+	if form.Line2Adjustment != 0 && form.FormType != "1099-K" {
+		return fmt.Errorf("line2_adjustment only applies to 1099-K forms")
+	}
+	if form.Line2Adjustment < 0 || form.Line2Adjustment > form.incomeAmount() {
+		return fmt.Errorf("line2_adjustment must be between 0 and the form's box 1a")
+	}
+	return nil
+}
+
+// loadForms1099 returns the forms for taxYear, or every form when taxYear is 0
+func loadForms1099(taxYear int) ([]Form1099, error) {
+	rows, err := db.Query(`
+		SELECT id, tax_year, form_type, payer, COALESCE(payer_tin_last4, ''), COALESCE(match_pattern, ''),
+		       boxes, COALESCE(line2_adjustment_cents, 0), created_at
+		FROM forms_1099
+		WHERE ? = 0 OR tax_year = ?
+		ORDER BY tax_year, payer, form_type, id
+	`, taxYear, taxYear)
+	if err != nil {
+		return nil, fmt.Errorf("error querying 1099 forms: %v", err)
+	}
+	defer rows.Close()
+
+	var forms []Form1099
+	for rows.Next() {
+		var form Form1099
+		err := rows.Scan(&form.ID, &form.TaxYear, &form.FormType, &form.Payer, &form.PayerTINLast4,
+			&form.MatchPattern, &form.Boxes, &form.Line2Adjustment, &form.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning 1099 form: %v", err)
+		}
+		forms = append(forms, form)
+	}
+	return forms, rows.Err()
+}
+
+// PayerReconciliation compares what one payer reported on its 1099s for a
+// tax year with the income recorded from that payer
+type PayerReconciliation struct {
+	Payer          string     `json:"payer"`
+	TaxYear        int        `json:"tax_year"`
+	Forms          []Form1099 `json:"forms"`
+	Reported       Cents      `json:"reported"`        // Schedule C income boxes across the payer's forms
+	RecordedIncome Cents      `json:"recorded_income"` // Matching income transactions
+	BusinessIncome Cents      `json:"business_income"` // The part of it counted on Line 1
+	Transactions   int        `json:"transactions"`
+	Difference     Cents      `json:"difference"` // Reported minus business income
+	Status         string     `json:"status"`     // "matched", "under_reported" or "over_reported"
+	Note           string     `json:"note,omitempty"`
+
+	reported1099K   Cents
+	line2Adjustment Cents
+}
+
+// reconcileForms1099 groups the forms for taxYear (0 = every year) by payer
+// and year and totals the income transactions each payer's match patterns
+// find in that year. Customer refunds recorded as negative income aren't
+// subtracted, since 1099 amounts are gross.
+func reconcileForms1099(taxYear int) ([]PayerReconciliation, error) {
+	forms, err := loadForms1099(taxYear)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []PayerReconciliation
+	index := make(map[string]int)
+	for _, form := range forms {
+		key := fmt.Sprintf("%d|%s", form.TaxYear, strings.ToLower(form.Payer))
+		i, ok := index[key]
+		if !ok {
+			i = len(results)
+			index[key] = i
+			results = append(results, PayerReconciliation{Payer: form.Payer, TaxYear: form.TaxYear})
+		}
+		result := &results[i]
+		result.Forms = append(result.Forms, form)
+		result.Reported += form.incomeAmount()
+		if form.FormType == "1099-K" {
+			result.reported1099K += form.incomeAmount()
+			result.line2Adjustment += form.Line2Adjustment
+		}
+	}
+
+	for i := range results {
+		result := &results[i]
+		if err := matchPayerIncome(result); err != nil {
+			return nil, err
+		}
+
+		result.Difference = result.Reported - result.BusinessIncome
+		switch {
+		case result.Difference > form1099Tolerance:
+			result.Status = "under_reported"
+			switch {
+			case result.RecordedIncome-result.BusinessIncome >= result.Difference-form1099Tolerance:
+				result.Note = "matching income is recorded but not all of it is marked expensable, so Line 1 leaves it out"
+			case result.reported1099K > 0:
+				result.Note = "Line 1 includes the 1099-K gross; record the missing income or set line2_adjustment for payments that weren't business income"
+			default:
+				result.Note = "income the IRS was told about is missing from Line 1"
+			}
+		case result.Difference < -form1099Tolerance:
+			result.Status = "over_reported"
+			result.Note = "more income is recorded than the payer reported; check the match pattern or for payments outside the form"
+		default:
+			result.Status = "matched"
+		}
+	}
+
+	return results, nil
+}
+
+// matchPayerIncome totals the income transactions in the result's tax year
+// whose vendor or description contains any of its forms' match patterns
+func matchPayerIncome(result *PayerReconciliation) error {
+	var patterns []string
+	var args []interface{}
+	seen := make(map[string]bool)
+	for _, form := range result.Forms {
+		pattern := strings.ToLower(form.pattern())
+		if seen[pattern] {
+			continue
+		}
+		seen[pattern] = true
+		patterns = append(patterns, "(instr(lower(vendor), ?) > 0 OR instr(lower(COALESCE(description, '')), ?) > 0)")
+		args = append(args, pattern, pattern)
+	}
+	args = append(args, strconv.Itoa(result.TaxYear))
+
+	query := fmt.Sprintf(`
+		SELECT COALESCE(SUM(amount_cents), 0),
+		       COALESCE(SUM(CASE WHEN expensable = true THEN amount_cents ELSE 0 END), 0),
+		       COUNT(*)
+		FROM transactions
+		WHERE type = 'income' AND amount_cents > 0 AND (%s) AND strftime('%%Y', date) = ?
+	`, strings.Join(patterns, " OR "))
+
+	err := db.QueryRow(query, args...).Scan(&result.RecordedIncome, &result.BusinessIncome, &result.Transactions)
+	if err != nil {
+		return fmt.Errorf("error matching income for %s: %v", result.Payer, err)
+	}
+	return nil
+}
+
+// incomeLines computes Schedule C Line 1 (gross receipts) and Line 2
+// (returns and allowances) from taxYear's business income. Customer refunds recorded
+// as negative income go to Line 2 rather than reducing Line 1. A payer's
+// 1099-K gross that exceeds the income recorded from it is added to Line 1,
+// so Line 1 is never below what the IRS was told, and the forms'
+// line2_adjustment amounts go to Line 2. Only taxYear's forms count.
+func incomeLines(taxYear int) (line1, line2, adjustment1099K Cents, err error) {
+	err = db.QueryRow(`
+		SELECT COALESCE(SUM(CASE WHEN amount_cents > 0 THEN amount_cents ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN amount_cents < 0 THEN -amount_cents ELSE 0 END), 0)
+		FROM transactions
+		WHERE type = 'income' AND expensable = true AND strftime('%Y', date) = ?
+	`, strconv.Itoa(taxYear)).Scan(&line1, &line2)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("error calculating gross receipts: %v", err)
+	}
+
+	results, err := reconcileForms1099(taxYear)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for _, result := range results {
+		if result.reported1099K == 0 {
+			continue
+		}
+		if shortfall := result.Reported - result.BusinessIncome; shortfall > 0 {
+			if shortfall > result.reported1099K {
+				shortfall = result.reported1099K
+			}
+			adjustment1099K += shortfall
+		}
+		line2 += result.line2Adjustment
+	}
+
+	return line1 + adjustment1099K, line2, adjustment1099K, nil
+}
+
+func createForm1099(w http.ResponseWriter, r *http.Request) {
+	var form Form1099
+	if err := json.NewDecoder(r.Body).Decode(&form); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateForm1099(&form); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if form.ID > 0 {
+		result, err := db.Exec(`
+			UPDATE forms_1099
+			SET tax_year = ?, form_type = ?, payer = ?, payer_tin_last4 = ?, match_pattern = ?, boxes = ?, line2_adjustment_cents = ?
+			WHERE id = ?`,
+			form.TaxYear, form.FormType, form.Payer, form.PayerTINLast4, form.MatchPattern, form.Boxes, form.Line2Adjustment, form.ID)
+		if err != nil {
+			log.Printf("Failed to update 1099 form: %v", err)
+			http.Error(w, "Failed to save 1099 form", http.StatusInternalServerError)
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			http.Error(w, "1099 form not found", http.StatusNotFound)
+			return
+		}
+	} else {
+		result, err := db.Exec(`
+			INSERT INTO forms_1099 (tax_year, form_type, payer, payer_tin_last4, match_pattern, boxes, line2_adjustment_cents)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			form.TaxYear, form.FormType, form.Payer, form.PayerTINLast4, form.MatchPattern, form.Boxes, form.Line2Adjustment)
+		if err != nil {
+			log.Printf("Failed to create 1099 form: %v", err)
+			http.Error(w, "Failed to save 1099 form", http.StatusInternalServerError)
+			return
+		}
+		id, _ := result.LastInsertId()
+		form.ID = int(id)
+	}
+
+	db.QueryRow("SELECT created_at FROM forms_1099 WHERE id = ?", form.ID).Scan(&form.CreatedAt)
+
+	log.Printf("🧾 Saved %s from %s for %d (ID: %d)", form.FormType, form.Payer, form.TaxYear, form.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "1099 form saved successfully",
+		"form":    form,
+	})
+}
+
+// taxYearParam reads the optional tax_year query parameter; 0 means every year
+func taxYearParam(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("tax_year")
+	if value == "" {
+		return 0, nil
+	}
+	year, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid tax_year")
+	}
+	return year, nil
+}
+
+func getForms1099(w http.ResponseWriter, r *http.Request) {
+	taxYear, err := taxYearParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	forms, err := loadForms1099(taxYear)
+	if err != nil {
+		log.Printf("Error loading 1099 forms: %v", err)
+		http.Error(w, "Failed to fetch 1099 forms", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"forms":   forms,
+		"count":   len(forms),
+	})
+}
+
+func deleteForm1099(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid form ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM forms_1099 WHERE id = ?", id)
+	if err != nil {
+		log.Printf("Failed to delete 1099 form: %v", err)
+		http.Error(w, "Failed to delete 1099 form", http.StatusInternalServerError)
+		return
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		http.Error(w, "1099 form not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "1099 form deleted successfully",
+	})
+}
+
+// get1099Reconciliation reports, per payer and tax year, the 1099 total
+// against the matching income, listing under-reported payers first
+func get1099Reconciliation(w http.ResponseWriter, r *http.Request) {
+	taxYear, err := taxYearParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results, err := reconcileForms1099(taxYear)
+	if err != nil {
+		log.Printf("Error reconciling 1099 forms: %v", err)
+		http.Error(w, "Failed to reconcile 1099 forms", http.StatusInternalServerError)
+		return
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Status == "under_reported" && results[j].Status != "under_reported"
+	})
+
+	var reported, business, shortfall Cents
+	underReported := 0
+	for _, result := range results {
+		reported += result.Reported
+		business += result.BusinessIncome
+		if result.Status == "under_reported" {
+			underReported++
+			shortfall += result.Difference
+		}
+	}
+
+	if underReported > 0 {
+		log.Printf("⚠️ %d payer(s) reported $%s more on 1099s than Line 1 includes", underReported, shortfall)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":         true,
+		"payers":          results,
+		"count":           len(results),
+		"under_reported":  underReported,
+		"total_reported":  reported,
+		"total_business":  business,
+		"total_shortfall": shortfall,
+	})
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// driverSummaryFormat reads Uber and Lyft driver tax summaries saved as CSV.
+// The first row is the title, naming the platform and usually the year, and
+// label/amount rows follow under section headings:
+//
+//	Uber Tax Summary 2024
+//	Gross fares breakdown
+//	Trip fares,"$23,456.78"
+//	Booking fees,"$1,234.00"
+//	Total,"$24,690.78"
+//	Uber fees
+//	Service fee,"$4,567.89"
+//	Other income
+//	Tips,$789.00
+//	Potential deductions
+//	On-trip mileage,"12,345 mi"
+//	Online miles,"20,123 mi"
+//
+// Rows under fare and income headings are income and rows under fee and
+// deduction headings are Line 10 fees. Totals are skipped because their parts
+// are already counted, and miles go to the vehicle deduction. Everything is
+// dated December 31 of the summary's year.
+type driverSummaryFormat struct {
+	year                   int
+	section                string
+	tripMiles, onlineMiles float64
+}
+
+func (*driverSummaryFormat) Name() string { return "driver_summary" }
+
+func (*driverSummaryFormat) Detect(headers []string) int {
+	title := strings.ToLower(strings.Join(headers, " "))
+	if driverPlatform(headers) != "" && strings.Contains(title, "summary") {
+		return 50
+	}
+	return 0
+}
+
+func (*driverSummaryFormat) newFile() FormatParser { return &driverSummaryFormat{} }
+
+// Ragged is true because headings are a single cell, shorter than the title row
+func (*driverSummaryFormat) Ragged() bool { return true }
+
+// driverPlatform names the platform in a summary's title row
+func driverPlatform(headers []string) string {
+	title := strings.ToLower(strings.Join(headers, " "))
+	switch {
+	case strings.Contains(title, "uber"):
+		return "Uber"
+	case strings.Contains(title, "lyft"):
+		return "Lyft"
+	}
+	return ""
+}
+
+var summaryYearPattern = regexp.MustCompile(`\b(19|20)\d{2}\b`)
+
+// summaryYear finds the tax year in a title or period row. A period such as
+// "Jan 1, 2024 - Dec 31, 2024" names it twice; the last one is used.
+func summaryYear(cells []string) int {
+	matches := summaryYearPattern.FindAllString(strings.Join(cells, " "), -1)
+	if len(matches) == 0 {
+		return 0
+	}
+	year, _ := strconv.Atoi(matches[len(matches)-1])
+	return year
+}
+
+func (f *driverSummaryFormat) Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
+	if f.year == 0 {
+		f.year = summaryYear(headers)
+	}
+
+	var cells []string
+	for _, cell := range record {
+		if cell = strings.TrimSpace(cell); cell != "" {
+			cells = append(cells, cell)
+		}
+	}
+	if len(cells) == 0 {
+		return nil, false, errSkipRecord
+	}
+	label := strings.ToLower(cells[0])
+
+	// A lone label is a section heading
+	if len(cells) == 1 {
+		f.section = label
+		if f.year == 0 {
+			f.year = summaryYear(cells)
+		}
+		return nil, false, errSkipRecord
+	}
+	value := cells[len(cells)-1]
+
+	switch {
+	case strings.Contains(label, "total"):
+		return nil, false, errSkipRecord
+	case strings.Contains(label, "year") || strings.Contains(label, "period"):
+		if year := summaryYear(cells[1:]); year != 0 {
+			f.year = year
+		}
+		return nil, false, errSkipRecord
+	case strings.Contains(label, "mile"):
+		miles, err := parseMiles(value)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid mileage: %v", err)
+		}
+		if strings.Contains(label, "online") {
+			f.onlineMiles += miles
+		} else {
+			f.tripMiles += miles
+		}
+		return nil, false, errSkipRecord
+	}
+
+	if f.year == 0 {
+		return nil, false, fmt.Errorf("summary doesn't say which tax year it covers; add the year to its title row")
+	}
+	amount, err := parseAmountField(value)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid amount: %v", err)
+	}
+
+	platform := driverPlatform(headers)
+	transaction.Date = time.Date(f.year, time.December, 31, 0, 0, 0, 0, time.UTC)
+	transaction.Description = fmt.Sprintf("%s %d summary: %s", platform, f.year, cells[0])
+	transaction.Vendor = platform
+	if f.isFee(label) {
+		platformFee(&transaction, platform, amount.Abs())
+	} else {
+		platformSale(&transaction, amount)
+	}
+
+	finishTransaction(&transaction)
+	return &transaction, false, nil
+}
+
+// isFee goes by section first: Uber lists rider-paid booking fees under gross
+// fares as income, and again under its own fees as a deduction
+func (f *driverSummaryFormat) isFee(label string) bool {
+	for _, word := range []string{"fare", "income", "earning", "gross"} {
+		if strings.Contains(f.section, word) {
+			return false
+		}
+	}
+	for _, word := range []string{"fee", "deduction", "expense"} {
+		if strings.Contains(f.section, word) {
+			return true
+		}
+	}
+	return strings.Contains(label, "fee") || strings.Contains(label, "commission")
+}
+
+// BusinessMiles prefers online miles, which include the miles on trips
+func (f *driverSummaryFormat) BusinessMiles() float64 {
+	return math.Max(f.onlineMiles, f.tripMiles)
+}
+
+// DoorDash Dasher earnings, one row per dash or delivery:
+// "Date","Store Name","Base Pay","Peak Pay","Customer Tips","Total Pay","Miles",...
+// Total pay includes tips; DoorDash takes no fee from Dashers.
+type doorDashFormat struct {
+	miles float64
+}
+
+var doorDashTotalColumns = []string{"total pay", "total earnings", "total"}
+
+func (*doorDashFormat) Name() string { return "doordash" }
+
+func (*doorDashFormat) Detect(headers []string) int {
+	for _, total := range doorDashTotalColumns {
+		if score := matchHeaders(headers, "base pay", total); score > 0 {
+			return score + 10
+		}
+	}
+	return 0
+}
+
+func (*doorDashFormat) newFile() FormatParser { return &doorDashFormat{} }
+
+func (*doorDashFormat) MappedColumns() []string {
+	return append([]string{"date", "dash date", "delivery date", "store name", "delivery id", "miles", "mileage"}, doorDashTotalColumns...)
+}
+
+func (f *doorDashFormat) Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
+	row := newCSVRow(record, headers)
+
+	date, err := parseDateInOrder(row.get("date", "dash date", "delivery date"), transaction.dateOrder)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid date: %v", err)
+	}
+	transaction.Date = date
+
+	total, err := parseAmountField(row.get(doorDashTotalColumns...))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid total pay: %v", err)
+	}
+
+	var miles float64
+	if value := row.get("miles", "mileage"); value != "" {
+		if miles, err = parseMiles(value); err != nil {
+			return nil, false, fmt.Errorf("invalid mileage: %v", err)
+		}
+	}
+	f.miles += miles
+	transaction.miles = miles
+
+	transaction.Description = "DoorDash earnings"
+	if store := row.get("store name"); store != "" {
+		transaction.Description = "DoorDash delivery: " + store
+	}
+	transaction.Vendor = "DoorDash"
+	platformSale(&transaction, total)
+	transaction.ExternalID = row.get("delivery id")
+
+	finishTransaction(&transaction)
+	return &transaction, false, nil
+}
+
+func (f *doorDashFormat) BusinessMiles() float64 { return f.miles }
+
+// parseMiles reads a distance such as "12,345.6 mi"
+func parseMiles(value string) (float64, error) {
+	value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(strings.ToLower(value)), "mi"))
+	miles, err := strconv.ParseFloat(strings.ReplaceAll(value, ",", ""), 64)
+	if err != nil || miles < 0 {
+		return 0, fmt.Errorf("unable to parse miles: %s", value)
+	}
+	return miles, nil
+}
+
+// Etsy sold orders: "Sale Date","Order ID",...,"Order Value",...,"Shipping","Sales Tax","Order Total",...,"Card Processing Fees","Order Net",...
+// Sales tax is collected and remitted by Etsy, so income is the order total
+// without it; the card processing fee is split off like a processor's.
+type etsyOrdersFormat struct{}
+
+func (etsyOrdersFormat) Name() string { return "etsy_orders" }
+
+func (etsyOrdersFormat) Detect(headers []string) int {
+	return matchHeaders(headers, "sale date", "order id", "order total", "sales tax", "card processing fees", "order net")
+}
+
+func (etsyOrdersFormat) MappedColumns() []string {
+	return []string{"sale date", "order id", "full name", "order total", "adjusted order total", "sales tax", "card processing fees", "adjusted card processing fees"}
+}
+
+func (etsyOrdersFormat) Processor() string { return "Etsy" }
+
+func (etsyOrdersFormat) Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
+	row := newCSVRow(record, headers)
+
+	date, err := parseDateInOrder(row.get("sale date"), transaction.dateOrder)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid date: %v", err)
+	}
+	transaction.Date = date
+
+	// Refunded orders have an adjusted total
+	total, err := parseAmountField(firstNonEmpty(row.get("adjusted order total"), row.get("order total")))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid order total: %v", err)
+	}
+	salesTax, err := parseAmountField(row.get("sales tax"))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid sales tax: %v", err)
+	}
+
+	transaction.Description = "Etsy order " + row.get("order id")
+	transaction.Vendor = "Etsy"
+	if buyer := row.get("full name"); buyer != "" {
+		transaction.Vendor = extractVendorName(buyer)
+	}
+	platformSale(&transaction, total-salesTax)
+	transaction.ExternalID = row.get("order id")
+
+	finishTransaction(&transaction)
+	return &transaction, false, nil
+}
+
+func (etsyOrdersFormat) Fee(record []string, headers []string) (Cents, error) {
+	row := newCSVRow(record, headers)
+	fee, err := parseAmountField(firstNonEmpty(row.get("adjusted card processing fees"), row.get("card processing fees")))
+	return fee.Abs(), err
+}
+
+func (etsyOrdersFormat) Payout(record []string, headers []string, transaction Transaction) (*Payout, error) {
+	return nil, nil
+}
+
+// Etsy payment account statement: "Date","Type","Title","Info","Currency","Amount","Fees & Taxes","Net","Tax Details"
+// Sales, fees, Etsy Ads and deposits are separate rows; "--" marks an empty amount.
+type etsyStatementFormat struct{}
+
+func (etsyStatementFormat) Name() string { return "etsy" }
+
+func (etsyStatementFormat) Detect(headers []string) int {
+	return matchHeaders(headers, "date", "type", "title", "info", "amount", "fees & taxes", "net")
+}
+
+func (etsyStatementFormat) MappedColumns() []string {
+	return []string{"date", "type", "title", "info", "amount", "fees & taxes", "net"}
+}
+
+func (etsyStatementFormat) Processor() string { return "Etsy" }
+
+// etsyDepositPattern finds the amount in a deposit title such as
+// "$432.10 sent to your bank account"
+var etsyDepositPattern = regexp.MustCompile(`[\d,]+\.\d{2}`)
+
+func etsyAmount(value string) (Cents, error) {
+	if value == "--" {
+		return 0, nil
+	}
+	return parseAmountField(value)
+}
+
+func etsyDeposit(row csvRow) (Cents, error) {
+	amount := etsyDepositPattern.FindString(row.get("title"))
+	if amount == "" {
+		return 0, fmt.Errorf("no amount in deposit title %q", row.get("title"))
+	}
+	return parseMoney(amount, ".")
+}
+
+func (etsyStatementFormat) Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
+	row := newCSVRow(record, headers)
+
+	date, err := parseDateInOrder(row.get("date"), transaction.dateOrder)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid date: %v", err)
+	}
+	transaction.Date = date
+
+	net, err := etsyAmount(row.get("net"))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid net amount: %v", err)
+	}
+
+	transaction.Description = strings.TrimSpace(row.get("title") + " " + row.get("info"))
+	transaction.Vendor = "Etsy"
+
+	isPayment := false
+	switch strings.ToLower(row.get("type")) {
+	case "tax":
+		// Sales tax and VAT collected and remitted by Etsy
+		return nil, false, errSkipRecord
+	case "fee":
+		// Listing, transaction and processing fees; credits are positive
+		platformFee(&transaction, "Etsy", -net)
+	case "marketing":
+		// Etsy Ads
+		transaction.Type = "expense"
+		transaction.Amount = -net
+		transaction.Category = "Advertising"
+		transaction.ScheduleCLine = 8
+	case "deposit":
+		deposit, err := etsyDeposit(row)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid deposit: %v", err)
+		}
+		isPayment = true
+		transaction.Amount = accountAmount(-deposit, transaction)
+	default:
+		if net < 0 && !strings.EqualFold(row.get("type"), "refund") {
+			// Shipping labels and other charges, left for classification
+			transaction.Type = "expense"
+			transaction.Amount = -net
+		} else {
+			platformSale(&transaction, net)
+		}
+	}
+
+	finishTransaction(&transaction)
+	return &transaction, isPayment, nil
+}
+
+// Fee is 0 because Etsy lists its fees as rows of their own
+func (etsyStatementFormat) Fee(record []string, headers []string) (Cents, error) {
+	return 0, nil
+}
+
+// Payout treats deposits to the bank as payouts. They have no ID, so the
+// date and amount identify them.
+func (etsyStatementFormat) Payout(record []string, headers []string, transaction Transaction) (*Payout, error) {
+	row := newCSVRow(record, headers)
+	if !strings.EqualFold(row.get("type"), "deposit") {
+		return nil, nil
+	}
+
+	deposit, err := etsyDeposit(row)
+	if err != nil {
+		return nil, err
+	}
+	payoutID := fmt.Sprintf("%s %s", transaction.Date.Format("2006-01-02"), deposit)
+	return &Payout{PayoutID: payoutID, Date: transaction.Date, Amount: deposit, total: true}, nil
+}
+
+// Amazon seller settlement report, the tab-separated flat file (V2):
+// "settlement-id","settlement-start-date","settlement-end-date","deposit-date","total-amount","currency",
+// "transaction-type","order-id",...,"amount-type","amount-description","amount",...,"posted-date",...
+// The first row after the header is the settlement itself with its deposit;
+// the rest are the order, refund, fee and other amounts that make it up.
+type amazonSettlementFormat struct{}
+
+func (amazonSettlementFormat) Name() string { return "amazon" }
+
+func (amazonSettlementFormat) Detect(headers []string) int {
+	return matchHeaders(headers, "settlement-id", "total-amount", "transaction-type", "amount-type", "amount-description", "amount")
+}
+
+func (amazonSettlementFormat) MappedColumns() []string {
+	return []string{"settlement-id", "deposit-date", "total-amount", "transaction-type", "order-id", "amount-type", "amount-description", "amount", "posted-date", "posted-date-time"}
+}
+
+func (amazonSettlementFormat) Processor() string { return "Amazon" }
+
+// isAmazonSettlementRow reports whether a row is the settlement summary
+func isAmazonSettlementRow(row csvRow) bool {
+	return row.get("total-amount") != "" && row.get("transaction-type") == ""
+}
+
+func (amazonSettlementFormat) Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
+	row := newCSVRow(record, headers)
+	transaction.Vendor = "Amazon"
+
+	// The settlement's deposit to the bank is a transfer, like a Stripe payout
+	if isAmazonSettlementRow(row) {
+		date, err := parseDateInOrder(row.get("deposit-date"), transaction.dateOrder)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid deposit date: %v", err)
+		}
+		total, err := parseAmountField(row.get("total-amount"))
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid total amount: %v", err)
+		}
+		transaction.Date = date
+		transaction.Description = "Amazon settlement " + row.get("settlement-id")
+		transaction.Amount = accountAmount(-total, transaction)
+		transaction.ExternalID = row.get("settlement-id")
+		finishTransaction(&transaction)
+		return &transaction, true, nil
+	}
+
+	date, err := parseDateInOrder(row.get("posted-date", "posted-date-time"), transaction.dateOrder)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid date: %v", err)
+	}
+	transaction.Date = date
+
+	// Signed from the seller's side: sales positive, fees and refunds negative
+	amount, err := parseAmountField(row.get("amount"))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid amount: %v", err)
+	}
+
+	transactionType := row.get("transaction-type")
+	amountType := strings.ToLower(row.get("amount-type"))
+	description := strings.ToLower(row.get("amount-description"))
+	parts := []string{transactionType, row.get("order-id"), row.get("amount-type"), row.get("amount-description")}
+	if strings.EqualFold(parts[2], transactionType) {
+		parts[2] = "" // "other-transaction" repeats the transaction type
+	}
+	transaction.Description = strings.Join(strings.Fields(strings.Join(parts, " ")), " ")
+
+	switch {
+	case strings.Contains(amountType, "tax") || strings.Contains(description, "tax"):
+		// Sales tax Amazon collects and remits; withheld tax rows cancel it out
+		return nil, false, errSkipRecord
+	case strings.Contains(description, "reserve"):
+		// Funds held back from one settlement and released in the next
+		return nil, false, errSkipRecord
+	case strings.Contains(description, "advertising"):
+		transaction.Type = "expense"
+		transaction.Amount = -amount
+		transaction.Category = "Advertising"
+		transaction.ScheduleCLine = 8
+	case strings.Contains(amountType, "fee") || strings.Contains(strings.ToLower(transactionType), "fee") ||
+		strings.Contains(description, "fee") || strings.Contains(description, "commission"):
+		platformFee(&transaction, "Amazon", -amount)
+	case amount < 0 && amountType != "itemprice" && amountType != "promotion" && !strings.EqualFold(transactionType, "refund"):
+		// Shipping labels and other charges, left for classification
+		transaction.Type = "expense"
+		transaction.Amount = -amount
+	default:
+		platformSale(&transaction, amount)
+	}
+
+	finishTransaction(&transaction)
+	return &transaction, false, nil
+}
+
+// Fee is 0 because Amazon lists its fees as rows of their own
+func (amazonSettlementFormat) Fee(record []string, headers []string) (Cents, error) {
+	return 0, nil
+}
+
+// Payout reads the settlement's deposit from its summary row
+func (amazonSettlementFormat) Payout(record []string, headers []string, transaction Transaction) (*Payout, error) {
+	row := newCSVRow(record, headers)
+	if !isAmazonSettlementRow(row) {
+		return nil, nil
+	}
+
+	total, err := parseAmountField(row.get("total-amount"))
+	if err != nil {
+		return nil, err
+	}
+	return &Payout{PayoutID: row.get("settlement-id"), Date: transaction.Date, Amount: total, total: true}, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// newBusinessMiles splits a file's mileage into the miles an import adds to
+// the vehicle deduction and the miles it skips. Rows that carry their own
+// miles (DoorDash) count unless they're exact or near duplicates. The rest of
+// the file's miles (a driver summary's) cover the whole period, so they count
+// only when none of its rows had been imported before or resembles one.
+func newBusinessMiles(parsedData *ParsedCSVData, exact map[string]bool, near []NearDuplicate) (added, skipped int) {
+	flagged := make(map[string]bool)
+	for _, match := range near {
+		flagged[match.TransactionID] = true
+	}
+
+	periodMiles := parsedData.BusinessMiles
+	periodNew := true
+	var miles float64
+	for _, tx := range parsedData.Transactions {
+		periodMiles -= tx.miles
+		if exact[tx.ID] || flagged[tx.ID] {
+			periodNew = false
+			continue
+		}
+		miles += tx.miles
+	}
+	if periodNew {
+		miles += periodMiles
+	}
+
+	added = int(math.Round(miles))
+	return added, int(math.Round(parsedData.BusinessMiles)) - added
+}
+
+// addBusinessMiles adjusts the business miles of the current deduction
+// record, creating one if none exists. Miles never go below zero.
+func addBusinessMiles(dbTx *sql.Tx, miles int) error {
+	result, err := dbTx.Exec(`
+		UPDATE deduction_data
+		SET business_miles = MAX(business_miles + ?, 0), updated_at = CURRENT_TIMESTAMP
+		WHERE id = (SELECT id FROM deduction_data ORDER BY updated_at DESC, id DESC LIMIT 1)
+	`, miles)
+	if err != nil {
+		return fmt.Errorf("failed to update business miles: %v", err)
+	}
+	if updated, _ := result.RowsAffected(); updated > 0 || miles <= 0 {
+		return nil
+	}
+
+	if _, err := dbTx.Exec("INSERT INTO deduction_data (business_miles, updated_at) VALUES (?, CURRENT_TIMESTAMP)", miles); err != nil {
+		return fmt.Errorf("failed to save business miles: %v", err)
+	}
+	return nil
+}
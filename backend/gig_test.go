@@ -0,0 +1,178 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var amazonSettlementHeaders = strings.Split("settlement-id\tsettlement-start-date\tsettlement-end-date\tdeposit-date\ttotal-amount\tcurrency\t"+
+	"transaction-type\torder-id\tmerchant-order-id\tadjustment-id\tshipment-id\tmarketplace-name\tamount-type\tamount-description\tamount\t"+
+	"fulfillment-id\tposted-date\tposted-date-time\torder-item-code\tmerchant-order-item-id\tmerchant-adjustment-item-id\tsku\tquantity-purchased\tpromotion-id", "\t")
+
+// amazonRow builds a settlement line from the columns that vary
+func amazonRow(transactionType, amountType, description, amount string) []string {
+	row := make([]string, len(amazonSettlementHeaders))
+	row[0] = "111"
+	row[5] = "USD"
+	row[6] = transactionType
+	row[7] = "A-1"
+	row[11] = "Amazon.com"
+	row[12] = amountType
+	row[13] = description
+	row[14] = amount
+	row[16] = "2024-03-10"
+	return row
+}
+
+// Gig and marketplace earnings are business income whatever the upload's
+// source; only the platform's fees are expenses
+func TestGigEarningsAreIncome(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     string
+		headers    []string
+		records    [][]string // Earlier records set up state, such as section headings
+		wantType   string
+		wantAmount Cents
+	}{
+		{
+			name:       "uber fares",
+			format:     "driver_summary",
+			headers:    []string{"Uber Tax Summary 2024"},
+			records:    [][]string{{"Gross fares breakdown"}, {"Trip fares", "$23,456.78"}},
+			wantType:   "income",
+			wantAmount: 2345678,
+		},
+		{
+			name:       "lyft tips",
+			format:     "driver_summary",
+			headers:    []string{"Lyft Annual Summary 2024"},
+			records:    [][]string{{"Other income"}, {"Tips", "$789.00"}},
+			wantType:   "income",
+			wantAmount: 78900,
+		},
+		{
+			name:       "uber service fee",
+			format:     "driver_summary",
+			headers:    []string{"Uber Tax Summary 2024"},
+			records:    [][]string{{"Uber fees"}, {"Service fee", "$4,567.89"}},
+			wantType:   "expense",
+			wantAmount: 456789,
+		},
+		{
+			name:       "doordash delivery",
+			format:     "doordash",
+			headers:    []string{"Date", "Store Name", "Base Pay", "Peak Pay", "Customer Tips", "Total Pay", "Miles", "Delivery ID"},
+			records:    [][]string{{"03/01/2024", "Chipotle", "3.00", "1.00", "5.50", "9.50", "4.2", "D1"}},
+			wantType:   "income",
+			wantAmount: 950,
+		},
+		{
+			name:   "etsy order without sales tax",
+			format: "etsy_orders",
+			headers: []string{"Sale Date", "Order ID", "Full Name", "Order Value", "Shipping", "Sales Tax", "Order Total",
+				"Card Processing Fees", "Order Net", "Adjusted Order Total", "Adjusted Card Processing Fees"},
+			records:    [][]string{{"03/15/24", "3001", "Alice Smith", "40.00", "5.00", "3.60", "48.60", "1.71", "46.89", "", ""}},
+			wantType:   "income",
+			wantAmount: 4500,
+		},
+		{
+			name:       "etsy statement sale",
+			format:     "etsy",
+			headers:    []string{"Date", "Type", "Title", "Info", "Currency", "Amount", "Fees & Taxes", "Net", "Tax Details"},
+			records:    [][]string{{"March 15, 2024", "Sale", "Payment for Order #3001", "", "USD", "$48.60", "--", "$48.60", "--"}},
+			wantType:   "income",
+			wantAmount: 4860,
+		},
+		{
+			name:       "etsy statement refund to buyer",
+			format:     "etsy",
+			headers:    []string{"Date", "Type", "Title", "Info", "Currency", "Amount", "Fees & Taxes", "Net", "Tax Details"},
+			records:    [][]string{{"March 20, 2024", "Refund", "Refund for Order #3002", "", "USD", "-$10.00", "--", "-$10.00", "--"}},
+			wantType:   "income",
+			wantAmount: -1000,
+		},
+		{
+			name:       "amazon item price",
+			format:     "amazon",
+			headers:    amazonSettlementHeaders,
+			records:    [][]string{amazonRow("Order", "ItemPrice", "Principal", "100.00")},
+			wantType:   "income",
+			wantAmount: 10000,
+		},
+		{
+			name:       "amazon refund to buyer",
+			format:     "amazon",
+			headers:    amazonSettlementHeaders,
+			records:    [][]string{amazonRow("Refund", "ItemPrice", "Principal", "-20.00")},
+			wantType:   "income",
+			wantAmount: -2000,
+		},
+		{
+			name:       "amazon commission",
+			format:     "amazon",
+			headers:    amazonSettlementHeaders,
+			records:    [][]string{amazonRow("Order", "ItemFees", "Commission", "-15.00")},
+			wantType:   "expense",
+			wantAmount: 1500,
+		},
+	}
+
+	for _, tt := range tests {
+		for _, source := range []string{"expenses", "income", ""} {
+			t.Run(tt.name+"/"+source, func(t *testing.T) {
+				format := detectCSVFormat(tt.headers)
+				if format.Name() != tt.format {
+					t.Fatalf("detected %s, want %s", format.Name(), tt.format)
+				}
+				if perFile, ok := format.(fileFormat); ok {
+					format = perFile.newFile()
+				}
+
+				var parsed *Transaction
+				for _, record := range tt.records {
+					tx, _, err := parseTransactionRecord(record, tt.headers, format, "file", source, "upload.csv", dateOrderMonthFirst)
+					if err != nil && err != errSkipRecord {
+						t.Fatalf("parse %v: %v", record, err)
+					}
+					parsed = tx
+				}
+				if parsed == nil {
+					t.Fatal("last record parsed to no transaction")
+				}
+
+				if parsed.Type != tt.wantType {
+					t.Errorf("type = %s, want %s", parsed.Type, tt.wantType)
+				}
+				if parsed.Amount != tt.wantAmount {
+					t.Errorf("amount = %d, want %d", parsed.Amount, tt.wantAmount)
+				}
+				if !parsed.Expensable {
+					t.Errorf("expensable = false, want true so it counts on Schedule C")
+				}
+			})
+		}
+	}
+}
+
+// Miles already imported with earlier rows aren't added to the deduction again
+func TestNewBusinessMiles(t *testing.T) {
+	doorDash := &ParsedCSVData{
+		Transactions:  []Transaction{{ID: "d1", miles: 4.2}, {ID: "d2", miles: 10}, {ID: "d3", miles: 6}},
+		BusinessMiles: 20.2,
+	}
+	if added, skipped := newBusinessMiles(doorDash, map[string]bool{"d1": true}, []NearDuplicate{{TransactionID: "d3"}}); added != 10 || skipped != 10 {
+		t.Errorf("doordash: added %d and skipped %d miles, want 10 and 10", added, skipped)
+	}
+
+	summary := &ParsedCSVData{
+		Transactions:  []Transaction{{ID: "fares"}, {ID: "fee"}},
+		BusinessMiles: 20123,
+	}
+	if added, skipped := newBusinessMiles(summary, nil, nil); added != 20123 || skipped != 0 {
+		t.Errorf("new summary: added %d and skipped %d miles, want 20123 and 0", added, skipped)
+	}
+	if added, skipped := newBusinessMiles(summary, map[string]bool{"fares": true}, nil); added != 0 || skipped != 20123 {
+		t.Errorf("summary imported before: added %d and skipped %d miles, want 0 and 20123", added, skipped)
+	}
+}
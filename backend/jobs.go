@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Job is a background categorization run. Only one runs at a time; a run
+// requested meanwhile is queued behind it so rows uploaded during the run
+// are picked up without classifying anything twice.
+type Job struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`    // "categorize"
+	Trigger    string          `json:"trigger"` // "api", "upload" or "categorize" (the blocking endpoint)
+	Status     string          `json:"status"`  // "queued", "running", "completed", "failed" or "cancelled"
+	Total      int             `json:"total"`
+	Processed  int             `json:"processed"`
+	Failed     int             `json:"failed"`
+	Remaining  int             `json:"remaining"`
+	Errors     []string        `json:"errors"`
+	Stats      CategorizeStats `json:"stats"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{} // Closed when the job finishes
+}
+
+// jobTTL is how long a finished job can still be looked up
+const jobTTL = time.Hour
+
+var (
+	jobsMu sync.Mutex
+	jobs   = make(map[string]*Job)
+
+	// The categorization job that is running and the one queued behind it
+	runningCategorizeJob *Job
+	queuedCategorizeJob  *Job
+)
+
+func (job *Job) finished() bool {
+	return job.Status == "completed" || job.Status == "failed" || job.Status == "cancelled"
+}
+
+// updateLocked copies the run's progress onto the job; callers hold jobsMu
+func (job *Job) updateLocked(stats CategorizeStats) {
+	job.Stats = stats
+	job.Total = stats.Total
+	job.Processed = stats.Classified
+	job.Failed = stats.Failed
+	job.Remaining = stats.Total - stats.Classified - stats.Failed
+	job.Errors = append([]string{}, stats.Errors...)
+}
+
+// snapshot returns a copy of the job that is safe to encode
+func (job *Job) snapshot() Job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	return job.copyLocked()
+}
+
+func (job *Job) copyLocked() Job {
+	copied := *job
+	copied.Errors = append([]string{}, job.Errors...)
+	return copied
+}
+
+// startCategorizeJob starts a categorization job, or queues one if a job is
+// already running. If one is already queued it is returned instead, since it
+// will see every row that is uncategorized when it starts.
+func startCategorizeJob(trigger string) *Job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	expireJobsLocked()
+
+	if queuedCategorizeJob != nil {
+		return queuedCategorizeJob
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:        uuid.New().String(),
+		Type:      "categorize",
+		Trigger:   trigger,
+		Status:    "queued",
+		Errors:    []string{},
+		CreatedAt: time.Now(),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	jobs[job.ID] = job
+
+	if runningCategorizeJob != nil {
+		queuedCategorizeJob = job
+		log.Printf("🗂️ Categorization job %s queued behind %s", job.ID, runningCategorizeJob.ID)
+		return job
+	}
+
+	runningCategorizeJob = job
+	go runCategorizeJob(job)
+	return job
+}
+
+func runCategorizeJob(job *Job) {
+	jobsMu.Lock()
+	now := time.Now()
+	job.Status = "running"
+	job.StartedAt = &now
+	jobsMu.Unlock()
+
+	log.Printf("🤖 Categorization job %s started (%s)", job.ID, job.Trigger)
+	publishEvent(EventJobStarted, job.snapshot())
+	stats, err := categorizeUncategorizedTransactions(job.ctx, func(stats CategorizeStats, classified []ClassifiedTransaction) {
+		jobsMu.Lock()
+		job.updateLocked(stats)
+		jobsMu.Unlock()
+
+		if len(classified) > 0 {
+			publishEvent(EventBatchClassified, BatchClassifiedEvent{
+				JobID:        job.ID,
+				Transactions: classified,
+				Processed:    stats.Classified,
+				Total:        stats.Total,
+			})
+		}
+	})
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	job.updateLocked(stats)
+	finishedAt := time.Now()
+	job.FinishedAt = &finishedAt
+	eventType := EventJobFinished
+	switch {
+	case job.ctx.Err() != nil:
+		job.Status = "cancelled"
+		log.Printf("🛑 Categorization job %s cancelled after %d/%d transactions", job.ID, job.Processed, job.Total)
+	case err != nil:
+		job.Status = "failed"
+		job.Errors = append(job.Errors, err.Error())
+		eventType = EventJobFailed
+		log.Printf("❌ Categorization job %s failed: %v", job.ID, err)
+	default:
+		job.Status = "completed"
+		log.Printf("✅ Categorization job %s completed: %d processed, %d failed, %d remaining", job.ID, job.Processed, job.Failed, job.Remaining)
+	}
+	job.cancel()
+	close(job.done)
+	publishEvent(eventType, job.copyLocked())
+
+	runningCategorizeJob = nil
+	if next := queuedCategorizeJob; next != nil {
+		queuedCategorizeJob = nil
+		runningCategorizeJob = next
+		go runCategorizeJob(next)
+	}
+}
+
+// cancelJob stops a queued or running job. A running job stops before its
+// next LLM batch.
+func cancelJob(job *Job) error {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	if job.finished() {
+		return fmt.Errorf("job already %s", job.Status)
+	}
+
+	job.cancel()
+	if job == queuedCategorizeJob {
+		queuedCategorizeJob = nil
+		now := time.Now()
+		job.Status = "cancelled"
+		job.FinishedAt = &now
+		close(job.done)
+		publishEvent(EventJobFinished, job.copyLocked())
+	}
+	return nil
+}
+
+func expireJobsLocked() {
+	for id, job := range jobs {
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > jobTTL {
+			delete(jobs, id)
+		}
+	}
+}
+
+func lookupJob(id string) *Job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	expireJobsLocked()
+	return jobs[id]
+}
+
+func createCategorizeJob(w http.ResponseWriter, r *http.Request) {
+	job := startCategorizeJob("api")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"job_id":  job.ID,
+		"job":     job.snapshot(),
+	})
+}
+
+func getJobs(w http.ResponseWriter, r *http.Request) {
+	jobsMu.Lock()
+	expireJobsLocked()
+	list := make([]*Job, 0, len(jobs))
+	for _, job := range jobs {
+		list = append(list, job)
+	}
+	jobsMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	snapshots := make([]Job, len(list))
+	for i, job := range list {
+		snapshots[i] = job.snapshot()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"jobs":    snapshots,
+		"count":   len(snapshots),
+	})
+}
+
+func getJob(w http.ResponseWriter, r *http.Request) {
+	job := lookupJob(chi.URLParam(r, "id"))
+	if job == nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"job":     job.snapshot(),
+	})
+}
+
+func deleteJob(w http.ResponseWriter, r *http.Request) {
+	job := lookupJob(chi.URLParam(r, "id"))
+	if job == nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	if err := cancelJob(job); err != nil {
+		http.Error(w, fmt.Sprintf("Cannot cancel: %v", err), http.StatusConflict)
+		return
+	}
+	log.Printf("🛑 Cancellation requested for job %s", job.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Cancellation requested",
+		"job":     job.snapshot(),
+	})
+}
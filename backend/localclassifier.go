@@ -0,0 +1,451 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// localMinConfidence is the confidence a local classification needs before
+// it is saved; anything below goes to the configured LLM provider
+// (CLASSIFIER_LOCAL_MIN_CONFIDENCE, default 0.75)
+var localMinConfidence = 0.75
+
+func loadLocalMinConfidence() error {
+	value := os.Getenv("CLASSIFIER_LOCAL_MIN_CONFIDENCE")
+	if value == "" {
+		return nil
+	}
+	confidence, err := strconv.ParseFloat(value, 64)
+	if err != nil || confidence <= 0 || confidence > 1 {
+		return fmt.Errorf("CLASSIFIER_LOCAL_MIN_CONFIDENCE must be between 0 and 1, got %q", value)
+	}
+	localMinConfidence = confidence
+	return nil
+}
+
+// merchantRule maps merchant keywords, issuer categories or merchant
+// category codes to a Schedule C category
+type merchantRule struct {
+	Keywords []string // Whole words or phrases, matched case-insensitively
+	Category string
+	Line     int
+	Purpose  string
+}
+
+// Confidence of each dictionary; user history can outrank them
+const (
+	mccConfidence            = 0.9
+	keywordConfidence        = 0.85
+	issuerCategoryConfidence = 0.8
+)
+
+// merchantKeywords is checked in order against the vendor and description,
+// so specific phrases ("uber eats") come before general ones ("uber")
+var merchantKeywords = []merchantRule{
+	{[]string{"uber eats", "doordash", "grubhub", "postmates", "seamless", "caviar"}, "Meals", 24, "Business meal delivery"},
+	{[]string{"amazon web services", "aws", "digitalocean", "linode", "heroku", "vercel", "netlify", "cloudflare", "google cloud", "azure"}, "Utilities", 26, "Cloud hosting"},
+	{[]string{"verizon", "t mobile", "at t", "sprint", "comcast", "xfinity", "spectrum", "cox communications", "google fi", "mint mobile", "twilio"}, "Utilities", 26, "Phone and internet"},
+	{[]string{"adobe", "microsoft", "github", "slack", "zoom us", "zoom com", "notion", "atlassian", "google workspace", "gsuite", "dropbox", "figma", "canva", "openai", "quickbooks", "intuit", "1password", "jetbrains"}, "Office expenses", 18, "Software subscription"},
+	{[]string{"staples", "office depot", "officemax", "the ups store", "fedex", "usps", "stamps com", "pirate ship"}, "Office expenses", 18, "Office supplies and postage"},
+	{[]string{"facebook ads", "facebk", "meta ads", "google ads", "linkedin ads", "mailchimp", "vistaprint", "moo com"}, "Advertising", 8, "Advertising"},
+	{[]string{"delta air", "american airlines", "united airlines", "southwest", "jetblue", "alaska air", "spirit airl", "frontier airlines", "allegiant", "air canada", "british airways", "lufthansa"}, "Travel expenses", 25, "Business airfare"},
+	{[]string{"airbnb", "vrbo", "marriott", "hilton", "hyatt", "holiday inn", "best western", "hampton inn", "expedia", "booking com", "hotels com", "hotel", "motel"}, "Travel expenses", 25, "Business lodging"},
+	{[]string{"uber", "lyft", "amtrak", "greyhound", "taxi", "hertz", "avis", "enterprise rent", "national car", "budget rent", "alamo"}, "Travel expenses", 25, "Business ground transportation"},
+	{[]string{"shell", "chevron", "exxon", "exxonmobil", "mobil", "valero", "sunoco", "texaco", "citgo", "marathon petro", "speedway", "parkmobile", "spothero", "parking", "ez pass", "ezpass", "sunpass", "fastrak", "jiffy lube"}, "Car and truck", 9, "Business vehicle expense"},
+	{[]string{"starbucks", "dunkin", "chipotle", "panera", "mcdonald", "restaurant", "cafe", "coffee", "bistro", "grill", "pizza", "taqueria", "sushi"}, "Meals", 24, "Business meal"},
+	{[]string{"geico", "progressive", "state farm", "allstate", "hiscox", "next insurance", "insurance"}, "Insurance", 15, "Business insurance"},
+	{[]string{"legalzoom", "attorney", "law office", "law firm", "cpa", "turbotax", "h r block"}, "Legal fees and professional services", 17, "Professional services"},
+	{[]string{"upwork", "fiverr", "toptal"}, "Contractors", 11, "Contract labor"},
+	{[]string{"wework", "regus", "industrious", "coworking", "public storage", "extra space storage", "cubesmart"}, "Rent and lease", 20, "Workspace or storage rent"},
+	{[]string{"secretary of state", "franchise tax", "business license", "dmv", "irs"}, "Taxes and licenses", 23, "Business taxes and licenses"},
+	{[]string{"interest charge", "finance charge"}, "Interest paid", 16, "Business card interest"},
+	{[]string{"annual fee", "foreign transaction fee", "service fee"}, "Commissions and fees", 10, "Account fees"},
+	{[]string{"home depot", "lowes", "menards", "best buy", "micro center", "b h photo"}, "Supplies", 22, "Business supplies"},
+}
+
+// issuerCategories maps the category a card issuer put on the row (Amex
+// "Travel-Airline", Capital One "Airfare", Chase "Food & Drink", ...)
+var issuerCategories = []merchantRule{
+	{[]string{"fuel", "gas", "gas automotive", "automotive", "auto services", "parking", "tolls"}, "Car and truck", 9, "Business vehicle expense"},
+	{[]string{"airline", "airfare", "lodging", "hotel", "travel", "rail", "taxis", "car rental", "rideshare"}, "Travel expenses", 25, "Business travel"},
+	{[]string{"restaurant", "restaurants", "dining", "food drink", "bar"}, "Meals", 24, "Business meal"},
+	{[]string{"cable internet", "internet services", "telecom", "phone", "utilities"}, "Utilities", 26, "Phone and internet"},
+	{[]string{"computer supplies", "office supplies", "mailing shipping"}, "Office expenses", 18, "Office supplies and postage"},
+	{[]string{"advertising"}, "Advertising", 8, "Advertising"},
+	{[]string{"professional services", "legal"}, "Legal fees and professional services", 17, "Professional services"},
+	{[]string{"contracting services"}, "Contractors", 11, "Contract labor"},
+	{[]string{"insurance"}, "Insurance", 15, "Business insurance"},
+	{[]string{"government services"}, "Taxes and licenses", 23, "Business taxes and licenses"},
+	{[]string{"fees adjustments"}, "Commissions and fees", 10, "Account fees"},
+}
+
+// mccRange maps a block of merchant category codes (ISO 18245), also used
+// for the SIC codes some OFX files carry
+type mccRange struct {
+	From, To int
+	Rule     merchantRule
+}
+
+var mccRanges = []mccRange{
+	{3000, 3350, merchantRule{Category: "Travel expenses", Line: 25, Purpose: "Business airfare"}},
+	{3351, 3500, merchantRule{Category: "Travel expenses", Line: 25, Purpose: "Business car rental"}},
+	{3501, 3999, merchantRule{Category: "Travel expenses", Line: 25, Purpose: "Business lodging"}},
+	{4111, 4131, merchantRule{Category: "Travel expenses", Line: 25, Purpose: "Business ground transportation"}},
+	{4511, 4511, merchantRule{Category: "Travel expenses", Line: 25, Purpose: "Business airfare"}},
+	{4722, 4722, merchantRule{Category: "Travel expenses", Line: 25, Purpose: "Business travel"}},
+	{7011, 7011, merchantRule{Category: "Travel expenses", Line: 25, Purpose: "Business lodging"}},
+	{7512, 7512, merchantRule{Category: "Travel expenses", Line: 25, Purpose: "Business car rental"}},
+	{4784, 4784, merchantRule{Category: "Car and truck", Line: 9, Purpose: "Business vehicle expense"}},
+	{5541, 5542, merchantRule{Category: "Car and truck", Line: 9, Purpose: "Business vehicle expense"}},
+	{7523, 7523, merchantRule{Category: "Car and truck", Line: 9, Purpose: "Business vehicle expense"}},
+	{7531, 7549, merchantRule{Category: "Car and truck", Line: 9, Purpose: "Business vehicle expense"}},
+	{5811, 5814, merchantRule{Category: "Meals", Line: 24, Purpose: "Business meal"}},
+	{4812, 4816, merchantRule{Category: "Utilities", Line: 26, Purpose: "Phone and internet"}},
+	{4899, 4900, merchantRule{Category: "Utilities", Line: 26, Purpose: "Phone and internet"}},
+	{4215, 4215, merchantRule{Category: "Office expenses", Line: 18, Purpose: "Office supplies and postage"}},
+	{5044, 5045, merchantRule{Category: "Office expenses", Line: 18, Purpose: "Office equipment"}},
+	{5111, 5111, merchantRule{Category: "Office expenses", Line: 18, Purpose: "Office supplies and postage"}},
+	{5734, 5734, merchantRule{Category: "Office expenses", Line: 18, Purpose: "Software"}},
+	{5943, 5943, merchantRule{Category: "Office expenses", Line: 18, Purpose: "Office supplies and postage"}},
+	{9402, 9402, merchantRule{Category: "Office expenses", Line: 18, Purpose: "Office supplies and postage"}},
+	{7311, 7311, merchantRule{Category: "Advertising", Line: 8, Purpose: "Advertising"}},
+	{8111, 8111, merchantRule{Category: "Legal fees and professional services", Line: 17, Purpose: "Professional services"}},
+	{8931, 8931, merchantRule{Category: "Legal fees and professional services", Line: 17, Purpose: "Professional services"}},
+	{6300, 6300, merchantRule{Category: "Insurance", Line: 15, Purpose: "Business insurance"}},
+	{9311, 9311, merchantRule{Category: "Taxes and licenses", Line: 23, Purpose: "Business taxes and licenses"}},
+	{9399, 9399, merchantRule{Category: "Taxes and licenses", Line: 23, Purpose: "Business taxes and licenses"}},
+	{5200, 5251, merchantRule{Category: "Supplies", Line: 22, Purpose: "Business supplies"}},
+}
+
+// mccFields are the extra columns that may hold a merchant category code
+var mccFields = []string{"MCC", "Merchant Category Code", "SIC"}
+
+// normalizeText lowercases and turns everything but letters and digits into
+// single spaces, padded so " word " matches whole words
+func normalizeText(text string) string {
+	var b strings.Builder
+	b.WriteByte(' ')
+	space := true
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			space = false
+		} else if !space {
+			b.WriteByte(' ')
+			space = true
+		}
+	}
+	if !space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// matchRules returns the first rule with a keyword in the normalized text
+func matchRules(rules []merchantRule, text string) (*merchantRule, string) {
+	for i := range rules {
+		for _, keyword := range rules[i].Keywords {
+			if strings.Contains(text, normalizeText(keyword)) {
+				return &rules[i], keyword
+			}
+		}
+	}
+	return nil, ""
+}
+
+func matchMCC(extra ExtraFields) (*merchantRule, int) {
+	for _, field := range mccFields {
+		code, err := strconv.Atoi(strings.TrimSpace(extra[field]))
+		if err != nil {
+			continue
+		}
+		for i := range mccRanges {
+			if code >= mccRanges[i].From && code <= mccRanges[i].To {
+				return &mccRanges[i].Rule, code
+			}
+		}
+	}
+	return nil, 0
+}
+
+// issuerCategory returns the card issuer's category still sitting in
+// Category, or "" once the row has a Schedule C category
+func issuerCategory(tx Transaction) string {
+	if tx.ScheduleCLine != 0 || tx.Category == "" || tx.Category == "uncategorized" {
+		return ""
+	}
+	return tx.Category
+}
+
+// Stop words that say nothing about the merchant
+var classifierStopWords = map[string]bool{
+	"the": true, "and": true, "com": true, "www": true, "inc": true, "llc": true,
+	"usa": true, "pos": true, "purchase": true, "debit": true, "card": true, "payment": true,
+	"aplpay": true, "tst": true, "sq": true, "null": true,
+}
+
+// classifierTokens splits vendor and description into distinct words of at
+// least three letters, dropping numbers and stop words
+func classifierTokens(tx Transaction) []string {
+	seen := make(map[string]bool)
+	var tokens []string
+	for _, word := range strings.Fields(normalizeText(tx.Vendor + " " + tx.Description)) {
+		if len([]rune(word)) < 3 || classifierStopWords[word] || strings.IndexFunc(word, unicode.IsDigit) >= 0 {
+			continue
+		}
+		if !seen[word] {
+			seen[word] = true
+			tokens = append(tokens, word)
+		}
+	}
+	// The transaction type keeps income and expense examples apart
+	tokens = append(tokens, "type:"+tx.Type)
+	return tokens
+}
+
+// classStats holds the training examples for one category and line
+type classStats struct {
+	Category   string
+	Line       int
+	Examples   int
+	Expensable int
+	Tokens     map[string]int
+	TokenTotal int
+	Purposes   map[string]int
+}
+
+// localClassifier classifies transactions without leaving the machine: the
+// user's own classification history (by vendor, then a naive Bayes model over
+// vendor and description words) and the merchant dictionaries above
+type localClassifier struct {
+	classes    map[string]*classStats
+	vocabulary map[string]bool
+	examples   int
+	vendors    map[string]map[string]int // Normalized vendor -> class key -> examples
+}
+
+// minBayesExamples is how many past classifications the naive Bayes model
+// needs before it is trusted at full confidence
+const minBayesExamples = 20
+
+func classKey(category string, line int) string {
+	return fmt.Sprintf("%d|%s", line, category)
+}
+
+// trainLocalClassifier learns from transactions the user classified by hand
+// or through a vendor rule
+func trainLocalClassifier() (*localClassifier, error) {
+	rows, err := db.Query(`
+		SELECT COALESCE(vendor, ''), COALESCE(description, ''), COALESCE(type, ''), category, schedule_c_line,
+		       expensable, COALESCE(purpose, '')
+		FROM transactions
+		WHERE classified_by IN ('manual', 'vendor_rule') AND schedule_c_line BETWEEN 8 AND 27 AND category <> ''`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load training examples: %v", err)
+	}
+	defer rows.Close()
+
+	local := &localClassifier{
+		classes:    make(map[string]*classStats),
+		vocabulary: make(map[string]bool),
+		vendors:    make(map[string]map[string]int),
+	}
+	for rows.Next() {
+		var tx Transaction
+		if err := rows.Scan(&tx.Vendor, &tx.Description, &tx.Type, &tx.Category, &tx.ScheduleCLine, &tx.Expensable, &tx.Purpose); err != nil {
+			return nil, fmt.Errorf("failed to scan training example: %v", err)
+		}
+		local.learn(tx)
+	}
+	return local, rows.Err()
+}
+
+func (c *localClassifier) learn(tx Transaction) {
+	key := classKey(tx.Category, tx.ScheduleCLine)
+	class := c.classes[key]
+	if class == nil {
+		class = &classStats{Category: tx.Category, Line: tx.ScheduleCLine, Tokens: make(map[string]int), Purposes: make(map[string]int)}
+		c.classes[key] = class
+	}
+	class.Examples++
+	if tx.Expensable {
+		class.Expensable++
+	}
+	if tx.Purpose != "" {
+		class.Purposes[tx.Purpose]++
+	}
+	for _, token := range classifierTokens(tx) {
+		class.Tokens[token]++
+		class.TokenTotal++
+		c.vocabulary[token] = true
+	}
+	c.examples++
+
+	vendor := strings.TrimSpace(normalizeText(tx.Vendor))
+	if vendor != "" {
+		if c.vendors[vendor] == nil {
+			c.vendors[vendor] = make(map[string]int)
+		}
+		c.vendors[vendor][key]++
+	}
+}
+
+func (c *localClassifier) Name() string { return "local" }
+
+// Examples is the number of past classifications the model was trained on
+func (c *localClassifier) Examples() int { return c.examples }
+
+// classification builds the result for a learned class
+func (c *localClassifier) classification(class *classStats, confidence float64) *ExpenseClassification {
+	purpose := ""
+	best := 0
+	for text, count := range class.Purposes {
+		if count > best || (count == best && text < purpose) {
+			purpose, best = text, count
+		}
+	}
+	return &ExpenseClassification{
+		Category:      class.Category,
+		ScheduleCLine: class.Line,
+		Expensable:    class.Expensable*2 >= class.Examples,
+		Purpose:       purpose,
+		Confidence:    confidence,
+	}
+}
+
+// vendorHistory classifies a vendor the user has classified before, as long
+// as they always used the same category
+func (c *localClassifier) vendorHistory(tx Transaction) *ExpenseClassification {
+	history := c.vendors[strings.TrimSpace(normalizeText(tx.Vendor))]
+	if len(history) != 1 {
+		return nil
+	}
+	for key, count := range history {
+		confidence := 0.8
+		if count > 1 {
+			confidence = 0.95
+		}
+		return c.classification(c.classes[key], confidence)
+	}
+	return nil
+}
+
+// bayes scores every learned class with multinomial naive Bayes (Laplace
+// smoothing) and returns the most likely one. The posterior is scaled down
+// while there are fewer than minBayesExamples examples.
+func (c *localClassifier) bayes(tx Transaction) *ExpenseClassification {
+	if len(c.classes) < 2 {
+		return nil
+	}
+
+	var known []string
+	for _, token := range classifierTokens(tx) {
+		if c.vocabulary[token] && !strings.HasPrefix(token, "type:") {
+			known = append(known, token)
+		}
+	}
+	if len(known) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(c.classes))
+	for key := range c.classes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	features := append(known, "type:"+tx.Type)
+	vocabulary := float64(len(c.vocabulary))
+	scores := make([]float64, len(keys))
+	best := 0
+	for i, key := range keys {
+		class := c.classes[key]
+		score := math.Log(float64(class.Examples) / float64(c.examples))
+		for _, token := range features {
+			score += math.Log((float64(class.Tokens[token]) + 1) / (float64(class.TokenTotal) + vocabulary))
+		}
+		scores[i] = score
+		if score > scores[best] {
+			best = i
+		}
+	}
+
+	total := 0.0
+	for _, score := range scores {
+		total += math.Exp(score - scores[best])
+	}
+	confidence := 1 / total
+	if c.examples < minBayesExamples {
+		confidence *= float64(c.examples) / minBayesExamples
+	}
+	return c.classification(c.classes[keys[best]], confidence)
+}
+
+// dictionary classifies business expenses by merchant category code, known
+// merchant name or the issuer's category, in that order
+func (c *localClassifier) dictionary(tx Transaction) *ExpenseClassification {
+	if tx.Type != "expense" {
+		return nil
+	}
+
+	result := func(rule *merchantRule, confidence float64, purpose string) *ExpenseClassification {
+		if purpose == "" {
+			purpose = rule.Purpose
+		}
+		return &ExpenseClassification{
+			Category:      rule.Category,
+			ScheduleCLine: rule.Line,
+			Expensable:    true,
+			Purpose:       purpose,
+			Confidence:    confidence,
+		}
+	}
+
+	if rule, _ := matchMCC(tx.Extra); rule != nil {
+		return result(rule, mccConfidence, "")
+	}
+	if rule, _ := matchRules(merchantKeywords, normalizeText(tx.Vendor+" "+tx.Description)); rule != nil {
+		return result(rule, keywordConfidence, "")
+	}
+	if category := issuerCategory(tx); category != "" {
+		if rule, _ := matchRules(issuerCategories, normalizeText(category)); rule != nil {
+			return result(rule, issuerCategoryConfidence, "")
+		}
+	}
+	return nil
+}
+
+// best returns the most confident local classification, or nil
+func (c *localClassifier) best(tx Transaction) *ExpenseClassification {
+	var best *ExpenseClassification
+	for _, candidate := range []*ExpenseClassification{c.vendorHistory(tx), c.bayes(tx), c.dictionary(tx)} {
+		if candidate != nil && (best == nil || candidate.Confidence > best.Confidence) {
+			best = candidate
+		}
+	}
+	return best
+}
+
+// ClassifyBatch returns only the classifications that reach localMinConfidence
+func (c *localClassifier) ClassifyBatch(transactions []Transaction) (map[string]*ExpenseClassification, error) {
+	results := make(map[string]*ExpenseClassification)
+	for _, tx := range transactions {
+		if classification := c.best(tx); classification != nil && classification.Confidence >= localMinConfidence {
+			results[tx.ID] = classification
+		}
+	}
+	return results, nil
+}
+
+func (c *localClassifier) Classify(tx Transaction) (*ExpenseClassification, error) {
+	classification := c.best(tx)
+	if classification == nil || classification.Confidence < localMinConfidence {
+		return nil, fmt.Errorf("no confident local classification for %s", tx.Vendor)
+	}
+	return classification, nil
+}
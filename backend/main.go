@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -21,14 +24,14 @@ import (
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	"github.com/jung-kurt/gofpdf"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 type Transaction struct {
 	ID            string    `json:"id" db:"id"`
 	Date          time.Time `json:"date" db:"date"`
 	Vendor        string    `json:"vendor" db:"vendor"`
-	Amount        float64   `json:"amount" db:"amount"`
+	Amount        Cents     `json:"amount" db:"amount_cents"`
 	Card          string    `json:"card" db:"card"`
 	Category      string    `json:"category" db:"category"`
 	Purpose       string    `json:"purpose" db:"purpose"`
@@ -39,13 +42,41 @@ type Transaction struct {
 	IsBusiness    bool      `json:"is_business" db:"is_business"`         // User toggle for business vs personal
 	SortCategory  string    `json:"sort_category" db:"sort_category"`     // Sortable category string
 	SortBusiness  string    `json:"sort_business" db:"sort_business"`     // "Business" or "Personal" for sorting
+	ExternalID    string    `json:"external_id" db:"external_id"`         // Bank-assigned ID (OFX FITID, statement reference), used for deduplication
+	Description   string    `json:"description" db:"description"`         // Description exactly as it appeared in the bank file
+	Fingerprint   string    `json:"fingerprint" db:"fingerprint"`         // Deterministic hash used to skip re-imported rows
+	DuplicateOf   string    `json:"duplicate_of" db:"duplicate_of"`       // Existing transaction this one may duplicate, pending review
+	RefundOf      string    `json:"refund_of" db:"refund_of"`             // Purchase a refund was matched to
+	FeeOf         string    `json:"fee_of" db:"fee_of"`                   // Sale a payment processor fee was charged on
+	ClassifiedBy  string    `json:"classified_by" db:"classified_by"`     // "manual", "vendor_rule", "local", "cache" or the LLM provider; "" if never classified
+
+	// Merchant details and the export's remaining columns, kept for audit and classification
+	MerchantAddress string      `json:"merchant_address" db:"merchant_address"`
+	MerchantCity    string      `json:"merchant_city" db:"merchant_city"` // e.g. "AUSTIN, TX"
+	MerchantZip     string      `json:"merchant_zip" db:"merchant_zip"`
+	Extra           ExtraFields `json:"extra,omitempty" db:"extra"` // Unmapped source columns by header
+
+	dateOrder          string  // Day/month order of the file being parsed, for the format parser; not stored
+	line               int     // Line in the uploaded file (STMTTRN number for OFX), for error reports; not stored
+	contentFingerprint string  // Fingerprint of the row's content when Fingerprint is keyed by ExternalID; not stored
+	bankAccount        bool    // Row is from a checking or savings account, whose credits are deposits rather than card refunds; not stored
+	miles              float64 // Miles driven for this row (DoorDash), for the vehicle deduction; not stored
 }
 
 type CSVFile struct {
-	ID       string    `json:"id" db:"id"`
-	Filename string    `json:"filename" db:"filename"`
-	Uploaded time.Time `json:"uploaded" db:"uploaded"`
-	Source   string    `json:"source" db:"source"` // "income", "expenses", "both"
+	ID                 string    `json:"id" db:"id"`
+	Filename           string    `json:"filename" db:"filename"`
+	Uploaded           time.Time `json:"uploaded" db:"uploaded"`
+	Source             string    `json:"source" db:"source"` // "income", "expenses", "both"
+	Path               string    `json:"path" db:"path"`     // Stored copy under uploads/
+	Format             string    `json:"format" db:"format"` // Detected format, e.g. "chase" or "ofx"
+	TransactionsParsed int       `json:"transactions_parsed" db:"transactions_parsed"`
+	PaymentsExcluded   int       `json:"payments_excluded" db:"payments_excluded"`
+	RowsRejected       int       `json:"rows_rejected" db:"rows_rejected"`
+	DuplicatesSkipped  int       `json:"duplicates_skipped" db:"duplicates_skipped"`
+	DateOrder          string    `json:"date_order" db:"date_order"`                     // "month_first" or "day_first"; empty when the file has no numeric dates
+	DateOrderAmbiguous bool      `json:"date_order_ambiguous" db:"date_order_ambiguous"` // No date decided the order, so month-first was assumed
+	BusinessMiles      int       `json:"business_miles" db:"business_miles"`             // Driver mileage added to the vehicle deduction
 }
 
 type UploadResponse struct {
@@ -57,31 +88,61 @@ type UploadResponse struct {
 	Source             string `json:"source"`
 	TransactionsParsed int    `json:"transactions_parsed"`
 	PaymentsExcluded   int    `json:"payments_excluded"`
+	DuplicatesSkipped  int    `json:"duplicates_skipped"`
+	RowsRejected       int    `json:"rows_rejected"`
+	Format             string `json:"format"`
+	// Day/month order used for numeric dates. When ambiguous, every date in
+	// the file fit either order and month-first was assumed; re-upload with
+	// date_order=day_first if that's wrong.
+	DateOrder          string `json:"date_order,omitempty"`
+	DateOrderAmbiguous bool   `json:"date_order_ambiguous"`
+	// XLSX only: the worksheet that was read and every sheet in the workbook,
+	// so another can be picked with the sheet form field
+	Sheet  string   `json:"sheet,omitempty"`
+	Sheets []string `json:"sheets,omitempty"`
+	// Stripe, Square and PayPal only: payouts in the file, and how many
+	// payouts were matched to bank deposits once it was saved
+	Payouts           []Payout `json:"payouts,omitempty"`
+	PayoutsReconciled int      `json:"payouts_reconciled,omitempty"`
+	// Driver exports only: miles added to the vehicle deduction, and miles
+	// left out because their rows were imported before or resemble existing
+	// ones, so they would be counted twice
+	BusinessMiles        int `json:"business_miles,omitempty"`
+	BusinessMilesSkipped int `json:"business_miles_skipped,omitempty"`
+	// Saved mapping profile whose header signature matches this file, when
+	// the file was parsed without one
+	SuggestedProfileID   int        `json:"suggested_profile_id,omitempty"`
+	SuggestedProfileName string     `json:"suggested_profile_name,omitempty"`
+	RejectedRows         []RowIssue `json:"rejected_rows"`
+	// Rows that look like existing transactions (same amount and vendor
+	// within two days). They are imported and flagged for review.
+	NearDuplicates []NearDuplicate `json:"near_duplicates"`
+
+	// Dry-run only: what would be imported, and the token to commit it with
+	DryRun           bool          `json:"dry_run,omitempty"`
+	PreviewToken     string        `json:"preview_token,omitempty"`
+	ExpiresAt        *time.Time    `json:"expires_at,omitempty"`
+	Transactions     []Transaction `json:"transactions,omitempty"`
+	ExcludedPayments []RowIssue    `json:"excluded_payments,omitempty"`
+
+	// Background job categorizing the new rows; poll GET /jobs/{id}
+	CategorizeJobID string `json:"categorize_job_id,omitempty"`
 }
 
 type ParsedCSVData struct {
-	Transactions     []Transaction `json:"transactions"`
-	PaymentsExcluded int           `json:"payments_excluded"`
-	ParsedCount      int           `json:"parsed_count"`
-}
-
-// OpenRouter API structures
-type OpenRouterRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
-}
-
-type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
-type OpenRouterResponse struct {
-	Choices []Choice `json:"choices"`
-}
-
-type Choice struct {
-	Message Message `json:"message"`
+	Transactions       []Transaction `json:"transactions"` // Includes excluded payments as type "transfer"
+	PaymentsExcluded   int           `json:"payments_excluded"`
+	ParsedCount        int           `json:"parsed_count"` // Transactions other than transfers
+	Format             string        `json:"format"`
+	HeaderSignature    string        `json:"header_signature"` // Signature of the file's first row
+	DateOrder          string        `json:"date_order"`
+	DateOrderAmbiguous bool          `json:"date_order_ambiguous"` // Month-first was assumed; no date decided the order
+	Sheet              string        `json:"sheet,omitempty"`      // XLSX worksheet that was read
+	Sheets             []string      `json:"sheets,omitempty"`     // Every worksheet in the workbook
+	Payouts            []Payout      `json:"payouts,omitempty"`    // Processor payouts, for matching to bank deposits
+	BusinessMiles      float64       `json:"business_miles"`       // Driver mileage in the file
+	ExcludedPayments   []RowIssue    `json:"excluded_payments"`
+	RejectedRows       []RowIssue    `json:"rejected_rows"`
 }
 
 type ExpenseClassification struct {
@@ -110,7 +171,9 @@ type ScheduleCCategory struct {
 }
 
 var db *sql.DB
-var openRouterAPIKey string
+
+// maxUploadBytes caps the size of an upload request (MAX_UPLOAD_MB, default 100)
+var maxUploadBytes int64 = 100 << 20
 
 func main() {
 	// Load environment variables
@@ -118,13 +181,26 @@ func main() {
 		log.Printf("Warning: Could not load .env file: %v", err)
 	}
 
-	openRouterAPIKey = os.Getenv("OPENROUTER_API_KEY")
-	if openRouterAPIKey == "" {
-		log.Fatal("OPENROUTER_API_KEY environment variable is required")
+	var err error
+	classifier, err = newClassifierFromEnv()
+	if err != nil {
+		log.Fatal("Invalid classifier configuration: ", err)
+	}
+	log.Printf("🤖 Classifier: %s", classifier.Name())
+
+	if err := loadLocalMinConfidence(); err != nil {
+		log.Fatal("Invalid classifier configuration: ", err)
+	}
+
+	if limit := os.Getenv("MAX_UPLOAD_MB"); limit != "" {
+		mb, err := strconv.Atoi(limit)
+		if err != nil || mb <= 0 {
+			log.Fatalf("MAX_UPLOAD_MB must be a positive number of megabytes, got %q", limit)
+		}
+		maxUploadBytes = int64(mb) << 20
 	}
 
 	// Initialize database
-	var err error
 	db, err = sql.Open("sqlite3", "./schedccalc.db")
 	if err != nil {
 		log.Fatal("Failed to open database:", err)
@@ -143,6 +219,9 @@ func main() {
 		log.Fatal("Failed to create uploads directory:", err)
 	}
 
+	// Remove stored files left by failed uploads or previews from a previous run
+	removeOrphanUploads()
+
 	// Initialize router
 	r := chi.NewRouter()
 
@@ -163,13 +242,44 @@ func main() {
 	// Routes
 	r.Get("/health", healthCheck)
 	r.Post("/upload-csv", uploadCSV)
+	r.Post("/upload-csv/commit", commitUpload)
 	r.Get("/transactions", getTransactions)
 	r.Post("/categorize", categorizeTransactions)
+	r.Post("/jobs/categorize", createCategorizeJob)
+	r.Get("/jobs", getJobs)
+	r.Get("/jobs/{id}", getJob)
+	r.Delete("/jobs/{id}", deleteJob)
+	r.Get("/events", streamEvents)
 	r.Post("/classify", classifyTransaction)
 	r.Post("/toggle-business", toggleBusinessStatus)
 	r.Post("/toggle-all-business", toggleAllBusinessStatus)
 	r.Post("/vendor-rule", createVendorRule)
 	r.Get("/vendor-rules", getVendorRules)
+	r.Post("/vendor-alias", createVendorAlias)
+	r.Get("/vendor-aliases", getVendorAliases)
+	r.Delete("/vendor-alias/{id}", deleteVendorAlias)
+	r.Post("/normalize-vendors", normalizeVendors)
+	r.Get("/files", getFiles)
+	r.Get("/files/{id}", getFile)
+	r.Delete("/files/{id}", deleteFile)
+	r.Get("/duplicates", getDuplicates)
+	r.Post("/resolve-duplicate", resolveDuplicate)
+	r.Post("/exclusion-rule", createExclusionRule)
+	r.Get("/exclusion-rules", getExclusionRules)
+	r.Delete("/exclusion-rule/{id}", deleteExclusionRule)
+	r.Post("/reinstate-transaction", reinstateTransaction)
+	r.Get("/payouts", getPayouts)
+	r.Post("/form-1099", createForm1099)
+	r.Get("/forms-1099", getForms1099)
+	r.Delete("/form-1099/{id}", deleteForm1099)
+	r.Get("/1099-reconciliation", get1099Reconciliation)
+	r.Post("/mapping-profile", createMappingProfile)
+	r.Get("/mapping-profiles", getMappingProfiles)
+	r.Delete("/mapping-profile/{id}", deleteMappingProfile)
+	r.Post("/pdf-template", createPDFTemplate)
+	r.Get("/pdf-templates", getPDFTemplates)
+	r.Delete("/pdf-template/{id}", deletePDFTemplate)
+	r.Delete("/classification-cache", clearClassificationCache)
 	r.Post("/apply-rules", applyVendorRules)
 	r.Post("/vehicle", updateVehicleDeduction)
 	r.Post("/home-office", updateHomeOfficeDeduction)
@@ -208,7 +318,7 @@ func createTables() error {
 			id TEXT PRIMARY KEY,
 			date DATETIME,
 			vendor TEXT,
-			amount REAL,
+			amount_cents INTEGER NOT NULL DEFAULT 0,
 			card TEXT,
 			category TEXT,
 			purpose TEXT,
@@ -261,7 +371,37 @@ func createTables() error {
 			description TEXT NOT NULL
 		);`
 
-	tables := []string{transactionsTable, csvFilesTable, vendorRulesTable, deductionDataTable, scheduleCCategoriesTable}
+	// Create mapping_profiles table for user-defined CSV column mappings
+	mappingProfilesTable := `
+		CREATE TABLE IF NOT EXISTS mapping_profiles (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT UNIQUE NOT NULL,
+			date_column TEXT NOT NULL,
+			date_format TEXT DEFAULT '',
+			date_order TEXT DEFAULT '',
+			description_column TEXT NOT NULL,
+			amount_column TEXT DEFAULT '',
+			debit_column TEXT DEFAULT '',
+			credit_column TEXT DEFAULT '',
+			sign_convention TEXT DEFAULT 'expenses_positive',
+			decimal_separator TEXT DEFAULT '',
+			header_row INTEGER DEFAULT 0,
+			card_name TEXT DEFAULT '',
+			header_signature TEXT DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`
+
+	// Create vendor_aliases table mapping raw descriptors to canonical vendor names
+	vendorAliasesTable := `
+		CREATE TABLE IF NOT EXISTS vendor_aliases (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			pattern TEXT UNIQUE NOT NULL,
+			vendor TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`
+
+	tables := []string{transactionsTable, csvFilesTable, vendorRulesTable, deductionDataTable, scheduleCCategoriesTable, mappingProfilesTable, vendorAliasesTable}
 
 	for _, table := range tables {
 		_, err := db.Exec(table)
@@ -270,6 +410,30 @@ func createTables() error {
 		}
 	}
 
+	if err := createExclusionRulesTable(); err != nil {
+		return err
+	}
+
+	if err := createPayoutsTable(); err != nil {
+		return err
+	}
+
+	if err := createForms1099Table(); err != nil {
+		return err
+	}
+
+	if err := createPDFTemplatesTable(); err != nil {
+		return err
+	}
+
+	if err := createClassificationCacheTable(); err != nil {
+		return err
+	}
+
+	if err := createRemovedDuplicatesTable(); err != nil {
+		return err
+	}
+
 	// Add schedule_c_line column if it doesn't exist (for existing databases)
 	_, err := db.Exec("ALTER TABLE transactions ADD COLUMN schedule_c_line INTEGER DEFAULT 0")
 	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
@@ -293,6 +457,139 @@ func createTables() error {
 		log.Printf("Warning: Could not add sort_business column: %v", err)
 	}
 
+	// Add external_id column for bank-assigned transaction IDs (OFX FITID)
+	_, err = db.Exec("ALTER TABLE transactions ADD COLUMN external_id TEXT DEFAULT ''")
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add external_id column: %v", err)
+	}
+
+	// The same FITID on the same account is the same transaction, so re-importing
+	// an overlapping OFX statement doesn't create duplicates
+	_, err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_card_external_id ON transactions(card, external_id) WHERE external_id <> ''")
+	if err != nil {
+		log.Printf("Warning: Could not create external_id index: %v", err)
+	}
+
+	// Amounts are stored as integer cents. Databases created before that
+	// kept dollars in a REAL amount column; rows not converted yet are filled
+	// on every start, so an interrupted conversion is retried. Databases
+	// created with amount_cents have no amount column to convert.
+	_, err = db.Exec("ALTER TABLE transactions ADD COLUMN amount_cents INTEGER")
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add amount_cents column: %v", err)
+	}
+
+	result, err := db.Exec(`
+		UPDATE transactions SET amount_cents = CAST(ROUND(amount * 100) AS INTEGER)
+		WHERE amount_cents IS NULL OR (amount_cents = 0 AND amount <> 0)
+	`)
+	if err == nil {
+		if converted, _ := result.RowsAffected(); converted > 0 {
+			log.Printf("✅ Converted %d transaction amounts to integer cents", converted)
+		}
+	} else if !strings.Contains(err.Error(), "no such column: amount") {
+		log.Printf("Warning: Could not convert amounts to cents: %v", err)
+	}
+
+	// Near-duplicate checks look up existing rows by amount and date
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_transactions_amount_date ON transactions(amount_cents, date)")
+	if err != nil {
+		log.Printf("Warning: Could not create amount/date index: %v", err)
+	}
+
+	// Add raw description and duplicate detection columns
+	_, err = db.Exec("ALTER TABLE transactions ADD COLUMN description TEXT DEFAULT ''")
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add description column: %v", err)
+	}
+
+	_, err = db.Exec("ALTER TABLE transactions ADD COLUMN fingerprint TEXT DEFAULT ''")
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add fingerprint column: %v", err)
+	}
+
+	_, err = db.Exec("ALTER TABLE transactions ADD COLUMN duplicate_of TEXT DEFAULT ''")
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add duplicate_of column: %v", err)
+	}
+
+	// Add refund link column. Existing credits on expense uploads become refunds
+	// once, when the column is added, so later reclassifications are kept
+	_, err = db.Exec("ALTER TABLE transactions ADD COLUMN refund_of TEXT DEFAULT ''")
+	if err == nil {
+		_, err = db.Exec("UPDATE transactions SET type = 'refund', expensable = false WHERE type = 'expense' AND amount_cents < 0")
+		if err != nil {
+			log.Printf("Warning: Could not mark existing refunds: %v", err)
+		}
+	} else if !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add refund_of column: %v", err)
+	}
+
+	// Add processor fee link; fees split from Stripe, Square and PayPal sales
+	_, err = db.Exec("ALTER TABLE transactions ADD COLUMN fee_of TEXT DEFAULT ''")
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add fee_of column: %v", err)
+	}
+
+	// Record who classified each transaction; manual ones train the local classifier
+	_, err = db.Exec("ALTER TABLE transactions ADD COLUMN classified_by TEXT DEFAULT ''")
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add classified_by column: %v", err)
+	}
+
+	_, err = linkRefunds()
+	if err != nil {
+		log.Printf("Warning: Could not link existing refunds: %v", err)
+	}
+
+	// Add merchant details and unmapped source columns (JSON)
+	for _, column := range []string{"merchant_address", "merchant_city", "merchant_zip", "extra"} {
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE transactions ADD COLUMN %s TEXT DEFAULT ''", column))
+		if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			log.Printf("Warning: Could not add %s column: %v", column, err)
+		}
+	}
+
+	_, err = db.Exec("ALTER TABLE mapping_profiles ADD COLUMN decimal_separator TEXT DEFAULT ''")
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add decimal_separator column: %v", err)
+	}
+
+	_, err = db.Exec("ALTER TABLE mapping_profiles ADD COLUMN date_order TEXT DEFAULT ''")
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		log.Printf("Warning: Could not add date_order column: %v", err)
+	}
+
+	// Add upload details to csv_files
+	csvFileColumns := []string{
+		"path TEXT DEFAULT ''",
+		"format TEXT DEFAULT ''",
+		"transactions_parsed INTEGER DEFAULT 0",
+		"payments_excluded INTEGER DEFAULT 0",
+		"rows_rejected INTEGER DEFAULT 0",
+		"duplicates_skipped INTEGER DEFAULT 0",
+		"date_order TEXT DEFAULT ''",
+		"date_order_ambiguous BOOLEAN DEFAULT FALSE",
+		"business_miles INTEGER DEFAULT 0",
+	}
+	for _, column := range csvFileColumns {
+		_, err = db.Exec("ALTER TABLE csv_files ADD COLUMN " + column)
+		if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			log.Printf("Warning: Could not add csv_files column %s: %v", column, err)
+		}
+	}
+
+	// Fingerprint existing rows before the unique index is created
+	err = backfillFingerprints()
+	if err != nil {
+		log.Printf("Warning: Could not fingerprint existing transactions: %v", err)
+	}
+
+	_, err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_fingerprint ON transactions(fingerprint) WHERE fingerprint <> ''")
+	if err != nil {
+		log.Printf("Warning: Could not create fingerprint index: %v", err)
+	}
+
 	// Populate sortable columns for existing transactions
 	err = populateSortableColumns()
 	if err != nil {
@@ -448,12 +745,23 @@ func healthCheck(w http.ResponseWriter, r *http.Request) {
 		"database":          "connected",
 		"timestamp":         time.Now(),
 		"transaction_count": transactionCount,
+		"classifier":        classifier.Name(),
 	})
 }
 
 func uploadCSV(w http.ResponseWriter, r *http.Request) {
-	// Limit file size to 10MB
-	r.ParseMultipartForm(10 << 20)
+	// Limit the request to maxUploadBytes; parts over 32MB spill to temp files
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("File is larger than the %d MB upload limit", maxUploadBytes>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Invalid multipart form", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
 
 	// Get file from form
 	file, header, err := r.FormFile("file")
@@ -475,10 +783,56 @@ func uploadCSV(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// dry_run=true parses and reports without writing to the database
+	dryRun := r.FormValue("dry_run") == "true"
+
+	// Optional saved column mapping for exports the built-in formats don't recognize
+	var profile *MappingProfile
+	if profileIDStr := r.FormValue("profile_id"); profileIDStr != "" {
+		profileID, err := strconv.Atoi(profileIDStr)
+		if err != nil {
+			http.Error(w, "Invalid profile_id", http.StatusBadRequest)
+			return
+		}
+		profile, err = getMappingProfile(profileID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Mapping profile not found", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Printf("Error loading mapping profile: %v", err)
+			http.Error(w, "Failed to load mapping profile", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Optional day/month order for numeric dates; inferred from the file when empty
+	dateOrder := r.FormValue("date_order")
+	if !validDateOrder(dateOrder) {
+		http.Error(w, "Invalid date_order. Must be: month_first or day_first", http.StatusBadRequest)
+		return
+	}
+
+	// Optional header row offset (rows above the header), overriding the profile's
+	headerRow := -1
+	if headerRowStr := r.FormValue("header_row"); headerRowStr != "" {
+		headerRow, err = strconv.Atoi(headerRowStr)
+		if err != nil || headerRow < 0 {
+			http.Error(w, "Invalid header_row", http.StatusBadRequest)
+			return
+		}
+	}
+
+	options := importOptions{
+		DateOrder:   dateOrder,
+		HeaderRow:   headerRow,
+		Sheet:       r.FormValue("sheet"),        // XLSX only
+		PDFTemplate: r.FormValue("pdf_template"), // PDF only; detected from the statement's text when empty
+	}
+
 	// Validate file extension
 	filename := header.Filename
-	if !strings.HasSuffix(strings.ToLower(filename), ".csv") {
-		http.Error(w, "Only CSV files are allowed", http.StatusBadRequest)
+	if !isDelimitedFilename(filename) && !isXLSXFilename(filename) && !isOFXFilename(filename) && !isPDFFilename(filename) {
+		http.Error(w, "Only CSV, TSV, TXT, XLSX, OFX, QFX or PDF files are allowed", http.StatusBadRequest)
 		return
 	}
 
@@ -490,153 +844,395 @@ func uploadCSV(w http.ResponseWriter, r *http.Request) {
 	safeFilename := fmt.Sprintf("%s_%s%s", fileID, strings.ReplaceAll(filename[:len(filename)-len(ext)], " ", "_"), ext)
 	tempPath := filepath.Join("uploads", safeFilename)
 
+	// The stored copy is removed again unless the upload is saved or held for
+	// a preview commit, so failed uploads leave nothing behind in uploads/
+	keepFile := false
+	defer func() {
+		if !keepFile {
+			removeUpload(tempPath)
+		}
+	}()
+
 	// Create the destination file
 	dst, err := os.Create(tempPath)
 	if err != nil {
 		log.Printf("Error creating file: %v", err)
-		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		writeUploadError(w, http.StatusInternalServerError, "Failed to save file", nil)
 		return
 	}
-	defer dst.Close()
 
 	// Copy file content
 	_, err = io.Copy(dst, file)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		log.Printf("Error copying file: %v", err)
-		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		writeUploadError(w, http.StatusInternalServerError, "Failed to save file", nil)
 		return
 	}
 
-	// Parse CSV and extract transactions
-	parsedData, err := parseCSVFile(tempPath, fileID, source, filename)
-	if err != nil {
-		log.Printf("Error parsing CSV: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to parse CSV: %v", err), http.StatusInternalServerError)
-		return
+	// Parse the statement and extract transactions
+	var parsedData *ParsedCSVData
+	switch {
+	case isOFXFilename(filename):
+		parsedData, err = parseOFXFile(tempPath, fileID, source, filename)
+	case isXLSXFilename(filename):
+		parsedData, err = parseXLSXFile(tempPath, fileID, source, filename, profile, options)
+	case isPDFFilename(filename):
+		parsedData, err = parsePDFFile(tempPath, fileID, source, filename, options)
+	default:
+		parsedData, err = parseCSVFile(tempPath, fileID, source, filename, profile, options)
 	}
-
-	// Save transactions to database
-	err = saveTransactions(parsedData.Transactions)
 	if err != nil {
-		log.Printf("Error saving transactions: %v", err)
-		http.Error(w, "Failed to save transactions", http.StatusInternalServerError)
+		log.Printf("Error parsing file: %v", err)
+		writeUploadError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Failed to parse file: %v", err), nil)
 		return
 	}
 
-	// Save file record to database
-	err = saveCSVFileRecord(fileID, filename, source, tempPath)
-	if err != nil {
-		log.Printf("Error saving file record: %v", err)
-		// File was saved but DB record failed - this is recoverable
+	pending := &pendingUpload{
+		FileID:    fileID,
+		Filename:  filename,
+		TempPath:  tempPath,
+		Source:    source,
+		Profile:   profile,
+		Data:      parsedData,
+		CreatedAt: time.Now(),
 	}
 
-	// Log successful upload
-	log.Printf("📤 CSV processed: %s (ID: %s, Source: %s, Transactions: %d, Payments excluded: %d)",
-		filename, fileID, source, parsedData.ParsedCount, parsedData.PaymentsExcluded)
-
-	// Trigger auto-categorization for newly uploaded transactions
-	go func() {
-		log.Printf("🤖 Starting auto-categorization for uploaded transactions...")
-		err := categorizeUncategorizedTransactions()
+	var response *UploadResponse
+	if dryRun {
+		exact, near, err := checkDuplicates(parsedData.Transactions)
 		if err != nil {
-			log.Printf("❌ Auto-categorization failed: %v", err)
-		} else {
-			log.Printf("✅ Auto-categorization completed")
+			log.Printf("Error checking duplicates: %v", err)
+			writeUploadError(w, http.StatusInternalServerError, "Failed to check for duplicates", nil)
+			return
+		}
+
+		// Show only the rows a commit would actually add
+		var newTransactions []Transaction
+		for _, tx := range parsedData.Transactions {
+			if !exact[tx.ID] {
+				newTransactions = append(newTransactions, tx)
+			}
 		}
-	}()
 
-	// Return success response
-	response := UploadResponse{
-		Success:            true,
-		Message:            "File uploaded and processed successfully",
-		FileID:             fileID,
-		Filename:           filename,
-		TempPath:           tempPath,
-		Source:             source,
-		TransactionsParsed: parsedData.ParsedCount,
-		PaymentsExcluded:   parsedData.PaymentsExcluded,
+		response = newUploadResponse(pending)
+		response.Message = "File parsed; nothing was saved. Commit the preview to import it."
+		response.DryRun = true
+		response.PreviewToken = storePendingUpload(pending)
+		keepFile = true
+		expiresAt := pending.CreatedAt.Add(previewTTL)
+		response.ExpiresAt = &expiresAt
+		response.Transactions = newTransactions
+		response.ExcludedPayments = parsedData.ExcludedPayments
+		response.DuplicatesSkipped = len(exact)
+		response.NearDuplicates = near
+		response.BusinessMiles, response.BusinessMilesSkipped = newBusinessMiles(parsedData, exact, near)
+
+		log.Printf("🔎 Upload preview: %s (Transactions: %d, Payments excluded: %d, Rows rejected: %d)",
+			filename, parsedData.ParsedCount, parsedData.PaymentsExcluded, len(parsedData.RejectedRows))
+	} else {
+		response, err = persistUpload(pending)
+		if err != nil {
+			log.Printf("Error saving transactions: %v", err)
+			writeUploadError(w, http.StatusInternalServerError, "Failed to save transactions", err)
+			return
+		}
+		keepFile = true
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-func parseCSVFile(filePath, fileID, source, originalFilename string) (*ParsedCSVData, error) {
+// recordReader yields a statement's rows one at a time, so large exports are
+// never held in memory as a whole. next returns io.EOF after the last row.
+type recordReader interface {
+	next() (record []string, line int, err error)
+	Close() error
+}
+
+// importOptions are per-upload choices that override what the mapping
+// profile or the file itself would decide
+type importOptions struct {
+	DateOrder   string // "month_first" or "day_first"; empty = the profile's or inferred
+	HeaderRow   int    // Rows above the header row; -1 = the profile's, or 0
+	Sheet       string // XLSX worksheet name or 1-based number; empty = the first sheet
+	PDFTemplate string // PDF template name; empty = chosen by the statement's text
+}
+
+// isDelimitedFilename accepts CSV files and tab-separated reports, such as
+// Amazon settlement flat files (.txt)
+func isDelimitedFilename(filename string) bool {
+	lower := strings.ToLower(filename)
+	return strings.HasSuffix(lower, ".csv") || strings.HasSuffix(lower, ".tsv") || strings.HasSuffix(lower, ".txt")
+}
+
+// csvRecords reads a CSV file one record at a time
+type csvRecords struct {
+	file   *os.File
+	reader *csv.Reader
+}
+
+func openCSVRecords(filePath string) (*csvRecords, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %v", err)
 	}
-	defer file.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	// A first line with more tabs than commas is tab-separated
+	buffered := bufio.NewReader(file)
+	firstLine, _ := buffered.Peek(4096)
+	if end := bytes.IndexByte(firstLine, '\n'); end != -1 {
+		firstLine = firstLine[:end]
+	}
+
+	reader := csv.NewReader(buffered)
+	reader.FieldsPerRecord = -1 // Short rows are reported per row
+	if bytes.Count(firstLine, []byte("\t")) > bytes.Count(firstLine, []byte(",")) {
+		reader.Comma = '\t'
+		reader.LazyQuotes = true // Tab-separated reports don't quote their fields
+	}
+	return &csvRecords{file: file, reader: reader}, nil
+}
+
+// next returns the next record and its line in the file (quoted fields such
+// as Amex "Extended Details" span several lines). It returns io.EOF at the end.
+func (c *csvRecords) next() ([]string, int, error) {
+	record, err := c.reader.Read()
+	if err == io.EOF {
+		return nil, 0, err
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to read CSV: %v", err)
+		return nil, 0, fmt.Errorf("failed to read CSV: %v", err)
 	}
+	line, _ := c.reader.FieldPos(0)
+	return record, line, nil
+}
+
+func (c *csvRecords) Close() error {
+	return c.file.Close()
+}
 
-	if len(records) == 0 {
-		return nil, fmt.Errorf("CSV file is empty")
+// parseCSVFile reads a CSV export. When profile is non-nil its column mapping
+// is used instead of detecting the format from the headers.
+func parseCSVFile(filePath, fileID, source, originalFilename string, profile *MappingProfile, options importOptions) (*ParsedCSVData, error) {
+	open := func() (recordReader, error) {
+		return openCSVRecords(filePath)
+	}
+	return parseRecords(open, fileID, source, originalFilename, profile, options)
+}
+
+// parseRecords detects the format from the header row and parses every row
+// after it. open is called again when the date order has to be inferred
+// from a first pass over the rows.
+//
+// Records are streamed: only the parsed transactions are kept in memory.
+func parseRecords(open func() (recordReader, error), fileID, source, originalFilename string, profile *MappingProfile, options importOptions) (*ParsedCSVData, error) {
+	records, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer records.Close()
+
+	headerRow := options.HeaderRow
+	if headerRow < 0 {
+		headerRow = 0
+		if profile != nil {
+			headerRow = profile.HeaderRow
+		}
+	}
+
+	// Read up to the header row. The file's first record is its signature.
+	var firstRecord, headers []string
+	var headerLine int
+	for i := 0; i <= headerRow; i++ {
+		record, line, err := records.next()
+		if err == io.EOF {
+			if i == 0 {
+				return nil, fmt.Errorf("file is empty")
+			}
+			return nil, fmt.Errorf("header row %d is past the end of the file", headerRow+1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			firstRecord = record
+		}
+		headers, headerLine = record, line
 	}
 
 	// Detect CSV format based on headers
-	headers := records[0]
-	format := detectCSVFormat(headers)
+	var format FormatParser
+	if profile != nil {
+		format = profileFormat{profile: *profile}
+		if format.Detect(headers) == 0 {
+			return nil, fmt.Errorf("file headers don't contain the columns in mapping profile %q", profile.Name)
+		}
+	} else {
+		format = detectCSVFormat(headers)
+	}
+	if f, ok := format.(fileFormat); ok {
+		format = f.newFile()
+	}
+
+	// Skip the header row unless the format has none
+	firstRow := headerRow + 1
+	if isHeaderless(format) {
+		firstRow = 0
+	}
+
+	// Numeric dates are read in one day/month order for the whole file
+	dateOrder := options.DateOrder
+	dateAmbiguous := false
+	switch {
+	case dateOrder != "":
+		// Set explicitly on this upload
+	case profile != nil && profile.DateFormat != "":
+		// The profile's layout already fixes the order
+	case profile != nil && profile.DateOrder != "":
+		dateOrder = profile.DateOrder
+	default:
+		dateOrder, dateAmbiguous, err = scanDateOrder(open, firstRow)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if dateAmbiguous {
+		log.Printf("⚠️ %s: no date decides day/month order; assuming month-first", originalFilename)
+	}
+
+	exclusionRules, err := loadExclusionRules()
+	if err != nil {
+		return nil, err
+	}
+	vendorAliases, err := loadVendorAliases()
+	if err != nil {
+		return nil, err
+	}
 
 	var transactions []Transaction
-	paymentsExcluded := 0
+	var excludedPayments, rejectedRows []RowIssue
+	var payouts payoutSet
+
+	parseRecord := func(record []string, line int) {
+		if len(record) < len(headers) && !isRagged(format) {
+			log.Printf("Skipping malformed row %d", line)
+			rejectedRows = append(rejectedRows, RowIssue{
+				Line:   line,
+				Reason: fmt.Sprintf("expected %d columns, got %d", len(headers), len(record)),
+				Values: record,
+			})
+			return
+		}
 
-	// Parse transactions based on detected format
-	for i, record := range records[1:] { // Skip header row
-		if len(record) < len(headers) {
-			log.Printf("Skipping malformed row %d", i+2)
-			continue
+		transaction, isPayment, err := parseTransactionRecord(record, headers, format, fileID, source, originalFilename, dateOrder)
+		if errors.Is(err, errSkipRecord) {
+			return
+		}
+		if err != nil {
+			log.Printf("Error parsing row %d: %v", line, err)
+			rejectedRows = append(rejectedRows, RowIssue{Line: line, Reason: err.Error(), Values: record})
+			return
 		}
+		transaction.line = line
 
-		transaction, isPayment, err := parseTransactionRecord(record, headers, format, fileID, source, originalFilename)
+		// Processor fees become their own expense; payouts are matched to deposits later
+		fee, payout, err := splitProcessorRecord(format, record, headers, transaction)
 		if err != nil {
-			log.Printf("Error parsing row %d: %v", i+2, err)
-			continue
+			log.Printf("Error parsing row %d: %v", line, err)
+			rejectedRows = append(rejectedRows, RowIssue{Line: line, Reason: err.Error(), Values: record})
+			return
 		}
 
-		if isPayment {
-			paymentsExcluded++
-			continue // Skip payments as requested
+		applyVendorAliases(vendorAliases, transaction)
+
+		// Payments and transfers are kept as type "transfer" for review
+		if reason := excludeTransfer(exclusionRules, transaction, isPayment); reason != "" {
+			excludedPayments = append(excludedPayments, RowIssue{Line: line, Reason: reason, Values: record})
+		}
+
+		transactions = append(transactions, *transaction)
+		if fee != nil {
+			transactions = append(transactions, *fee)
+		}
+		if payout != nil {
+			payouts.add(*payout)
 		}
+	}
 
-		if transaction != nil {
-			transactions = append(transactions, *transaction)
+	// Parse transactions based on detected format
+	if firstRow == 0 {
+		parseRecord(headers, headerLine)
+	}
+	for {
+		record, line, err := records.next()
+		if err == io.EOF {
+			break
 		}
+		if err != nil {
+			return nil, err
+		}
+		parseRecord(record, line)
+	}
+
+	assignFingerprints(transactions)
+
+	var businessMiles float64
+	if m, ok := format.(mileageFormat); ok {
+		businessMiles = m.BusinessMiles()
 	}
 
 	return &ParsedCSVData{
-		Transactions:     transactions,
-		PaymentsExcluded: paymentsExcluded,
-		ParsedCount:      len(transactions),
+		Transactions:       transactions,
+		PaymentsExcluded:   len(excludedPayments),
+		ParsedCount:        len(transactions) - len(excludedPayments),
+		Format:             format.Name(),
+		HeaderSignature:    headerSignature(firstRecord),
+		DateOrder:          dateOrder,
+		DateOrderAmbiguous: dateAmbiguous,
+		Payouts:            payouts.payouts,
+		BusinessMiles:      businessMiles,
+		ExcludedPayments:   excludedPayments,
+		RejectedRows:       rejectedRows,
 	}, nil
 }
 
-func detectCSVFormat(headers []string) string {
-	headerStr := strings.ToLower(strings.Join(headers, ","))
-
-	// Check for Chase format: Status,Date,Description,Debit,Credit
-	if strings.Contains(headerStr, "status") && strings.Contains(headerStr, "debit") && strings.Contains(headerStr, "credit") {
-		return "chase"
+// scanDateOrder infers the date order from a first pass over the file's
+// data rows, which start at record firstRow
+func scanDateOrder(open func() (recordReader, error), firstRow int) (string, bool, error) {
+	records, err := open()
+	if err != nil {
+		return "", false, err
 	}
+	defer records.Close()
 
-	// Check for Amex format: Date,Description,Amount,Extended Details,...
-	if strings.Contains(headerStr, "amount") && strings.Contains(headerStr, "extended details") {
-		return "amex"
+	var scan dateOrderScan
+	for i := 0; ; i++ {
+		record, _, err := records.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", false, err
+		}
+		if i >= firstRow {
+			scan.add(record)
+		}
 	}
 
-	// Default/generic format
-	return "generic"
+	order, ambiguous := scan.result()
+	return order, ambiguous, nil
 }
 
-func parseTransactionRecord(record []string, headers []string, format, fileID, source, cardName string) (*Transaction, bool, error) {
+func parseTransactionRecord(record []string, headers []string, format FormatParser, fileID, source, cardName, dateOrder string) (*Transaction, bool, error) {
 	var transaction Transaction
 	transaction.ID = uuid.New().String()
 	transaction.SourceFile = fileID
 	transaction.Card = extractCardName(cardName)
+	transaction.dateOrder = dateOrder
 
 	// Set transaction type based on source
 	switch source {
@@ -648,14 +1244,14 @@ func parseTransactionRecord(record []string, headers []string, format, fileID, s
 		transaction.Type = "uncategorized"
 	}
 
-	switch format {
-	case "chase":
-		return parseChaseRecord(record, headers, transaction)
-	case "amex":
-		return parseAmexRecord(record, headers, transaction)
-	default:
-		return parseGenericRecord(record, headers, transaction)
+	parsed, isPayment, err := format.Parse(record, headers, transaction)
+	if parsed != nil {
+		markRefund(parsed)
+		if mapper, ok := format.(columnMapper); ok {
+			parsed.Extra = extraColumns(record, headers, mapper.MappedColumns())
+		}
 	}
+	return parsed, isPayment, err
 }
 
 func parseChaseRecord(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
@@ -666,7 +1262,7 @@ func parseChaseRecord(record []string, headers []string, transaction Transaction
 
 	// Extract date
 	if dateIdx, ok := headerMap["date"]; ok && dateIdx < len(record) {
-		date, err := parseDate(record[dateIdx])
+		date, err := parseDateInOrder(record[dateIdx], transaction.dateOrder)
 		if err != nil {
 			return nil, false, fmt.Errorf("invalid date: %v", err)
 		}
@@ -676,30 +1272,28 @@ func parseChaseRecord(record []string, headers []string, transaction Transaction
 	// Extract description/vendor
 	if descIdx, ok := headerMap["description"]; ok && descIdx < len(record) {
 		description := strings.TrimSpace(record[descIdx])
+		transaction.Description = description
 		transaction.Vendor = extractVendorName(description)
-
-		// Check if this is a payment
-		if isPaymentTransaction(description) {
-			return nil, true, nil // This is a payment, exclude it
-		}
 	}
 
 	// Extract amount (Chase has separate Debit/Credit columns)
-	var amount float64
+	var amount Cents
 	if debitIdx, ok := headerMap["debit"]; ok && debitIdx < len(record) {
-		if record[debitIdx] != "" {
-			debitAmount, err := strconv.ParseFloat(record[debitIdx], 64)
-			if err == nil {
-				amount = debitAmount // Positive for expenses
+		if strings.TrimSpace(record[debitIdx]) != "" {
+			debitAmount, err := parseMoney(record[debitIdx], "")
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid debit: %v", err)
 			}
+			amount = debitAmount // Positive for expenses
 		}
 	}
 	if creditIdx, ok := headerMap["credit"]; ok && creditIdx < len(record) {
-		if record[creditIdx] != "" {
-			creditAmount, err := strconv.ParseFloat(record[creditIdx], 64)
-			if err == nil {
-				amount = -creditAmount // Negative for income/refunds
+		if strings.TrimSpace(record[creditIdx]) != "" {
+			creditAmount, err := parseMoney(record[creditIdx], "")
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid credit: %v", err)
 			}
+			amount = -creditAmount.Abs() // Negative for income/refunds; exports sign credits either way
 		}
 	}
 
@@ -719,7 +1313,7 @@ func parseAmexRecord(record []string, headers []string, transaction Transaction)
 
 	// Extract date
 	if dateIdx, ok := headerMap["date"]; ok && dateIdx < len(record) {
-		date, err := parseDate(record[dateIdx])
+		date, err := parseDateInOrder(record[dateIdx], transaction.dateOrder)
 		if err != nil {
 			return nil, false, fmt.Errorf("invalid date: %v", err)
 		}
@@ -729,23 +1323,33 @@ func parseAmexRecord(record []string, headers []string, transaction Transaction)
 	// Extract description/vendor
 	if descIdx, ok := headerMap["description"]; ok && descIdx < len(record) {
 		description := strings.TrimSpace(record[descIdx])
+		transaction.Description = description
 		transaction.Vendor = extractVendorName(description)
-
-		// Check if this is a payment
-		if isPaymentTransaction(description) {
-			return nil, true, nil // This is a payment, exclude it
-		}
 	}
 
 	// Extract amount (Amex has single Amount column with positive/negative values)
 	if amountIdx, ok := headerMap["amount"]; ok && amountIdx < len(record) {
-		amount, err := strconv.ParseFloat(record[amountIdx], 64)
+		amount, err := parseMoney(record[amountIdx], "")
 		if err != nil {
 			return nil, false, fmt.Errorf("invalid amount: %v", err)
 		}
 		transaction.Amount = amount
 	}
 
+	// Statement reference and merchant location
+	if refIdx, ok := headerMap["reference"]; ok && refIdx < len(record) {
+		transaction.ExternalID = strings.Trim(strings.TrimSpace(record[refIdx]), "'")
+	}
+	if addressIdx, ok := headerMap["address"]; ok && addressIdx < len(record) {
+		transaction.MerchantAddress = joinLines(record[addressIdx])
+	}
+	if cityIdx, ok := headerMap["city/state"]; ok && cityIdx < len(record) {
+		transaction.MerchantCity = joinLines(record[cityIdx])
+	}
+	if zipIdx, ok := headerMap["zip code"]; ok && zipIdx < len(record) {
+		transaction.MerchantZip = strings.TrimSpace(record[zipIdx])
+	}
+
 	// Use Amex category if available
 	if catIdx, ok := headerMap["category"]; ok && catIdx < len(record) {
 		category := strings.TrimSpace(record[catIdx])
@@ -778,22 +1382,22 @@ func parseGenericRecord(record []string, headers []string, transaction Transacti
 
 		// Try to parse date
 		if strings.Contains(headerLower, "date") && transaction.Date.IsZero() {
-			if date, err := parseDate(value); err == nil {
+			if date, err := parseDateInOrder(value, transaction.dateOrder); err == nil {
 				transaction.Date = date
 			}
 		}
 
 		// Try to parse description/vendor
-		if (strings.Contains(headerLower, "description") || strings.Contains(headerLower, "vendor")) && transaction.Vendor == "" {
+		if (strings.Contains(headerLower, "description") || strings.Contains(headerLower, "vendor") ||
+			strings.Contains(headerLower, "payee") || strings.Contains(headerLower, "merchant") ||
+			strings.Contains(headerLower, "memo")) && transaction.Vendor == "" && value != "" {
+			transaction.Description = value
 			transaction.Vendor = extractVendorName(value)
-			if isPaymentTransaction(value) {
-				return nil, true, nil // This is a payment, exclude it
-			}
 		}
 
 		// Try to parse amount
 		if strings.Contains(headerLower, "amount") && transaction.Amount == 0 {
-			if amount, err := strconv.ParseFloat(value, 64); err == nil {
+			if amount, err := parseMoney(value, ""); err == nil {
 				transaction.Amount = amount
 			}
 		}
@@ -810,61 +1414,6 @@ func parseGenericRecord(record []string, headers []string, transaction Transacti
 	return &transaction, false, nil
 }
 
-func isPaymentTransaction(description string) bool {
-	description = strings.ToLower(description)
-	paymentKeywords := []string{
-		"online payment",
-		"payment thank you",
-		"payment - thank you",
-		"autopay",
-		"automatic payment",
-		"paypal transfer",
-		"venmo payment",
-		"zelle payment",
-		"wire transfer",
-		"transfer to",
-		"transfer from",
-	}
-
-	for _, keyword := range paymentKeywords {
-		if strings.Contains(description, keyword) {
-			return true
-		}
-	}
-	return false
-}
-
-func extractVendorName(description string) string {
-	// Clean up vendor name from description
-	vendor := strings.TrimSpace(description)
-
-	// Remove common prefixes
-	prefixes := []string{"AplPay ", "TST* ", "SQC*", "GOOGLE *", "PAYPAL *"}
-	for _, prefix := range prefixes {
-		if strings.HasPrefix(vendor, prefix) {
-			vendor = vendor[len(prefix):]
-			break
-		}
-	}
-
-	// Remove location suffixes (state codes at end)
-	words := strings.Fields(vendor)
-	if len(words) > 1 {
-		lastWord := words[len(words)-1]
-		// Check if last word is a US state code
-		if len(lastWord) == 2 && strings.ToUpper(lastWord) == lastWord {
-			vendor = strings.Join(words[:len(words)-1], " ")
-		}
-	}
-
-	// Limit length
-	if len(vendor) > 50 {
-		vendor = vendor[:50]
-	}
-
-	return strings.TrimSpace(vendor)
-}
-
 func extractCardName(filename string) string {
 	// Extract card name from filename
 	cardName := filename
@@ -879,59 +1428,64 @@ func extractCardName(filename string) string {
 	return cardName
 }
 
-func parseDate(dateStr string) (time.Time, error) {
-	// Try multiple date formats
-	formats := []string{
-		"01/02/2006",
-		"1/2/2006",
-		"2006-01-02",
-		"01-02-2006",
-		"1-2-2006",
-		"January 2, 2006",
-		"Jan 2, 2006",
-		"2/1/2006",
-		"02/01/2006",
-	}
-
-	for _, format := range formats {
-		if date, err := time.Parse(format, dateStr); err == nil {
-			return date, nil
-		}
-	}
-
-	return time.Time{}, fmt.Errorf("unable to parse date: %s", dateStr)
-}
-
-func saveTransactions(transactions []Transaction) error {
+// saveTransactions inserts the parsed rows as part of dbTx and returns how
+// many were stored. Rows whose fingerprint (or external_id for the same card)
+// already exists are skipped. Rows that fail to insert are returned as a
+// *rowsError; the caller rolls back so none of the upload is saved.
+func saveTransactions(dbTx *sql.Tx, transactions []Transaction) (int, error) {
 	if len(transactions) == 0 {
-		return nil
+		return 0, nil
 	}
 
-	// Prepare bulk insert (updated with schedule_c_line)
+	// One prepared insert for every row (updated with schedule_c_line)
 	query := `
-		INSERT INTO transactions (id, date, vendor, amount, card, category, purpose, expensable, type, source_file, schedule_c_line)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT OR IGNORE INTO transactions (id, date, vendor, amount_cents, card, category, purpose, expensable, type, source_file, schedule_c_line,
+		                                    external_id, description, fingerprint, duplicate_of, fee_of,
+		                                    merchant_address, merchant_city, merchant_zip, extra)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	stmt, err := db.Prepare(query)
+	stmt, err := dbTx.Prepare(query)
 	if err != nil {
-		return fmt.Errorf("failed to prepare statement: %v", err)
+		return 0, fmt.Errorf("failed to prepare statement: %v", err)
 	}
 	defer stmt.Close()
 
+	saved := 0
+	var failed []RowIssue
 	for _, tx := range transactions {
-		_, err = stmt.Exec(
+		result, err := stmt.Exec(
 			tx.ID, tx.Date, tx.Vendor, tx.Amount, tx.Card,
 			tx.Category, tx.Purpose, tx.Expensable, tx.Type, tx.SourceFile, tx.ScheduleCLine,
+			tx.ExternalID, tx.Description, tx.Fingerprint, tx.DuplicateOf, tx.FeeOf,
+			tx.MerchantAddress, tx.MerchantCity, tx.MerchantZip, tx.Extra,
 		)
 		if err != nil {
-			log.Printf("Failed to insert transaction %s: %v", tx.ID, err)
+			failed = append(failed, RowIssue{
+				Line:   tx.line,
+				Reason: err.Error(),
+				Values: []string{tx.Date.Format("2006-01-02"), tx.Description, tx.Amount.String()},
+			})
+			// A value the driver couldn't convert never reached SQLite, so
+			// the remaining rows are still checked. An error from SQLite
+			// itself (disk full, database locked) ends the transaction.
+			var sqliteErr sqlite3.Error
+			if errors.As(err, &sqliteErr) {
+				break
+			}
 			continue
 		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+			saved++
+		}
 	}
 
-	log.Printf("💾 Saved %d transactions to database", len(transactions))
-	return nil
+	if len(failed) > 0 {
+		return 0, &rowsError{Rows: failed}
+	}
+
+	log.Printf("💾 Saved %d transactions to database (%d duplicates skipped)", saved, len(transactions)-saved)
+	return saved, nil
 }
 
 func getTransactions(w http.ResponseWriter, r *http.Request) {
@@ -974,8 +1528,10 @@ func getTransactions(w http.ResponseWriter, r *http.Request) {
 	offset := (page - 1) * pageSize
 
 	// Build base query
+	columns := `id, date, vendor, amount_cents, card, category, purpose, expensable, type, source_file, schedule_c_line, is_business, sort_category, sort_business, description, duplicate_of, refund_of, fee_of,
+		       external_id, merchant_address, merchant_city, merchant_zip, extra`
 	baseQuery := `
-		SELECT id, date, vendor, amount, card, category, purpose, expensable, type, source_file, schedule_c_line, is_business, sort_category, sort_business
+		SELECT ` + columns + `
 		FROM transactions
 		WHERE 1=1
 	`
@@ -983,17 +1539,17 @@ func getTransactions(w http.ResponseWriter, r *http.Request) {
 
 	// Add filters
 	if highValue == "true" {
-		thresholdValue := 100.0 // default threshold
+		thresholdValue := Cents(10000) // default threshold ($100)
 		if threshold != "" {
-			if t, err := strconv.ParseFloat(threshold, 64); err == nil {
+			if t, err := parseMoney(threshold, "."); err == nil {
 				thresholdValue = t
 			}
 		}
-		baseQuery += " AND ABS(amount) >= ?"
+		baseQuery += " AND ABS(amount_cents) >= ?"
 		args = append(args, thresholdValue)
 	}
 
-	if txType != "" && (txType == "income" || txType == "expense" || txType == "uncategorized") {
+	if txType != "" && (txType == "income" || txType == "expense" || txType == "refund" || txType == "transfer" || txType == "uncategorized") {
 		baseQuery += " AND type = ?"
 		args = append(args, txType)
 	}
@@ -1043,7 +1599,7 @@ func getTransactions(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// For total count (before LIMIT/OFFSET)
-	countQuery := strings.Replace(baseQuery, "SELECT id, date, vendor, amount, card, category, purpose, expensable, type, source_file, schedule_c_line, is_business, sort_category, sort_business", "SELECT COUNT(*)", 1)
+	countQuery := strings.Replace(baseQuery, "SELECT "+columns, "SELECT COUNT(*)", 1)
 	countArgs := make([]interface{}, len(args))
 	copy(countArgs, args)
 
@@ -1052,9 +1608,9 @@ func getTransactions(w http.ResponseWriter, r *http.Request) {
 	switch sortBy {
 	case "amount":
 		if sortOrder == "asc" {
-			orderClause += "ABS(amount) ASC"
+			orderClause += "ABS(amount_cents) ASC"
 		} else {
-			orderClause += "ABS(amount) DESC" // Default: highest amounts first
+			orderClause += "ABS(amount_cents) DESC" // Default: highest amounts first
 		}
 	case "vendor":
 		if sortOrder == "desc" {
@@ -1120,7 +1676,9 @@ func getTransactions(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var tx Transaction
 		err := rows.Scan(&tx.ID, &tx.Date, &tx.Vendor, &tx.Amount, &tx.Card,
-			&tx.Category, &tx.Purpose, &tx.Expensable, &tx.Type, &tx.SourceFile, &tx.ScheduleCLine, &tx.IsBusiness, &tx.SortCategory, &tx.SortBusiness)
+			&tx.Category, &tx.Purpose, &tx.Expensable, &tx.Type, &tx.SourceFile, &tx.ScheduleCLine, &tx.IsBusiness, &tx.SortCategory, &tx.SortBusiness,
+			&tx.Description, &tx.DuplicateOf, &tx.RefundOf, &tx.FeeOf,
+			&tx.ExternalID, &tx.MerchantAddress, &tx.MerchantCity, &tx.MerchantZip, &tx.Extra)
 		if err != nil {
 			log.Printf("Error scanning transaction: %v", err)
 			continue
@@ -1161,8 +1719,8 @@ func getTransactions(w http.ResponseWriter, r *http.Request) {
 }
 
 func calculateTransactionSummary(transactions []Transaction) map[string]interface{} {
-	var totalIncome, totalExpenses float64
-	var incomeCount, expenseCount int
+	var totalIncome, totalExpenses, totalRefunds Cents
+	var incomeCount, expenseCount, refundCount, transferCount int
 	vendorCounts := make(map[string]int)
 
 	for _, tx := range transactions {
@@ -1176,10 +1734,15 @@ func calculateTransactionSummary(transactions []Transaction) map[string]interfac
 		} else if tx.Type == "expense" && tx.Amount > 0 {
 			totalExpenses += tx.Amount
 			expenseCount++
+		} else if tx.Type == "refund" {
+			// Refunds offset the expenses they came from
+			totalRefunds += tx.Amount.Abs()
+			refundCount++
+		} else if tx.Type == "transfer" {
+			transferCount++
 		}
-		// Note: Negative amounts (refunds/credits) are excluded from both income and expenses
-		// They serve as reminders that the original expense should be offset
 	}
+	totalExpenses -= totalRefunds
 
 	// Find recurring vendors (appear more than once)
 	var recurringVendors []map[string]interface{}
@@ -1195,20 +1758,28 @@ func calculateTransactionSummary(transactions []Transaction) map[string]interfac
 	return map[string]interface{}{
 		"total_income":      totalIncome,
 		"total_expenses":    totalExpenses,
+		"total_refunds":     totalRefunds,
 		"income_count":      incomeCount,
 		"expense_count":     expenseCount,
+		"refund_count":      refundCount,
+		"transfer_count":    transferCount,
 		"recurring_vendors": recurringVendors,
 		"unique_vendors":    len(vendorCounts),
 	}
 }
 
-func saveCSVFileRecord(fileID, filename, source, tempPath string) error {
+func saveCSVFileRecord(dbTx *sql.Tx, pending *pendingUpload, duplicatesSkipped, businessMiles int) error {
 	query := `
-		INSERT INTO csv_files (id, filename, uploaded, source)
-		VALUES (?, ?, ?, ?)
+		INSERT INTO csv_files (id, filename, uploaded, source, path, format,
+		                       transactions_parsed, payments_excluded, rows_rejected, duplicates_skipped,
+		                       date_order, date_order_ambiguous, business_miles)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := db.Exec(query, fileID, filename, time.Now(), source)
+	parsedData := pending.Data
+	_, err := dbTx.Exec(query, pending.FileID, pending.Filename, time.Now(), pending.Source, pending.TempPath, parsedData.Format,
+		parsedData.ParsedCount, parsedData.PaymentsExcluded, len(parsedData.RejectedRows), duplicatesSkipped,
+		parsedData.DateOrder, parsedData.DateOrderAmbiguous, businessMiles)
 	if err != nil {
 		return fmt.Errorf("failed to save CSV file record: %v", err)
 	}
@@ -1216,11 +1787,15 @@ func saveCSVFileRecord(fileID, filename, source, tempPath string) error {
 	return nil
 }
 
-// Helper function for auto-categorization (used by upload and manual trigger)
-func categorizeUncategorizedTransactions() error {
+// Helper function for auto-categorization, run by categorization jobs.
+// The local classifier runs first, then the classification cache; only one
+// transaction per uncached vendor, amount band and card is sent to the LLM.
+// progress is called after each stage and batch with the transactions it
+// classified; cancelling ctx stops the run before its next LLM request.
+func categorizeUncategorizedTransactions(ctx context.Context, progress func(CategorizeStats, []ClassifiedTransaction)) (stats CategorizeStats, err error) {
 	// Get uncategorized BUSINESS transactions or business transactions without proper Schedule C line assignments
 	query := `
-		SELECT id, vendor, amount, category, purpose, type
+		SELECT id, vendor, amount_cents, COALESCE(card, ''), category, purpose, type, COALESCE(description, ''), COALESCE(extra, '')
 		FROM transactions 
 		WHERE is_business = true AND (category = 'uncategorized' OR category = '' OR schedule_c_line = 0)
 		ORDER BY date DESC
@@ -1228,14 +1803,14 @@ func categorizeUncategorizedTransactions() error {
 
 	rows, err := db.Query(query)
 	if err != nil {
-		return fmt.Errorf("failed to fetch transactions: %v", err)
+		return stats, fmt.Errorf("failed to fetch transactions: %v", err)
 	}
 	defer rows.Close()
 
 	var transactions []Transaction
 	for rows.Next() {
 		var tx Transaction
-		err := rows.Scan(&tx.ID, &tx.Vendor, &tx.Amount, &tx.Category, &tx.Purpose, &tx.Type)
+		err := rows.Scan(&tx.ID, &tx.Vendor, &tx.Amount, &tx.Card, &tx.Category, &tx.Purpose, &tx.Type, &tx.Description, &tx.Extra)
 		if err != nil {
 			log.Printf("Error scanning transaction: %v", err)
 			continue
@@ -1245,65 +1820,206 @@ func categorizeUncategorizedTransactions() error {
 
 	if len(transactions) == 0 {
 		log.Printf("No uncategorized transactions found")
-		return nil
+		return stats, nil
+	}
+
+	stats.Total = len(transactions)
+
+	// save stores a classification and collects it for the next progress report
+	var classified []ClassifiedTransaction
+	save := func(tx Transaction, classification *ExpenseClassification, classifiedBy string) error {
+		err := updateTransactionClassification(tx.ID, classification, classifiedBy)
+		if err == nil {
+			classified = append(classified, ClassifiedTransaction{
+				ID:            tx.ID,
+				Vendor:        tx.Vendor,
+				Category:      classification.Category,
+				ScheduleCLine: classification.ScheduleCLine,
+				Expensable:    classification.Expensable,
+				Purpose:       classification.Purpose,
+				ClassifiedBy:  classifiedBy,
+			})
+		}
+		return err
+	}
+	report := func() {
+		progress(stats.progress(), classified)
+		classified = nil
+	}
+
+	// The local classifier runs first; only what it can't place with
+	// confidence is sent to the LLM provider
+	local, err := trainLocalClassifier()
+	if err != nil {
+		log.Printf("⚠️ Local classifier unavailable: %v", err)
+	} else {
+		classifications, _ := local.ClassifyBatch(transactions)
+		var remaining []Transaction
+		for _, tx := range transactions {
+			classification, exists := classifications[tx.ID]
+			if !exists {
+				remaining = append(remaining, tx)
+				continue
+			}
+
+			err = save(tx, classification, local.Name())
+			if err != nil {
+				stats.fail("Failed to update transaction %s: %v", tx.ID, err)
+				continue
+			}
+
+			stats.LocalClassified++
+			log.Printf("📚 Classified locally: %s -> %s (Line %d, confidence %.2f)", tx.Vendor, classification.Category, classification.ScheduleCLine, classification.Confidence)
+		}
+		log.Printf("📚 Local classifier (%d training examples) classified %d/%d transactions", local.Examples(), stats.LocalClassified, stats.Total)
+		transactions = remaining
+	}
+	report()
+
+	// Serve cache hits and group the rest by cache key, so each vendor, amount
+	// band and card is sent to the LLM once
+	stats.CacheLookups = len(transactions)
+	var keys []string
+	for _, tx := range transactions {
+		if key := classificationCacheKey(tx); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	cache, err := loadClassificationCache(keys)
+	if err != nil {
+		log.Printf("⚠️ %v", err)
+		cache = make(map[string]*cachedClassification)
+	}
+
+	hits := make(map[string]int)
+	groups := make(map[string][]Transaction)
+	var pending []Transaction // One representative per group
+	for _, tx := range transactions {
+		key := classificationCacheKey(tx)
+		if entry, cached := cache[key]; cached {
+			err = save(tx, &entry.Classification, "cache")
+			if err != nil {
+				stats.fail("Failed to update transaction %s: %v", tx.ID, err)
+				continue
+			}
+			hits[key]++
+			stats.CacheHits++
+			continue
+		}
+
+		if key == "" {
+			pending = append(pending, tx)
+			continue
+		}
+		if _, grouped := groups[key]; grouped {
+			stats.Deduplicated++
+		} else {
+			pending = append(pending, tx)
+		}
+		groups[key] = append(groups[key], tx)
 	}
+	recordCacheHits(hits)
+	if stats.CacheLookups > 0 {
+		stats.CacheHitRate = float64(stats.CacheHits) / float64(stats.CacheLookups)
+	}
+	if stats.CacheHits > 0 {
+		log.Printf("💾 Classification cache served %d/%d transactions", stats.CacheHits, stats.CacheLookups)
+	}
+	report()
 
 	// Process transactions in batches of 10 for better performance
 	batchSize := 10
-	processed := 0
+	uncached := len(pending) + stats.Deduplicated
+
+	_, disabled := classifier.(noneClassifier)
+	defer func() {
+		stats = stats.progress()
+		// Without the cache every lookup would have gone out in batches
+		if !disabled {
+			stats.LLMCallsSaved = max(0, (stats.CacheLookups+batchSize-1)/batchSize-stats.LLMCalls)
+		}
+	}()
+
+	if disabled {
+		if uncached > 0 {
+			log.Printf("⚠️ %d transactions left for manual classification; no LLM provider configured", uncached)
+		}
+		return stats, nil
+	}
+
+	// apply saves an LLM classification on the representative and every
+	// transaction grouped with it, and caches it
+	apply := func(tx Transaction, classification *ExpenseClassification) {
+		group := groups[classificationCacheKey(tx)]
+		if len(group) == 0 {
+			group = []Transaction{tx}
+		}
+		for _, member := range group {
+			err := save(member, classification, classifier.Name())
+			if err != nil {
+				stats.fail("Failed to update transaction %s: %v", member.ID, err)
+				continue
+			}
+			stats.LLMClassified++
+		}
+		log.Printf("🏷️ Classified: %s -> %s (Line %d, %d transactions)", tx.Vendor, classification.Category, classification.ScheduleCLine, len(group))
+
+		if err := saveClassificationCache(tx, classification, classifier.Name()); err != nil {
+			log.Printf("Warning: Could not cache classification for %s: %v", tx.Vendor, err)
+		}
+	}
+
+	for i := 0; i < len(pending); i += batchSize {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
 
-	for i := 0; i < len(transactions); i += batchSize {
 		end := i + batchSize
-		if end > len(transactions) {
-			end = len(transactions)
+		if end > len(pending) {
+			end = len(pending)
 		}
 
-		batch := transactions[i:end]
-		log.Printf("🔄 Processing batch %d-%d of %d transactions...", i+1, end, len(transactions))
+		batch := pending[i:end]
+		log.Printf("🔄 Processing batch %d-%d of %d transactions...", i+1, end, len(pending))
 
-		// Classify batch with LLM
-		classifications, err := classifyTransactionsBatchWithLLM(batch)
+		// Classify batch with the configured provider
+		stats.LLMCalls++
+		classifications, err := classifier.ClassifyBatch(batch)
 		if err != nil {
 			log.Printf("Failed to classify batch: %v", err)
 			// Fall back to individual processing for this batch
 			for _, tx := range batch {
-				classification, err := classifyTransactionWithLLM(tx)
-				if err != nil {
-					log.Printf("Failed to classify transaction %s: %v", tx.ID, err)
-					continue
+				if err := ctx.Err(); err != nil {
+					return stats, err
 				}
 
-				err = updateTransactionClassification(tx.ID, classification)
+				stats.LLMCalls++
+				classification, err := classifier.Classify(tx)
 				if err != nil {
-					log.Printf("Failed to update transaction %s: %v", tx.ID, err)
+					stats.failGroup(groups[classificationCacheKey(tx)], "Failed to classify transaction %s: %v", tx.ID, err)
 					continue
 				}
-
-				processed++
-				log.Printf("🏷️ Classified: %s -> %s (Line %d)", tx.Vendor, classification.Category, classification.ScheduleCLine)
+				apply(tx, classification)
 			}
+			report()
 			continue
 		}
 
 		// Update transactions with batch classifications
 		for _, tx := range batch {
 			if classification, exists := classifications[tx.ID]; exists {
-				err = updateTransactionClassification(tx.ID, classification)
-				if err != nil {
-					log.Printf("Failed to update transaction %s: %v", tx.ID, err)
-					continue
-				}
-
-				processed++
-				log.Printf("🏷️ Classified: %s -> %s (Line %d)", tx.Vendor, classification.Category, classification.ScheduleCLine)
+				apply(tx, classification)
 			} else {
-				log.Printf("⚠️ No classification found for transaction %s", tx.ID)
+				stats.failGroup(groups[classificationCacheKey(tx)], "No classification found for transaction %s", tx.ID)
 			}
 		}
+		report()
 	}
 
-	log.Printf("✅ Auto-categorization completed: %d/%d transactions processed", processed, len(transactions))
-	return nil
+	log.Printf("✅ Auto-categorization completed: %d/%d transactions processed (%d local, %d cached, %d by %s in %d calls)",
+		stats.LocalClassified+stats.CacheHits+stats.LLMClassified, stats.Total, stats.LocalClassified, stats.CacheHits,
+		stats.LLMClassified, classifier.Name(), stats.LLMCalls)
+	return stats, nil
 }
 
 func categorizeTransactions(w http.ResponseWriter, r *http.Request) {
@@ -1345,10 +2061,18 @@ func categorizeTransactions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Use the helper function to do the actual categorization
-	err = categorizeUncategorizedTransactions()
-	if err != nil {
-		log.Printf("Categorization failed: %v", err)
+	// Run it as a job so it never overlaps an upload's categorization, and
+	// wait for it to finish
+	job := startCategorizeJob("categorize")
+	select {
+	case <-job.done:
+	case <-r.Context().Done():
+		return
+	}
+
+	result := job.snapshot()
+	if result.Status == "failed" {
+		log.Printf("Categorization failed: %v", result.Errors)
 		http.Error(w, "Categorization failed", http.StatusInternalServerError)
 		return
 	}
@@ -1362,22 +2086,33 @@ func categorizeTransactions(w http.ResponseWriter, r *http.Request) {
 
 	processed := totalUncategorized - remainingUncategorized
 
+	message := fmt.Sprintf("Processed %d transactions", processed)
+	if _, disabled := classifier.(noneClassifier); disabled && remainingUncategorized > 0 {
+		message += fmt.Sprintf("; %d need manual classification (no LLM provider configured)", remainingUncategorized)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success":   true,
-		"message":   fmt.Sprintf("Processed %d transactions", processed),
+		"message":   message,
 		"processed": processed,
+		"remaining": remainingUncategorized,
 		"total":     totalUncategorized,
+		"failed":    result.Failed,
+		"errors":    result.Errors,
+		"stats":     result.Stats,
+		"job_id":    result.ID,
 	})
 }
 
 func classifyTransaction(w http.ResponseWriter, r *http.Request) {
 	var request struct {
-		TransactionID string `json:"transaction_id"`
-		Category      string `json:"category,omitempty"`
-		Purpose       string `json:"purpose,omitempty"`
-		Expensable    *bool  `json:"expensable,omitempty"`
-		ScheduleCLine *int   `json:"schedule_c_line,omitempty"`
+		TransactionID string  `json:"transaction_id"`
+		Category      string  `json:"category,omitempty"`
+		Purpose       string  `json:"purpose,omitempty"`
+		Expensable    *bool   `json:"expensable,omitempty"`
+		ScheduleCLine *int    `json:"schedule_c_line,omitempty"`
+		RefundOf      *string `json:"refund_of,omitempty"` // Link a refund to its purchase by hand
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -1390,13 +2125,21 @@ func classifyTransaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Manual classification - update the transaction directly
+	// Manual classification - update the transaction directly. A category or
+	// line set by hand becomes a training example for the local classifier.
+	classifiedBy := ""
+	if request.Category != "" || request.ScheduleCLine != nil {
+		classifiedBy = "manual"
+	}
+
 	updateQuery := `
 		UPDATE transactions 
 		SET category = COALESCE(?, category),
 		    purpose = COALESCE(?, purpose),
 		    expensable = COALESCE(?, expensable),
-		    schedule_c_line = COALESCE(?, schedule_c_line)
+		    schedule_c_line = COALESCE(?, schedule_c_line),
+		    refund_of = COALESCE(?, refund_of),
+		    classified_by = COALESCE(?, classified_by)
 		WHERE id = ?
 	`
 
@@ -1405,6 +2148,8 @@ func classifyTransaction(w http.ResponseWriter, r *http.Request) {
 		nullString(request.Purpose),
 		request.Expensable,
 		request.ScheduleCLine,
+		request.RefundOf,
+		nullString(classifiedBy),
 		request.TransactionID)
 
 	if err != nil {
@@ -1413,6 +2158,24 @@ func classifyTransaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Serve the user's choice for this vendor from the cache from now on
+	if classifiedBy == "manual" {
+		var tx Transaction
+		var classification ExpenseClassification
+		err = db.QueryRow(`
+			SELECT vendor, amount_cents, COALESCE(card, ''), category, schedule_c_line, expensable, COALESCE(purpose, '')
+			FROM transactions WHERE id = ?`, request.TransactionID).Scan(
+			&tx.Vendor, &tx.Amount, &tx.Card, &classification.Category, &classification.ScheduleCLine,
+			&classification.Expensable, &classification.Purpose)
+		if err == nil && classification.ScheduleCLine >= 8 && classification.ScheduleCLine <= 27 {
+			classification.Confidence = 1
+			err = saveClassificationCache(tx, &classification, "manual")
+		}
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Warning: Could not cache manual classification: %v", err)
+		}
+	}
+
 	log.Printf("📝 Manual classification: Transaction %s updated", request.TransactionID)
 
 	w.Header().Set("Content-Type", "application/json")
@@ -1422,289 +2185,14 @@ func classifyTransaction(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// Batch classify multiple transactions for better performance
-func classifyTransactionsBatchWithLLM(transactions []Transaction) (map[string]*ExpenseClassification, error) {
-	if len(transactions) == 0 {
-		return make(map[string]*ExpenseClassification), nil
-	}
-
-	// Build batch prompt
-	var transactionList strings.Builder
-	for i, tx := range transactions {
-		transactionList.WriteString(fmt.Sprintf(`
-Transaction %d:
-- ID: %s
-- Vendor: %s
-- Amount: $%.2f
-- Description: %s`, i+1, tx.ID, tx.Vendor, tx.Amount, tx.Purpose))
-	}
-
-	prompt := fmt.Sprintf(`You are an expert tax accountant specializing in Schedule C business expenses. 
-
-Please categorize these %d business transactions and provide the corresponding IRS Schedule C line numbers:
-%s
-
-For EACH transaction, provide a JSON object with:
-1. transaction_id: The exact ID provided
-2. category: Must be one of the exact categories listed below
-3. schedule_c_line: IRS Schedule C line number (MUST be 8-27, NEVER use 0)
-4. expensable: true/false if this is a legitimate business expense
-5. purpose: Brief business purpose description
-6. confidence: 0.0-1.0 confidence score
-
-REQUIRED CATEGORIES (use exact names):
-- Line 8: "Advertising"
-- Line 9: "Car and truck"
-- Line 10: "Commissions and fees"
-- Line 11: "Contractors"
-- Line 15: "Insurance"
-- Line 16: "Interest paid"
-- Line 17: "Legal fees and professional services"
-- Line 18: "Office expenses"
-- Line 20: "Rent and lease"
-- Line 21: "Repairs and maintenance"
-- Line 22: "Supplies"
-- Line 23: "Taxes and licenses"
-- Line 24: "Meals"
-- Line 25: "Travel expenses"
-- Line 26: "Utilities"
-- Line 27: "Other business expenses"
-
-CRITICAL RULES:
-- NEVER use Line 0 or any number outside 8-27
-- If uncertain about the category, ALWAYS use "Other business expenses" (Line 27)
-- If you think it's not a business expense, still use Line 27 and set expensable: false
-- The schedule_c_line MUST be between 8 and 27 (inclusive)
-
-Return a JSON array with one object per transaction:
-[
-  {
-    "transaction_id": "exact_id_from_input",
-    "category": "category_name",
-    "schedule_c_line": number,
-    "expensable": boolean,
-    "purpose": "description",
-    "confidence": number
-  }
-]`, len(transactions), transactionList.String())
-
-	requestBody := OpenRouterRequest{
-		Model: "anthropic/claude-3.5-sonnet",
-		Messages: []Message{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
-	}
-
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %v", err)
-	}
-
-	req, err := http.NewRequest("POST", "https://openrouter.ai/api/v1/chat/completions", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+openRouterAPIKey)
-	req.Header.Set("HTTP-Referer", "https://github.com/jgabriele321/Schedule_C_Calculator")
-	req.Header.Set("X-Title", "Schedule C Calculator")
-
-	client := &http.Client{Timeout: 60 * time.Second} // Increased timeout for batch processing
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("OpenRouter API error %d: %s", resp.StatusCode, string(body))
-	}
-
-	var openRouterResp OpenRouterResponse
-	if err := json.NewDecoder(resp.Body).Decode(&openRouterResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %v", err)
-	}
-
-	if len(openRouterResp.Choices) == 0 {
-		return nil, fmt.Errorf("no response from LLM")
-	}
-
-	content := openRouterResp.Choices[0].Message.Content
-
-	// Clean up the content - remove markdown code blocks if present
-	content = strings.TrimSpace(content)
-	if strings.HasPrefix(content, "```json") {
-		content = strings.TrimPrefix(content, "```json")
-	}
-	if strings.HasPrefix(content, "```") {
-		content = strings.TrimPrefix(content, "```")
-	}
-	if strings.HasSuffix(content, "```") {
-		content = strings.TrimSuffix(content, "```")
-	}
-	content = strings.TrimSpace(content)
-
-	// Parse as array of classifications
-	type BatchClassification struct {
-		TransactionID string  `json:"transaction_id"`
-		Category      string  `json:"category"`
-		ScheduleCLine int     `json:"schedule_c_line"`
-		Expensable    bool    `json:"expensable"`
-		Purpose       string  `json:"purpose"`
-		Confidence    float64 `json:"confidence"`
-	}
-
-	var batchResults []BatchClassification
-	if err := json.Unmarshal([]byte(content), &batchResults); err != nil {
-		return nil, fmt.Errorf("failed to parse batch classification JSON: %v", err)
-	}
-
-	// Convert to map and validate
-	results := make(map[string]*ExpenseClassification)
-	for _, result := range batchResults {
-		classification := &ExpenseClassification{
-			Category:      result.Category,
-			ScheduleCLine: result.ScheduleCLine,
-			Expensable:    result.Expensable,
-			Purpose:       result.Purpose,
-			Confidence:    result.Confidence,
-		}
-
-		// Validate and fix schedule_c_line - never allow Line 0
-		if classification.ScheduleCLine < 8 || classification.ScheduleCLine > 27 {
-			log.Printf("⚠️ Invalid schedule_c_line %d for transaction %s, converting to Line 27 (Other business expenses)",
-				classification.ScheduleCLine, result.TransactionID)
-			classification.ScheduleCLine = 27
-			classification.Category = "Other business expenses"
-		}
-
-		results[result.TransactionID] = classification
-	}
-
-	return results, nil
-}
-
-// Individual transaction classification (fallback for batch failures)
-func classifyTransactionWithLLM(tx Transaction) (*ExpenseClassification, error) {
-	prompt := fmt.Sprintf(`You are an expert tax accountant specializing in Schedule C business expenses. 
-
-Please categorize this business transaction and provide the corresponding IRS Schedule C line number:
-
-Vendor: %s
-Amount: $%.2f
-Description: %s
-
-Based on this information, provide a JSON response with:
-1. category: Must be one of the exact categories listed below
-2. schedule_c_line: IRS Schedule C line number (MUST be 8-27, NEVER use 0)
-3. expensable: true/false if this is a legitimate business expense
-4. purpose: Brief business purpose description
-5. confidence: 0.0-1.0 confidence score
-
-REQUIRED CATEGORIES (use exact names):
-- Line 8: "Advertising"
-- Line 9: "Car and truck"
-- Line 10: "Commissions and fees"
-- Line 11: "Contractors"
-- Line 15: "Insurance"
-- Line 16: "Interest paid"
-- Line 17: "Legal fees and professional services"
-- Line 18: "Office expenses"
-- Line 20: "Rent and lease"
-- Line 21: "Repairs and maintenance"
-- Line 22: "Supplies"
-- Line 23: "Taxes and licenses"
-- Line 24: "Meals"
-- Line 25: "Travel expenses"
-- Line 26: "Utilities"
-- Line 27: "Other business expenses"
-
-CRITICAL RULES:
-- NEVER use Line 0 or any number outside 8-27
-- If uncertain about the category, ALWAYS use "Other business expenses" (Line 27)
-- If you think it's not a business expense, still use Line 27 and set expensable: false
-- The schedule_c_line MUST be between 8 and 27 (inclusive)
-
-Use the exact category name from the list above. If unsure, use "Other business expenses".
-
-Respond with ONLY valid JSON:`, tx.Vendor, tx.Amount, tx.Purpose)
-
-	requestBody := OpenRouterRequest{
-		Model: "anthropic/claude-3.5-sonnet",
-		Messages: []Message{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
-	}
-
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %v", err)
-	}
-
-	req, err := http.NewRequest("POST", "https://openrouter.ai/api/v1/chat/completions", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+openRouterAPIKey)
-	req.Header.Set("HTTP-Referer", "https://github.com/jgabriele321/Schedule_C_Calculator")
-	req.Header.Set("X-Title", "Schedule C Calculator")
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("OpenRouter API error %d: %s", resp.StatusCode, string(body))
-	}
-
-	var openRouterResp OpenRouterResponse
-	if err := json.NewDecoder(resp.Body).Decode(&openRouterResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %v", err)
-	}
-
-	if len(openRouterResp.Choices) == 0 {
-		return nil, fmt.Errorf("no response from LLM")
-	}
-
-	content := openRouterResp.Choices[0].Message.Content
-
-	var classification ExpenseClassification
-	if err := json.Unmarshal([]byte(content), &classification); err != nil {
-		return nil, fmt.Errorf("failed to parse LLM response: %v", err)
-	}
-
-	// Validate and fix schedule_c_line - never allow Line 0
-	if classification.ScheduleCLine < 8 || classification.ScheduleCLine > 27 {
-		log.Printf("⚠️ Invalid schedule_c_line %d for %s, converting to Line 27 (Other business expenses)",
-			classification.ScheduleCLine, tx.Vendor)
-		classification.ScheduleCLine = 27
-		classification.Category = "Other business expenses"
-	}
-
-	return &classification, nil
-}
-
-func updateTransactionClassification(transactionID string, classification *ExpenseClassification) error {
+func updateTransactionClassification(transactionID string, classification *ExpenseClassification, classifiedBy string) error {
 	query := `
 		UPDATE transactions 
 		SET category = ?, 
 		    purpose = ?, 
 		    expensable = ?, 
-		    schedule_c_line = ?
+		    schedule_c_line = ?,
+		    classified_by = ?
 		WHERE id = ?
 	`
 
@@ -1713,11 +2201,32 @@ func updateTransactionClassification(transactionID string, classification *Expen
 		classification.Purpose,
 		classification.Expensable,
 		classification.ScheduleCLine,
+		classifiedBy,
 		transactionID)
 
 	return err
 }
 
+// queryIDs runs a statement that returns one id column, such as an UPDATE
+// ... RETURNING id, and collects the ids
+func queryIDs(query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 func nullString(s string) interface{} {
 	if s == "" {
 		return nil
@@ -1845,20 +2354,28 @@ func applyVendorRules(w http.ResponseWriter, r *http.Request) {
 			SET category = ?, 
 			    expensable = ?, 
 			    schedule_c_line = ?,
-			    type = ?
-			WHERE vendor LIKE ? AND (category = 'uncategorized' OR category = '')
+			    type = ?,
+			    classified_by = 'vendor_rule'
+			WHERE vendor LIKE ? AND (category = 'uncategorized' OR category = '') AND type <> 'transfer'
 		`
 
-		result, err := db.Exec(updateQuery, rule.Category, rule.Expensable, rule.ScheduleCLine, rule.Type, "%"+vendor+"%")
+		ids, err := queryIDs(updateQuery+" RETURNING id", rule.Category, rule.Expensable, rule.ScheduleCLine, rule.Type, "%"+vendor+"%")
 		if err != nil {
 			log.Printf("Failed to apply rule for vendor %s: %v", vendor, err)
 			continue
 		}
 
-		rowsAffected, _ := result.RowsAffected()
-		if rowsAffected > 0 {
-			applied += int(rowsAffected)
-			log.Printf("📋 Applied rule: %s -> %s (%d transactions)", vendor, rule.Category, rowsAffected)
+		if len(ids) > 0 {
+			applied += len(ids)
+			log.Printf("📋 Applied rule: %s -> %s (%d transactions)", vendor, rule.Category, len(ids))
+			publishEvent(EventRuleApplied, RuleAppliedEvent{
+				RuleType:       "vendor_rule",
+				Rule:           vendor,
+				Category:       rule.Category,
+				ScheduleCLine:  rule.ScheduleCLine,
+				Count:          len(ids),
+				TransactionIDs: ids,
+			})
 		}
 	}
 
@@ -1903,10 +2420,10 @@ func updateVehicleDeduction(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Calculate deduction at $0.67/mile (2024 IRS rate)
-	mileageRate := 0.67
-	deduction := float64(request.BusinessMiles) * mileageRate
+	mileageRate := Cents(67)
+	deduction := Cents(request.BusinessMiles) * mileageRate
 
-	log.Printf("🚗 Vehicle deduction updated: %d miles × $%.2f = $%.2f", request.BusinessMiles, mileageRate, deduction)
+	log.Printf("🚗 Vehicle deduction updated: %d miles × $%s = $%s", request.BusinessMiles, mileageRate, deduction)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -1959,7 +2476,7 @@ func updateHomeOfficeDeduction(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Calculate deduction
-	var deduction float64
+	var deduction Cents
 	var method string
 
 	if request.UseSimplified {
@@ -1969,19 +2486,19 @@ func updateHomeOfficeDeduction(w http.ResponseWriter, r *http.Request) {
 		if sqft > maxSqft {
 			sqft = maxSqft
 		}
-		deduction = float64(sqft) * 5.0
+		deduction = Cents(sqft) * 500
 		method = "simplified"
 	} else {
 		// Actual expense method: percentage of home expenses
 		// This would typically require total home expenses input, defaulting to 0 for now
 		if request.TotalHomeSqft > 0 {
 			percentage := float64(request.HomeOfficeSqft) / float64(request.TotalHomeSqft)
-			deduction = 0.0 // Would multiply by total home expenses
+			deduction = 0 // Would multiply by total home expenses
 			method = fmt.Sprintf("actual (%.1f%% of home)", percentage*100)
 		}
 	}
 
-	log.Printf("🏠 Home office deduction updated: %d sqft, %s method = $%.2f", request.HomeOfficeSqft, method, deduction)
+	log.Printf("🏠 Home office deduction updated: %d sqft, %s method = $%s", request.HomeOfficeSqft, method, deduction)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -2018,8 +2535,8 @@ func getDeductions(w http.ResponseWriter, r *http.Request) {
 				"home_office_sqft":      0,
 				"total_home_sqft":       0,
 				"use_simplified":        true,
-				"vehicle_deduction":     0.0,
-				"home_office_deduction": 0.0,
+				"vehicle_deduction":     Cents(0),
+				"home_office_deduction": Cents(0),
 				"updated_at":            nil,
 			})
 			return
@@ -2030,20 +2547,20 @@ func getDeductions(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Calculate deductions
-	mileageRate := 0.67 // 2024 IRS rate
-	vehicleDeduction := float64(businessMiles) * mileageRate
+	mileageRate := Cents(67) // 2024 IRS rate
+	vehicleDeduction := Cents(businessMiles) * mileageRate
 
-	var homeOfficeDeduction float64
+	var homeOfficeDeduction Cents
 	if useSimplified {
 		maxSqft := 300
 		sqft := homeOfficeSqft
 		if sqft > maxSqft {
 			sqft = maxSqft
 		}
-		homeOfficeDeduction = float64(sqft) * 5.0
+		homeOfficeDeduction = Cents(sqft) * 500
 	} else {
 		// Actual method would require total home expenses
-		homeOfficeDeduction = 0.0
+		homeOfficeDeduction = 0
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -2059,64 +2576,78 @@ func getDeductions(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// reportTaxYear reads the tax_year query parameter of the Schedule C summaries
+// and exports. It defaults to the most recent year with transactions, or the
+// current year when there are none.
+func reportTaxYear(r *http.Request) (int, error) {
+	year, err := taxYearParam(r)
+	if err != nil || year != 0 {
+		return year, err
+	}
+
+	var latest sql.NullString
+	if err := db.QueryRow("SELECT MAX(strftime('%Y', date)) FROM transactions").Scan(&latest); err != nil {
+		return 0, fmt.Errorf("failed to find the latest tax year: %v", err)
+	}
+	if !latest.Valid {
+		return time.Now().Year(), nil
+	}
+	return strconv.Atoi(latest.String)
+}
+
 func getScheduleCSummary(w http.ResponseWriter, r *http.Request) {
+	taxYear, err := reportTaxYear(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Initialize Schedule C line items
 	scheduleC := map[string]interface{}{
 		// Income
-		"line1_gross_receipts": 0.0,
+		"line1_gross_receipts":     Cents(0),
+		"line2_returns_allowances": Cents(0),
 
 		// Expenses (Lines 8-27)
-		"line8_advertising":          0.0,
-		"line9_car_truck":            0.0,
-		"line10_commissions_fees":    0.0,
-		"line11_contract_labor":      0.0,
-		"line12_depletion":           0.0,
-		"line13_depreciation":        0.0,
-		"line14_employee_benefits":   0.0,
-		"line15_insurance":           0.0,
-		"line16_interest":            0.0,
-		"line17_legal_professional":  0.0,
-		"line18_office_expense":      0.0,
-		"line19_pension_profit":      0.0,
-		"line20_rent_lease":          0.0,
-		"line21_repairs_maintenance": 0.0,
-		"line22_supplies":            0.0,
-		"line23_taxes_licenses":      0.0,
-		"line24_travel_meals":        0.0,
-		"line25_utilities":           0.0,
-		"line26_wages":               0.0,
-		"line27_other_expenses":      0.0,
+		"line8_advertising":          Cents(0),
+		"line9_car_truck":            Cents(0),
+		"line10_commissions_fees":    Cents(0),
+		"line11_contract_labor":      Cents(0),
+		"line12_depletion":           Cents(0),
+		"line13_depreciation":        Cents(0),
+		"line14_employee_benefits":   Cents(0),
+		"line15_insurance":           Cents(0),
+		"line16_interest":            Cents(0),
+		"line17_legal_professional":  Cents(0),
+		"line18_office_expense":      Cents(0),
+		"line19_pension_profit":      Cents(0),
+		"line20_rent_lease":          Cents(0),
+		"line21_repairs_maintenance": Cents(0),
+		"line22_supplies":            Cents(0),
+		"line23_taxes_licenses":      Cents(0),
+		"line24_travel_meals":        Cents(0),
+		"line25_utilities":           Cents(0),
+		"line26_wages":               Cents(0),
+		"line27_other_expenses":      Cents(0),
 
 		// Special deductions
-		"line30_home_office": 0.0,
+		"line30_home_office": Cents(0),
 
 		// Calculated fields
-		"line28_total_expenses":  0.0,
-		"line31_net_profit_loss": 0.0,
+		"line28_total_expenses":  Cents(0),
+		"line31_net_profit_loss": Cents(0),
 	}
 
-	// Get all income transactions
-	incomeQuery := `
-		SELECT SUM(ABS(amount)) 
-		FROM transactions 
-		WHERE type = 'income' AND expensable = true
-	`
-	var grossReceipts sql.NullFloat64
-	err := db.QueryRow(incomeQuery).Scan(&grossReceipts)
-	if err != nil && err != sql.ErrNoRows {
+	// Get income, with refunds and 1099-K adjustments on Line 2
+	grossReceipts, returnsAllowances, adjustment1099K, err := incomeLines(taxYear)
+	if err != nil {
 		log.Printf("Error calculating gross receipts: %v", err)
 	}
-	if grossReceipts.Valid {
-		scheduleC["line1_gross_receipts"] = grossReceipts.Float64
-	}
+	scheduleC["line1_gross_receipts"] = grossReceipts
+	scheduleC["line2_returns_allowances"] = returnsAllowances
 
-	// Get expenses by Schedule C line number
-	expenseQuery := `
-		SELECT schedule_c_line, SUM(ABS(amount)) 
-		FROM transactions 
-		WHERE type = 'expense' AND expensable = true AND schedule_c_line > 0
-		GROUP BY schedule_c_line
-	`
+	// Get expenses by Schedule C line number, net of refunds
+	expenseQuery := expenseLinesQuery("p.expensable = true", taxYear)
 
 	expenseRows, err := db.Query(expenseQuery)
 	if err != nil {
@@ -2126,10 +2657,10 @@ func getScheduleCSummary(w http.ResponseWriter, r *http.Request) {
 	}
 	defer expenseRows.Close()
 
-	var totalExpenses float64
+	var totalExpenses Cents
 	for expenseRows.Next() {
 		var lineNumber int
-		var amount float64
+		var amount Cents
 		err := expenseRows.Scan(&lineNumber, &amount)
 		if err != nil {
 			log.Printf("Error scanning expense row: %v", err)
@@ -2196,21 +2727,21 @@ func getScheduleCSummary(w http.ResponseWriter, r *http.Request) {
 	err = db.QueryRow(deductionQuery).Scan(&businessMiles, &homeOfficeSqft, &useSimplified)
 	if err == nil {
 		// Vehicle deduction (Line 9)
-		mileageRate := 0.67 // 2024 IRS rate
-		vehicleDeduction := float64(businessMiles) * mileageRate
-		currentLine9 := scheduleC["line9_car_truck"].(float64)
+		mileageRate := Cents(67) // 2024 IRS rate
+		vehicleDeduction := Cents(businessMiles) * mileageRate
+		currentLine9 := scheduleC["line9_car_truck"].(Cents)
 		scheduleC["line9_car_truck"] = currentLine9 + vehicleDeduction
 		totalExpenses += vehicleDeduction
 
 		// Home office deduction (Line 30)
-		var homeOfficeDeduction float64
+		var homeOfficeDeduction Cents
 		if useSimplified {
 			maxSqft := 300
 			sqft := homeOfficeSqft
 			if sqft > maxSqft {
 				sqft = maxSqft
 			}
-			homeOfficeDeduction = float64(sqft) * 5.0
+			homeOfficeDeduction = Cents(sqft) * 500
 		}
 		scheduleC["line30_home_office"] = homeOfficeDeduction
 		totalExpenses += homeOfficeDeduction
@@ -2218,8 +2749,8 @@ func getScheduleCSummary(w http.ResponseWriter, r *http.Request) {
 
 	// Calculate totals
 	scheduleC["line28_total_expenses"] = totalExpenses
-	grossReceiptsValue := scheduleC["line1_gross_receipts"].(float64)
-	netProfitLoss := grossReceiptsValue - totalExpenses
+	grossReceiptsValue := scheduleC["line1_gross_receipts"].(Cents)
+	netProfitLoss := grossReceiptsValue - returnsAllowances - totalExpenses
 	scheduleC["line31_net_profit_loss"] = netProfitLoss
 
 	// Get transaction counts for summary
@@ -2227,12 +2758,14 @@ func getScheduleCSummary(w http.ResponseWriter, r *http.Request) {
 		SELECT 
 			COUNT(CASE WHEN type = 'income' AND expensable = true THEN 1 END) as income_transactions,
 			COUNT(CASE WHEN type = 'expense' AND expensable = true THEN 1 END) as expense_transactions,
+			COUNT(CASE WHEN type = 'refund' THEN 1 END) as refund_transactions,
 			COUNT(CASE WHEN category = 'uncategorized' THEN 1 END) as uncategorized_transactions
 		FROM transactions
+		WHERE strftime('%Y', date) = ?
 	`
 
-	var incomeCount, expenseCount, uncategorizedCount int
-	err = db.QueryRow(countQuery).Scan(&incomeCount, &expenseCount, &uncategorizedCount)
+	var incomeCount, expenseCount, refundCount, uncategorizedCount int
+	err = db.QueryRow(countQuery, strconv.Itoa(taxYear)).Scan(&incomeCount, &expenseCount, &refundCount, &uncategorizedCount)
 	if err != nil {
 		log.Printf("Error getting transaction counts: %v", err)
 	}
@@ -2243,28 +2776,49 @@ func getScheduleCSummary(w http.ResponseWriter, r *http.Request) {
 		"schedule_c": scheduleC,
 		"summary": map[string]interface{}{
 			"gross_receipts":             grossReceiptsValue,
+			"returns_allowances":         returnsAllowances,
+			"form_1099k_adjustment":      adjustment1099K,
 			"total_expenses":             totalExpenses,
 			"net_profit_loss":            netProfitLoss,
 			"income_transactions":        incomeCount,
 			"expense_transactions":       expenseCount,
+			"refund_transactions":        refundCount,
 			"uncategorized_transactions": uncategorizedCount,
 			"vehicle_miles":              businessMiles,
 			"home_office_sqft":           homeOfficeSqft,
 		},
-		"tax_year":         2024,
+		"tax_year":         taxYear,
 		"calculation_date": time.Now().Format("2006-01-02 15:04:05"),
 	}
 
-	log.Printf("📊 Schedule C Summary: Gross Receipts $%.2f - Total Expenses $%.2f = Net Profit/Loss $%.2f",
-		grossReceiptsValue, totalExpenses, netProfitLoss)
+	log.Printf("📊 Schedule C Summary: Gross Receipts $%s - Returns $%s - Total Expenses $%s = Net Profit/Loss $%s",
+		grossReceiptsValue, returnsAllowances, totalExpenses, netProfitLoss)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
 func clearAllData(w http.ResponseWriter, r *http.Request) {
+	// Note the stored copies of the uploaded files before their records go
+	var storedPaths []string
+	rows, err := db.Query("SELECT id, COALESCE(path, '') FROM csv_files")
+	if err != nil {
+		log.Printf("Error querying files: %v", err)
+		http.Error(w, "Failed to clear data", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var id, path string
+		if err := rows.Scan(&id, &path); err != nil {
+			log.Printf("Error scanning file: %v", err)
+			continue
+		}
+		storedPaths = append(storedPaths, storedUploadPaths(id, path)...)
+	}
+	rows.Close()
+
 	// Clear all tables
-	tables := []string{"transactions", "csv_files", "vendor_rules", "deduction_data"}
+	tables := []string{"transactions", "csv_files", "payouts", "vendor_rules", "vendor_aliases", "deduction_data", "mapping_profiles", "forms_1099", "pdf_templates", "classification_cache", "removed_duplicates"}
 
 	var deletedCounts []map[string]interface{}
 
@@ -2296,11 +2850,16 @@ func clearAllData(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Reset auto-increment counters
-	_, err := db.Exec("DELETE FROM sqlite_sequence WHERE name IN ('vendor_rules', 'deduction_data', 'schedule_c_categories')")
+	_, err = db.Exec("DELETE FROM sqlite_sequence WHERE name IN ('vendor_rules', 'vendor_aliases', 'deduction_data', 'schedule_c_categories', 'mapping_profiles', 'forms_1099', 'pdf_templates')")
 	if err != nil {
 		log.Printf("Warning: Could not reset auto-increment counters: %v", err)
 	}
 
+	// Stored copies of the deleted files are no longer referenced
+	for _, path := range storedPaths {
+		removeUpload(path)
+	}
+
 	log.Printf("🗑️ All data cleared successfully")
 
 	w.Header().Set("Content-Type", "application/json")
@@ -2322,7 +2881,7 @@ func fixIncomeTransactions(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update all income transactions to expense
-	result, err := db.Exec("UPDATE transactions SET type = 'expense', expensable = (amount > 0) WHERE type = 'income'")
+	result, err := db.Exec("UPDATE transactions SET type = 'expense', expensable = (amount_cents > 0) WHERE type = 'income'")
 	if err != nil {
 		http.Error(w, "Failed to update transactions", http.StatusInternalServerError)
 		return
@@ -2448,30 +3007,21 @@ func toggleAllBusinessStatus(w http.ResponseWriter, r *http.Request) {
 }
 
 func getBusinessSummary(w http.ResponseWriter, r *http.Request) {
-	// Get all business income transactions
-	incomeQuery := `
-		SELECT SUM(ABS(amount)) 
-		FROM transactions 
-		WHERE type = 'income' AND is_business = true
-	`
-	var grossReceipts sql.NullFloat64
-	err := db.QueryRow(incomeQuery).Scan(&grossReceipts)
-	if err != nil && err != sql.ErrNoRows {
-		log.Printf("Error calculating business gross receipts: %v", err)
+	taxYear, err := reportTaxYear(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	businessIncome := 0.0
-	if grossReceipts.Valid {
-		businessIncome = grossReceipts.Float64
+	// Business income is Line 1 less Line 2, as on the Schedule C summary
+	grossReceipts, returnsAllowances, _, err := incomeLines(taxYear)
+	if err != nil {
+		log.Printf("Error calculating business gross receipts: %v", err)
 	}
+	businessIncome := grossReceipts - returnsAllowances
 
-	// Get business expenses by Schedule C line number
-	expenseQuery := `
-		SELECT schedule_c_line, SUM(ABS(amount)) 
-		FROM transactions 
-		WHERE type = 'expense' AND is_business = true AND schedule_c_line > 0
-		GROUP BY schedule_c_line
-	`
+	// Get business expenses by Schedule C line number, net of refunds
+	expenseQuery := expenseLinesQuery("p.is_business = true", taxYear)
 
 	expenseRows, err := db.Query(expenseQuery)
 	if err != nil {
@@ -2482,33 +3032,33 @@ func getBusinessSummary(w http.ResponseWriter, r *http.Request) {
 	defer expenseRows.Close()
 
 	scheduleC := map[string]interface{}{
-		"line8_advertising":          0.0,
-		"line9_car_truck":            0.0,
-		"line10_commissions_fees":    0.0,
-		"line11_contract_labor":      0.0,
-		"line12_depletion":           0.0,
-		"line13_depreciation":        0.0,
-		"line14_employee_benefits":   0.0,
-		"line15_insurance":           0.0,
-		"line16_interest":            0.0,
-		"line17_legal_professional":  0.0,
-		"line18_office_expense":      0.0,
-		"line19_pension_profit":      0.0,
-		"line20_rent_lease":          0.0,
-		"line21_repairs_maintenance": 0.0,
-		"line22_supplies":            0.0,
-		"line23_taxes_licenses":      0.0,
-		"line24_travel_meals":        0.0,
-		"line25_utilities":           0.0,
-		"line26_wages":               0.0,
-		"line27_other_expenses":      0.0,
-		"line30_home_office":         0.0,
-	}
-
-	var totalBusinessExpenses float64
+		"line8_advertising":          Cents(0),
+		"line9_car_truck":            Cents(0),
+		"line10_commissions_fees":    Cents(0),
+		"line11_contract_labor":      Cents(0),
+		"line12_depletion":           Cents(0),
+		"line13_depreciation":        Cents(0),
+		"line14_employee_benefits":   Cents(0),
+		"line15_insurance":           Cents(0),
+		"line16_interest":            Cents(0),
+		"line17_legal_professional":  Cents(0),
+		"line18_office_expense":      Cents(0),
+		"line19_pension_profit":      Cents(0),
+		"line20_rent_lease":          Cents(0),
+		"line21_repairs_maintenance": Cents(0),
+		"line22_supplies":            Cents(0),
+		"line23_taxes_licenses":      Cents(0),
+		"line24_travel_meals":        Cents(0),
+		"line25_utilities":           Cents(0),
+		"line26_wages":               Cents(0),
+		"line27_other_expenses":      Cents(0),
+		"line30_home_office":         Cents(0),
+	}
+
+	var totalBusinessExpenses Cents
 	for expenseRows.Next() {
 		var lineNumber int
-		var amount float64
+		var amount Cents
 		err := expenseRows.Scan(&lineNumber, &amount)
 		if err != nil {
 			log.Printf("Error scanning business expense row: %v", err)
@@ -2567,12 +3117,14 @@ func getBusinessSummary(w http.ResponseWriter, r *http.Request) {
 		SELECT 
 			COUNT(CASE WHEN type = 'income' AND is_business = true THEN 1 END) as business_income_transactions,
 			COUNT(CASE WHEN type = 'expense' AND is_business = true THEN 1 END) as business_expense_transactions,
+			COUNT(CASE WHEN type = 'refund' AND is_business = true THEN 1 END) as business_refund_transactions,
 			COUNT(CASE WHEN is_business = false THEN 1 END) as personal_transactions
 		FROM transactions
+		WHERE strftime('%Y', date) = ?
 	`
 
-	var businessIncomeCount, businessExpenseCount, personalCount int
-	err = db.QueryRow(businessCountQuery).Scan(&businessIncomeCount, &businessExpenseCount, &personalCount)
+	var businessIncomeCount, businessExpenseCount, businessRefundCount, personalCount int
+	err = db.QueryRow(businessCountQuery, strconv.Itoa(taxYear)).Scan(&businessIncomeCount, &businessExpenseCount, &businessRefundCount, &personalCount)
 	if err != nil {
 		log.Printf("Error getting business transaction counts: %v", err)
 	}
@@ -2589,14 +3141,15 @@ func getBusinessSummary(w http.ResponseWriter, r *http.Request) {
 			"net_profit_loss":               netProfitLoss,
 			"business_income_transactions":  businessIncomeCount,
 			"business_expense_transactions": businessExpenseCount,
+			"business_refund_transactions":  businessRefundCount,
 			"personal_transactions":         personalCount,
 		},
 		"schedule_c":       scheduleC,
-		"tax_year":         2024,
+		"tax_year":         taxYear,
 		"calculation_date": time.Now().Format("2006-01-02 15:04:05"),
 	}
 
-	log.Printf("📊 Business Summary: Income $%.2f - Expenses $%.2f = Net Profit/Loss $%.2f",
+	log.Printf("📊 Business Summary: Income $%s - Expenses $%s = Net Profit/Loss $%s",
 		businessIncome, totalBusinessExpenses, netProfitLoss)
 
 	w.Header().Set("Content-Type", "application/json")
@@ -2605,8 +3158,14 @@ func getBusinessSummary(w http.ResponseWriter, r *http.Request) {
 
 // Export Schedule C as PDF
 func exportScheduleCPDF(w http.ResponseWriter, r *http.Request) {
+	taxYear, err := reportTaxYear(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Get Schedule C data
-	summaryData := getScheduleCData()
+	summaryData := getScheduleCData(taxYear)
 	if summaryData == nil {
 		http.Error(w, "Failed to generate Schedule C data", http.StatusInternalServerError)
 		return
@@ -2637,7 +3196,11 @@ func exportScheduleCPDF(w http.ResponseWriter, r *http.Request) {
 	pdf.SetFont("Arial", "", 11)
 	pdf.Cell(20, 6, "1")
 	pdf.Cell(100, 6, "Gross receipts or sales")
-	pdf.Cell(70, 6, fmt.Sprintf("$%.2f", scheduleC["line1_gross_receipts"]))
+	pdf.Cell(70, 6, fmt.Sprintf("$%v", scheduleC["line1_gross_receipts"]))
+	pdf.Ln(8)
+	pdf.Cell(20, 6, "2")
+	pdf.Cell(100, 6, "Returns and allowances")
+	pdf.Cell(70, 6, fmt.Sprintf("$%v", scheduleC["line2_returns_allowances"]))
 	pdf.Ln(8)
 
 	// Part II - Expenses
@@ -2649,52 +3212,52 @@ func exportScheduleCPDF(w http.ResponseWriter, r *http.Request) {
 	pdf.SetFont("Arial", "", 11)
 
 	// Helper function to add expense line
-	addExpenseLine := func(lineNum, description string, amount float64) {
+	addExpenseLine := func(lineNum, description string, amount Cents) {
 		pdf.Cell(20, 6, lineNum)
 		pdf.Cell(100, 6, description)
 		if amount == 0 {
 			pdf.Cell(70, 6, "-")
 		} else {
-			pdf.Cell(70, 6, fmt.Sprintf("$%.2f", amount))
+			pdf.Cell(70, 6, fmt.Sprintf("$%s", amount))
 		}
 		pdf.Ln(8)
 	}
 
-	addExpenseLine("8", "Advertising", scheduleC["line8_advertising"].(float64))
-	addExpenseLine("9", "Car and truck expenses", scheduleC["line9_car_truck"].(float64))
-	addExpenseLine("10", "Commissions and fees", scheduleC["line10_commissions_fees"].(float64))
-	addExpenseLine("11", "Contract labor", scheduleC["line11_contract_labor"].(float64))
-	addExpenseLine("12", "Depletion", scheduleC["line12_depletion"].(float64))
-	addExpenseLine("13", "Depreciation and section 179", scheduleC["line13_depreciation"].(float64))
-	addExpenseLine("14", "Employee benefit programs", scheduleC["line14_employee_benefits"].(float64))
-	addExpenseLine("15", "Insurance (other than health)", scheduleC["line15_insurance"].(float64))
-	addExpenseLine("16", "Interest", scheduleC["line16_interest"].(float64))
-	addExpenseLine("17", "Legal and professional services", scheduleC["line17_legal_professional"].(float64))
-	addExpenseLine("18", "Office expense", scheduleC["line18_office_expense"].(float64))
-	addExpenseLine("19", "Pension and profit-sharing plans", scheduleC["line19_pension_profit"].(float64))
-	addExpenseLine("20", "Rent or lease", scheduleC["line20_rent_lease"].(float64))
-	addExpenseLine("21", "Repairs and maintenance", scheduleC["line21_repairs_maintenance"].(float64))
-	addExpenseLine("22", "Supplies", scheduleC["line22_supplies"].(float64))
-	addExpenseLine("23", "Taxes and licenses", scheduleC["line23_taxes_licenses"].(float64))
-	addExpenseLine("24", "Travel and meals", scheduleC["line24_travel_meals"].(float64))
-	addExpenseLine("25", "Utilities", scheduleC["line25_utilities"].(float64))
-	addExpenseLine("26", "Wages", scheduleC["line26_wages"].(float64))
-	addExpenseLine("27", "Other expenses", scheduleC["line27_other_expenses"].(float64))
+	addExpenseLine("8", "Advertising", scheduleC["line8_advertising"].(Cents))
+	addExpenseLine("9", "Car and truck expenses", scheduleC["line9_car_truck"].(Cents))
+	addExpenseLine("10", "Commissions and fees", scheduleC["line10_commissions_fees"].(Cents))
+	addExpenseLine("11", "Contract labor", scheduleC["line11_contract_labor"].(Cents))
+	addExpenseLine("12", "Depletion", scheduleC["line12_depletion"].(Cents))
+	addExpenseLine("13", "Depreciation and section 179", scheduleC["line13_depreciation"].(Cents))
+	addExpenseLine("14", "Employee benefit programs", scheduleC["line14_employee_benefits"].(Cents))
+	addExpenseLine("15", "Insurance (other than health)", scheduleC["line15_insurance"].(Cents))
+	addExpenseLine("16", "Interest", scheduleC["line16_interest"].(Cents))
+	addExpenseLine("17", "Legal and professional services", scheduleC["line17_legal_professional"].(Cents))
+	addExpenseLine("18", "Office expense", scheduleC["line18_office_expense"].(Cents))
+	addExpenseLine("19", "Pension and profit-sharing plans", scheduleC["line19_pension_profit"].(Cents))
+	addExpenseLine("20", "Rent or lease", scheduleC["line20_rent_lease"].(Cents))
+	addExpenseLine("21", "Repairs and maintenance", scheduleC["line21_repairs_maintenance"].(Cents))
+	addExpenseLine("22", "Supplies", scheduleC["line22_supplies"].(Cents))
+	addExpenseLine("23", "Taxes and licenses", scheduleC["line23_taxes_licenses"].(Cents))
+	addExpenseLine("24", "Travel and meals", scheduleC["line24_travel_meals"].(Cents))
+	addExpenseLine("25", "Utilities", scheduleC["line25_utilities"].(Cents))
+	addExpenseLine("26", "Wages", scheduleC["line26_wages"].(Cents))
+	addExpenseLine("27", "Other expenses", scheduleC["line27_other_expenses"].(Cents))
 
 	pdf.Ln(5)
 	pdf.SetFont("Arial", "B", 11)
 	pdf.Cell(20, 6, "28")
 	pdf.Cell(100, 6, "Total expenses")
-	pdf.Cell(70, 6, fmt.Sprintf("$%.2f", scheduleC["line28_total_expenses"]))
+	pdf.Cell(70, 6, fmt.Sprintf("$%v", scheduleC["line28_total_expenses"]))
 	pdf.Ln(12)
 
 	pdf.Cell(20, 6, "31")
 	pdf.Cell(100, 6, "Net profit or (loss)")
-	netProfit := scheduleC["line31_net_profit_loss"].(float64)
+	netProfit := scheduleC["line31_net_profit_loss"].(Cents)
 	if netProfit < 0 {
-		pdf.Cell(70, 6, fmt.Sprintf("-$%.2f", -netProfit))
+		pdf.Cell(70, 6, fmt.Sprintf("-$%s", -netProfit))
 	} else {
-		pdf.Cell(70, 6, fmt.Sprintf("$%.2f", netProfit))
+		pdf.Cell(70, 6, fmt.Sprintf("$%s", netProfit))
 	}
 	pdf.Ln(15)
 
@@ -2722,10 +3285,10 @@ func exportScheduleCPDF(w http.ResponseWriter, r *http.Request) {
 
 	// Set headers for PDF download
 	w.Header().Set("Content-Type", "application/pdf")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=Schedule_C_%d.pdf", time.Now().Year()))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=Schedule_C_%d.pdf", taxYear))
 
 	// Output PDF to response
-	err := pdf.Output(w)
+	err = pdf.Output(w)
 	if err != nil {
 		log.Printf("Error generating PDF: %v", err)
 		http.Error(w, "Failed to generate PDF", http.StatusInternalServerError)
@@ -2737,14 +3300,22 @@ func exportScheduleCPDF(w http.ResponseWriter, r *http.Request) {
 
 // Export detailed transaction data as CSV
 func exportScheduleCSV(w http.ResponseWriter, r *http.Request) {
-	// Get all transactions
+	taxYear, err := reportTaxYear(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get the tax year's transactions
 	query := `
-		SELECT id, date, vendor, amount, card, category, purpose, expensable, type, source_file, schedule_c_line, is_business
+		SELECT id, date, vendor, amount_cents, card, category, purpose, expensable, type, source_file, schedule_c_line, is_business,
+		       COALESCE(refund_of, '')
 		FROM transactions
+		WHERE strftime('%Y', date) = ?
 		ORDER BY date DESC
 	`
 
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, strconv.Itoa(taxYear))
 	if err != nil {
 		log.Printf("Error querying transactions for CSV export: %v", err)
 		http.Error(w, "Failed to export CSV", http.StatusInternalServerError)
@@ -2754,16 +3325,16 @@ func exportScheduleCSV(w http.ResponseWriter, r *http.Request) {
 
 	// Set headers for CSV download
 	w.Header().Set("Content-Type", "text/csv")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=Schedule_C_Details_%d.csv", time.Now().Year()))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=Schedule_C_Details_%d.csv", taxYear))
 
 	// Write CSV header
-	csvHeader := "Date,Vendor,Amount,Card,Category,Purpose,Expensable,Type,Source File,Schedule C Line,Is Business,Transaction ID\n"
+	csvHeader := "Date,Vendor,Amount,Card,Category,Purpose,Expensable,Type,Source File,Schedule C Line,Is Business,Transaction ID,Refund Of\n"
 	w.Write([]byte(csvHeader))
 
 	// Write transaction data
 	for rows.Next() {
 		var tx Transaction
-		err := rows.Scan(&tx.ID, &tx.Date, &tx.Vendor, &tx.Amount, &tx.Card, &tx.Category, &tx.Purpose, &tx.Expensable, &tx.Type, &tx.SourceFile, &tx.ScheduleCLine, &tx.IsBusiness)
+		err := rows.Scan(&tx.ID, &tx.Date, &tx.Vendor, &tx.Amount, &tx.Card, &tx.Category, &tx.Purpose, &tx.Expensable, &tx.Type, &tx.SourceFile, &tx.ScheduleCLine, &tx.IsBusiness, &tx.RefundOf)
 		if err != nil {
 			log.Printf("Error scanning transaction for CSV: %v", err)
 			continue
@@ -2786,14 +3357,14 @@ func exportScheduleCSV(w http.ResponseWriter, r *http.Request) {
 			isBusinessStr = "Yes"
 		}
 
-		csvLine := fmt.Sprintf("%s,%s,%.2f,%s,%s,%s,%s,%s,%s,%d,%s,%s\n",
-			dateStr, vendor, tx.Amount, tx.Card, category, purpose, expensableStr, tx.Type, sourceFile, tx.ScheduleCLine, isBusinessStr, tx.ID)
+		csvLine := fmt.Sprintf("%s,%s,%s,%s,%s,%s,%s,%s,%s,%d,%s,%s,%s\n",
+			dateStr, vendor, tx.Amount, tx.Card, category, purpose, expensableStr, tx.Type, sourceFile, tx.ScheduleCLine, isBusinessStr, tx.ID, tx.RefundOf)
 
 		w.Write([]byte(csvLine))
 	}
 
 	// Add summary section
-	summaryData := getScheduleCData()
+	summaryData := getScheduleCData(taxYear)
 	if summaryData != nil {
 		scheduleC := summaryData["schedule_c"].(map[string]interface{})
 		summary := summaryData["summary"].(map[string]interface{})
@@ -2801,22 +3372,23 @@ func exportScheduleCSV(w http.ResponseWriter, r *http.Request) {
 		summarySection := fmt.Sprintf(`
 SCHEDULE C SUMMARY
 Line Item,Amount
-Gross Receipts (Line 1),%.2f
-Advertising (Line 8),%.2f
-Car and Truck (Line 9),%.2f
-Commissions and Fees (Line 10),%.2f
-Contract Labor (Line 11),%.2f
-Insurance (Line 15),%.2f
-Legal and Professional (Line 17),%.2f
-Office Expense (Line 18),%.2f
-Rent or Lease (Line 20),%.2f
-Supplies (Line 22),%.2f
-Travel and Meals (Line 24),%.2f
-Utilities (Line 25),%.2f
-Other Expenses (Line 27),%.2f
-Total Expenses (Line 28),%.2f
-Home Office Deduction (Line 30),%.2f
-Net Profit/Loss (Line 31),%.2f
+Gross Receipts (Line 1),%v
+Returns and Allowances (Line 2),%v
+Advertising (Line 8),%v
+Car and Truck (Line 9),%v
+Commissions and Fees (Line 10),%v
+Contract Labor (Line 11),%v
+Insurance (Line 15),%v
+Legal and Professional (Line 17),%v
+Office Expense (Line 18),%v
+Rent or Lease (Line 20),%v
+Supplies (Line 22),%v
+Travel and Meals (Line 24),%v
+Utilities (Line 25),%v
+Other Expenses (Line 27),%v
+Total Expenses (Line 28),%v
+Home Office Deduction (Line 30),%v
+Net Profit/Loss (Line 31),%v
 
 SUMMARY STATISTICS
 Income Transactions,%v
@@ -2825,6 +3397,7 @@ Vehicle Miles,%v
 Home Office Sq Ft,%v
 `,
 			scheduleC["line1_gross_receipts"],
+			scheduleC["line2_returns_allowances"],
 			scheduleC["line8_advertising"],
 			scheduleC["line9_car_truck"],
 			scheduleC["line10_commissions_fees"],
@@ -2852,51 +3425,46 @@ Home Office Sq Ft,%v
 	log.Printf("📊 Schedule C CSV exported successfully")
 }
 
-// Helper function to get Schedule C data (reused by both export functions)
-func getScheduleCData() map[string]interface{} {
+// Helper function to get Schedule C data for taxYear (reused by both export functions)
+func getScheduleCData(taxYear int) map[string]interface{} {
 	// Initialize Schedule C line items
 	scheduleC := map[string]interface{}{
-		"line1_gross_receipts":       0.0,
-		"line8_advertising":          0.0,
-		"line9_car_truck":            0.0,
-		"line10_commissions_fees":    0.0,
-		"line11_contract_labor":      0.0,
-		"line12_depletion":           0.0,
-		"line13_depreciation":        0.0,
-		"line14_employee_benefits":   0.0,
-		"line15_insurance":           0.0,
-		"line16_interest":            0.0,
-		"line17_legal_professional":  0.0,
-		"line18_office_expense":      0.0,
-		"line19_pension_profit":      0.0,
-		"line20_rent_lease":          0.0,
-		"line21_repairs_maintenance": 0.0,
-		"line22_supplies":            0.0,
-		"line23_taxes_licenses":      0.0,
-		"line24_travel_meals":        0.0,
-		"line25_utilities":           0.0,
-		"line26_wages":               0.0,
-		"line27_other_expenses":      0.0,
-		"line30_home_office":         0.0,
-		"line28_total_expenses":      0.0,
-		"line31_net_profit_loss":     0.0,
-	}
-
-	// Get income
-	incomeQuery := `SELECT SUM(ABS(amount)) FROM transactions WHERE type = 'income' AND expensable = true`
-	var grossReceipts sql.NullFloat64
-	err := db.QueryRow(incomeQuery).Scan(&grossReceipts)
-	if err == nil && grossReceipts.Valid {
-		scheduleC["line1_gross_receipts"] = grossReceipts.Float64
-	}
-
-	// Get expenses by Schedule C line
-	expenseQuery := `
-		SELECT schedule_c_line, SUM(ABS(amount)) 
-		FROM transactions 
-		WHERE type = 'expense' AND expensable = true AND schedule_c_line > 0
-		GROUP BY schedule_c_line
-	`
+		"line1_gross_receipts":       Cents(0),
+		"line2_returns_allowances":   Cents(0),
+		"line8_advertising":          Cents(0),
+		"line9_car_truck":            Cents(0),
+		"line10_commissions_fees":    Cents(0),
+		"line11_contract_labor":      Cents(0),
+		"line12_depletion":           Cents(0),
+		"line13_depreciation":        Cents(0),
+		"line14_employee_benefits":   Cents(0),
+		"line15_insurance":           Cents(0),
+		"line16_interest":            Cents(0),
+		"line17_legal_professional":  Cents(0),
+		"line18_office_expense":      Cents(0),
+		"line19_pension_profit":      Cents(0),
+		"line20_rent_lease":          Cents(0),
+		"line21_repairs_maintenance": Cents(0),
+		"line22_supplies":            Cents(0),
+		"line23_taxes_licenses":      Cents(0),
+		"line24_travel_meals":        Cents(0),
+		"line25_utilities":           Cents(0),
+		"line26_wages":               Cents(0),
+		"line27_other_expenses":      Cents(0),
+		"line30_home_office":         Cents(0),
+		"line28_total_expenses":      Cents(0),
+		"line31_net_profit_loss":     Cents(0),
+	}
+
+	// Get income, with refunds and 1099-K adjustments on Line 2
+	grossReceipts, returnsAllowances, _, err := incomeLines(taxYear)
+	if err == nil {
+		scheduleC["line1_gross_receipts"] = grossReceipts
+		scheduleC["line2_returns_allowances"] = returnsAllowances
+	}
+
+	// Get expenses by Schedule C line, net of refunds
+	expenseQuery := expenseLinesQuery("p.expensable = true", taxYear)
 
 	expenseRows, err := db.Query(expenseQuery)
 	if err != nil {
@@ -2904,10 +3472,10 @@ func getScheduleCData() map[string]interface{} {
 	}
 	defer expenseRows.Close()
 
-	var totalExpenses float64
+	var totalExpenses Cents
 	for expenseRows.Next() {
 		var lineNumber int
-		var amount float64
+		var amount Cents
 		err := expenseRows.Scan(&lineNumber, &amount)
 		if err != nil {
 			continue
@@ -2948,31 +3516,33 @@ func getScheduleCData() map[string]interface{} {
 			COUNT(CASE WHEN type = 'income' AND expensable = true THEN 1 END) as income_transactions,
 			COUNT(CASE WHEN type = 'expense' AND expensable = true THEN 1 END) as expense_transactions
 		FROM transactions
+		WHERE strftime('%Y', date) = ?
 	`
 
 	var incomeCount, expenseCount int
-	err = db.QueryRow(countQuery).Scan(&incomeCount, &expenseCount)
+	err = db.QueryRow(countQuery, strconv.Itoa(taxYear)).Scan(&incomeCount, &expenseCount)
 	if err != nil {
 		incomeCount, expenseCount = 0, 0
 	}
 
 	scheduleC["line28_total_expenses"] = totalExpenses
-	grossReceiptsValue := scheduleC["line1_gross_receipts"].(float64)
-	scheduleC["line31_net_profit_loss"] = grossReceiptsValue - totalExpenses
+	grossReceiptsValue := scheduleC["line1_gross_receipts"].(Cents)
+	scheduleC["line31_net_profit_loss"] = grossReceiptsValue - returnsAllowances - totalExpenses
 
 	return map[string]interface{}{
 		"success":    true,
 		"schedule_c": scheduleC,
 		"summary": map[string]interface{}{
 			"gross_receipts":       grossReceiptsValue,
+			"returns_allowances":   returnsAllowances,
 			"total_expenses":       totalExpenses,
-			"net_profit_loss":      grossReceiptsValue - totalExpenses,
+			"net_profit_loss":      grossReceiptsValue - returnsAllowances - totalExpenses,
 			"income_transactions":  incomeCount,
 			"expense_transactions": expenseCount,
 			"vehicle_miles":        0,
 			"home_office_sqft":     0,
 		},
-		"tax_year":         2024,
+		"tax_year":         taxYear,
 		"calculation_date": time.Now().Format("2006-01-02 15:04:05"),
 	}
 }
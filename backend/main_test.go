@@ -0,0 +1,40 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// openTestDB points db at a fresh database with every table created
+func openTestDB(t *testing.T) {
+	t.Helper()
+	var err error
+	db, err = sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		db = nil
+	})
+	if err := createTables(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// insertTransactions saves rows as an upload would
+func insertTransactions(t *testing.T, transactions []Transaction) {
+	t.Helper()
+	dbTx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbTx.Rollback()
+	if _, err := saveTransactions(dbTx, transactions); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbTx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Cents is an amount of money in hundredths of a dollar. Amounts are parsed,
+// stored and summed as integers so totals don't pick up floating-point error;
+// JSON still carries dollars (12.34) for the frontend.
+type Cents int64
+
+// centsFromDollars rounds a dollar amount to the nearest cent
+func centsFromDollars(dollars float64) Cents {
+	return Cents(math.Round(dollars * 100))
+}
+
+func (c Cents) Abs() Cents {
+	if c < 0 {
+		return -c
+	}
+	return c
+}
+
+// String formats the amount as dollars with two decimals, e.g. "-12.05"
+func (c Cents) String() string {
+	sign := ""
+	if c < 0 {
+		sign = "-"
+	}
+	abs := c.Abs()
+	return fmt.Sprintf("%s%d.%02d", sign, abs/100, abs%100)
+}
+
+func (c Cents) MarshalJSON() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *Cents) UnmarshalJSON(data []byte) error {
+	amount, err := parseMoney(strings.Trim(string(data), `"`), ".")
+	if err != nil {
+		return err
+	}
+	*c = amount
+	return nil
+}
+
+// parseMoney parses an amount as banks write it: "$1,234.56", "(45.00)",
+// "-45.00", "45.00-", "45.00 CR", "1.234,56", "USD 12.00". Parentheses, a
+// trailing minus and a CR suffix mean negative; a DR suffix is positive.
+//
+// decimalSeparator is "." or "," when the export's convention is known (set
+// per mapping profile). When empty the separator is inferred: if both "."
+// and "," appear the last one is the decimal point, and a lone "," followed
+// by other than three digits ("45,00") is a decimal comma.
+func parseMoney(value, decimalSeparator string) (Cents, error) {
+	original := value
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("empty amount")
+	}
+
+	negative := false
+	upper := strings.ToUpper(value)
+	switch {
+	case strings.HasSuffix(upper, "CR"):
+		negative = true
+		value = value[:len(value)-2]
+	case strings.HasSuffix(upper, "DR"):
+		value = value[:len(value)-2]
+	}
+	value = strings.TrimSpace(value)
+
+	if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+		negative = !negative
+		value = value[1 : len(value)-1]
+	}
+
+	// Drop currency symbols and codes, spaces and apostrophes used as
+	// thousands separators, keeping digits, separators and signs
+	var b strings.Builder
+	for _, r := range value {
+		if unicode.IsDigit(r) || r == '.' || r == ',' || r == '-' || r == '+' {
+			b.WriteRune(r)
+		}
+	}
+	value = b.String()
+
+	if strings.HasPrefix(value, "-") {
+		negative = !negative
+		value = value[1:]
+	} else if strings.HasSuffix(value, "-") {
+		negative = !negative
+		value = value[:len(value)-1]
+	}
+	value = strings.TrimPrefix(value, "+")
+
+	if decimalSeparator == "" {
+		decimalSeparator = inferDecimalSeparator(value)
+	}
+	if decimalSeparator == "," {
+		value = strings.ReplaceAll(value, ".", "")
+		value = strings.Replace(value, ",", ".", 1)
+	} else {
+		value = strings.ReplaceAll(value, ",", "")
+	}
+
+	amount, err := parseDecimalCents(value)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse amount: %s", original)
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// parseDecimalCents converts an unsigned decimal like "1234.567" to cents
+// without going through float64, rounding half up beyond two places
+func parseDecimalCents(value string) (Cents, error) {
+	whole, fraction, _ := strings.Cut(value, ".")
+	if whole == "" && fraction == "" {
+		return 0, fmt.Errorf("no digits")
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	for _, r := range whole + fraction {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("invalid character %q", r)
+		}
+	}
+
+	dollars, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	fraction += "000"
+	cents, _ := strconv.ParseInt(fraction[:2], 10, 64)
+	if fraction[2] >= '5' {
+		cents++
+	}
+	return Cents(dollars*100 + cents), nil
+}
+
+// inferDecimalSeparator guesses whether "," or "." is the decimal point in a
+// number that has already had its sign and currency stripped
+func inferDecimalSeparator(value string) string {
+	lastComma := strings.LastIndex(value, ",")
+	lastDot := strings.LastIndex(value, ".")
+
+	switch {
+	case lastComma == -1:
+		return "."
+	case lastDot != -1:
+		if lastComma > lastDot {
+			return ","
+		}
+		return "."
+	case strings.Count(value, ",") == 1 && len(value)-lastComma-1 != 3:
+		return ","
+	default:
+		return "."
+	}
+}
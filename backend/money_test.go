@@ -0,0 +1,111 @@
+package main
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		value            string
+		decimalSeparator string
+		want             Cents
+	}{
+		{"45.00", "", 4500},
+		{"$1,234.56", "", 123456},
+		{"-$1,234.56", "", -123456},
+		{"$-12.50", "", -1250},
+		{"+7.25", "", 725},
+		{"USD 12.00", "", 1200},
+		{"1'234.56", "", 123456},
+		{"(45.00)", "", -4500},
+		{"($1,234.56)", "", -123456},
+		{"45.00-", "", -4500},
+		{"1,234.56-", "", -123456},
+		{"45.00 CR", "", -4500},
+		{"45.00CR", "", -4500},
+		{"45.00 cr", "", -4500},
+		{"45.00 DR", "", 4500},
+		{"(45.00) CR", "", 4500}, // Both mean negative, so they cancel out
+		{"1.234,56", "", 123456},
+		{"1.234,56 €", "", 123456},
+		{"45,00", "", 4500},
+		{"-45,5", "", -4550},
+		{"1.235", "", 124}, // Rounded half up past the cents
+		{"0.004", "", 0},
+		{".99", "", 99},
+
+		// The export's separator, when known, overrides inference
+		{"1.234,56", ",", 123456},
+		{"1234,5", ",", 123450},
+		{"1,234.56", ".", 123456},
+		{"1.234", ",", 123400},
+		{"1,234", ",", 123},
+	}
+
+	for _, tt := range tests {
+		got, err := parseMoney(tt.value, tt.decimalSeparator)
+		if err != nil {
+			t.Errorf("parseMoney(%q, %q): %v", tt.value, tt.decimalSeparator, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseMoney(%q, %q) = %d, want %d", tt.value, tt.decimalSeparator, got, tt.want)
+		}
+	}
+}
+
+func TestParseMoneyInvalid(t *testing.T) {
+	for _, value := range []string{"", "   ", "abc", "CR", "()", "12.34.56x", "1.2.3"} {
+		if got, err := parseMoney(value, ""); err == nil {
+			t.Errorf("parseMoney(%q) = %d, want an error", value, got)
+		}
+	}
+}
+
+// A lone comma followed by exactly three digits is ambiguous: "1,234" is
+// read as thousands unless the file's profile says commas are decimal points
+func TestInferDecimalSeparator(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"1234.56", "."},
+		{"1,234.56", "."},
+		{"1.234,56", ","},
+		{"12,5", ","},
+		{"45,00", ","},
+		{"1,234", "."},
+		{"1,234,567", "."},
+		{"1.234", "."},
+		{"1234", "."},
+	}
+
+	for _, tt := range tests {
+		if got := inferDecimalSeparator(tt.value); got != tt.want {
+			t.Errorf("inferDecimalSeparator(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+
+	if got, _ := parseMoney("1,234", ""); got != 123400 {
+		t.Errorf(`parseMoney("1,234") = %d, want 123400`, got)
+	}
+}
+
+// A mapping profile's separator applies to every value in the file, so
+// amounts that would be inferred differently on their own agree
+func TestProfileDecimalSeparator(t *testing.T) {
+	values := []string{"1.234,56", "1,234", "12,00", "-3,5"}
+	want := []Cents{123456, 123, 1200, -350}
+
+	for i, value := range values {
+		got, err := parseAmountFieldWith(value, ",")
+		if err != nil {
+			t.Fatalf("parseAmountFieldWith(%q): %v", value, err)
+		}
+		if got != want[i] {
+			t.Errorf("parseAmountFieldWith(%q, \",\") = %d, want %d", value, got, want[i])
+		}
+	}
+
+	if got, err := parseAmountFieldWith("", ","); err != nil || got != 0 {
+		t.Errorf("empty cell = %d, %v; want 0", got, err)
+	}
+}
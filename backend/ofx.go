@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ofxNode is one element of a parsed OFX document. OFX 1.x (SGML) leaves
+// aggregates and leaf elements unclosed, so both versions are parsed into
+// this loose tree rather than through encoding/xml.
+type ofxNode struct {
+	Name     string
+	Value    string
+	Children []*ofxNode
+	parent   *ofxNode
+}
+
+// child returns the first direct child with the given tag name
+func (n *ofxNode) child(name string) *ofxNode {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// find walks a path of tag names below n, e.g. find("SONRS", "FI", "ORG")
+func (n *ofxNode) find(path ...string) *ofxNode {
+	current := n
+	for _, name := range path {
+		current = current.child(name)
+		if current == nil {
+			return nil
+		}
+	}
+	return current
+}
+
+// value returns the text of the element at path, or "" if it doesn't exist
+func (n *ofxNode) value(path ...string) string {
+	if found := n.find(path...); found != nil {
+		return found.Value
+	}
+	return ""
+}
+
+// findAll returns every descendant with the given tag name, in document order
+func (n *ofxNode) findAll(name string) []*ofxNode {
+	var matches []*ofxNode
+	for _, c := range n.Children {
+		if c.Name == name {
+			matches = append(matches, c)
+		}
+		matches = append(matches, c.findAll(name)...)
+	}
+	return matches
+}
+
+func isOFXFilename(filename string) bool {
+	lower := strings.ToLower(filename)
+	return strings.HasSuffix(lower, ".ofx") || strings.HasSuffix(lower, ".qfx")
+}
+
+// parseOFXDocument tokenizes an OFX 1.x or 2.x body into an element tree.
+// The SGML header block (OFXHEADER:100 ...) and XML prolog are skipped.
+func parseOFXDocument(content string) (*ofxNode, error) {
+	start := strings.Index(strings.ToUpper(content), "<OFX>")
+	if start == -1 {
+		return nil, fmt.Errorf("no <OFX> element found")
+	}
+	body := content[start:]
+
+	// Aggregates are always closed; in SGML files most leaves are not. Any
+	// tag that never appears with a closing tag is treated as a leaf.
+	closedTags := make(map[string]bool)
+	for _, part := range strings.Split(body, "</")[1:] {
+		if end := strings.IndexByte(part, '>'); end != -1 {
+			closedTags[strings.ToUpper(strings.TrimSpace(part[:end]))] = true
+		}
+	}
+
+	root := &ofxNode{Name: "#root"}
+	current := root
+
+	for len(body) > 0 {
+		open := strings.IndexByte(body, '<')
+		if open == -1 {
+			break
+		}
+
+		// Text between tags belongs to the most recently opened element
+		if text := strings.TrimSpace(body[:open]); text != "" && current != root {
+			current.Value = decodeOFXEntities(text)
+		}
+
+		end := strings.IndexByte(body[open:], '>')
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated tag in OFX body")
+		}
+		tag := strings.TrimSpace(body[open+1 : open+end])
+		body = body[open+end+1:]
+
+		if tag == "" || strings.HasPrefix(tag, "?") || strings.HasPrefix(tag, "!") {
+			continue
+		}
+
+		if strings.HasPrefix(tag, "/") {
+			// Closing tag: pop up to and including the matching element.
+			// Unclosed SGML leaves in between are closed implicitly.
+			name := strings.ToUpper(strings.TrimSpace(tag[1:]))
+			for n := current; n != root; n = n.parent {
+				if n.Name == name {
+					current = n.parent
+					break
+				}
+			}
+			continue
+		}
+
+		// A new tag implicitly closes an unclosed SGML leaf
+		if current != root && len(current.Children) == 0 && (current.Value != "" || !closedTags[current.Name]) {
+			current = current.parent
+		}
+
+		name := strings.ToUpper(strings.Fields(tag)[0])
+		selfClosing := strings.HasSuffix(tag, "/")
+		node := &ofxNode{Name: strings.TrimSuffix(name, "/"), parent: current}
+		current.Children = append(current.Children, node)
+		if !selfClosing {
+			current = node
+		}
+	}
+
+	ofx := root.child("OFX")
+	if ofx == nil {
+		return nil, fmt.Errorf("no <OFX> element found")
+	}
+	return ofx, nil
+}
+
+func decodeOFXEntities(s string) string {
+	replacer := strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", "\"", "&apos;", "'", "&nbsp;", " ")
+	return replacer.Replace(s)
+}
+
+// parseOFXDate parses DTPOSTED values like 20241231, 20241231120000 or
+// 20241231120000.000[-5:EST]. Only the calendar date is kept so OFX rows line
+// up with the date-only values parsed from CSV exports.
+func parseOFXDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("unable to parse OFX date: %s", value)
+	}
+	date, err := time.Parse("20060102", value[:8])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to parse OFX date: %s", value)
+	}
+	return date, nil
+}
+
+// ofxCardName builds the Card label from the statement's account info, e.g.
+// "Chase ...1091". Falls back to the filename-derived name used for CSVs.
+func ofxCardName(org, accountID, originalFilename string) string {
+	name := strings.TrimSpace(org)
+	if name == "" {
+		name = extractCardName(originalFilename)
+	}
+
+	accountID = strings.TrimSpace(accountID)
+	if accountID == "" {
+		return name
+	}
+	if len(accountID) > 4 {
+		accountID = accountID[len(accountID)-4:]
+	}
+	return fmt.Sprintf("%s ...%s", name, accountID)
+}
+
+func parseOFXFile(filePath, fileID, source, originalFilename string) (*ParsedCSVData, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+
+	ofx, err := parseOFXDocument(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OFX: %v", err)
+	}
+
+	org := ofx.value("SIGNONMSGSRSV1", "SONRS", "FI", "ORG")
+
+	exclusionRules, err := loadExclusionRules()
+	if err != nil {
+		return nil, err
+	}
+	vendorAliases, err := loadVendorAliases()
+	if err != nil {
+		return nil, err
+	}
+
+	var transactions []Transaction
+	var excludedPayments, rejectedRows []RowIssue
+	seenFITIDs := make(map[string]bool)
+	line := 0
+
+	// Bank statements use STMTRS/BANKACCTFROM, credit cards CCSTMTRS/CCACCTFROM
+	statements := append(ofx.findAll("STMTRS"), ofx.findAll("CCSTMTRS")...)
+	if len(statements) == 0 {
+		return nil, fmt.Errorf("OFX file contains no statements")
+	}
+
+	for _, statement := range statements {
+		accountID := statement.value("BANKACCTFROM", "ACCTID")
+		if accountID == "" {
+			accountID = statement.value("CCACCTFROM", "ACCTID")
+		}
+		card := ofxCardName(org, accountID, originalFilename)
+		bankAccount := statement.Name == "STMTRS"
+
+		for _, stmtTrn := range statement.findAll("STMTTRN") {
+			line++
+			fitID := stmtTrn.value("FITID")
+			values := []string{stmtTrn.value("DTPOSTED"), stmtTrn.value("TRNAMT"), stmtTrn.value("NAME"), fitID}
+
+			if fitID != "" {
+				if seenFITIDs[card+"|"+fitID] {
+					rejectedRows = append(rejectedRows, RowIssue{Line: line, Reason: "duplicate FITID in file", Values: values})
+					continue
+				}
+				seenFITIDs[card+"|"+fitID] = true
+			}
+
+			transaction, isPayment, err := parseOFXTransaction(stmtTrn, fileID, source, card, bankAccount)
+			if err != nil {
+				log.Printf("Error parsing OFX transaction %d (%s): %v", line, fitID, err)
+				rejectedRows = append(rejectedRows, RowIssue{Line: line, Reason: err.Error(), Values: values})
+				continue
+			}
+
+			transaction.line = line
+			applyVendorAliases(vendorAliases, transaction)
+
+			// Payments and transfers are kept as type "transfer" for review
+			if reason := excludeTransfer(exclusionRules, transaction, isPayment); reason != "" {
+				excludedPayments = append(excludedPayments, RowIssue{Line: line, Reason: reason, Values: values})
+			}
+
+			transactions = append(transactions, *transaction)
+		}
+	}
+
+	assignFingerprints(transactions)
+
+	return &ParsedCSVData{
+		Transactions:     transactions,
+		PaymentsExcluded: len(excludedPayments),
+		ParsedCount:      len(transactions) - len(excludedPayments),
+		Format:           "ofx",
+		ExcludedPayments: excludedPayments,
+		RejectedRows:     rejectedRows,
+	}, nil
+}
+
+func parseOFXTransaction(stmtTrn *ofxNode, fileID, source, card string, bankAccount bool) (*Transaction, bool, error) {
+	var transaction Transaction
+	transaction.ID = uuid.New().String()
+	transaction.SourceFile = fileID
+	transaction.Card = card
+	transaction.bankAccount = bankAccount
+	transaction.ExternalID = stmtTrn.value("FITID")
+
+	switch source {
+	case "income":
+		transaction.Type = "income"
+	case "expenses":
+		transaction.Type = "expense"
+	default:
+		transaction.Type = "uncategorized"
+	}
+
+	date, err := parseOFXDate(stmtTrn.value("DTPOSTED"))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid date: %v", err)
+	}
+	transaction.Date = date
+
+	description := stmtTrn.value("NAME")
+	if description == "" {
+		description = stmtTrn.value("PAYEE", "NAME")
+	}
+	if description == "" {
+		description = stmtTrn.value("MEMO")
+	}
+	transaction.Description = description
+	transaction.Vendor = extractVendorName(description)
+
+	trnAmount, err := parseMoney(stmtTrn.value("TRNAMT"), "")
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid amount: %v", err)
+	}
+
+	// OFX amounts are signed from the account's point of view (debits are negative)
+	transaction.Amount = accountAmount(trnAmount, transaction)
+
+	transaction.Category = "uncategorized"
+	transaction.Purpose = ""
+	transaction.Expensable = (transaction.Amount > 0 && transaction.Type == "expense")
+	markRefund(&transaction)
+
+	// Keep the remaining elements (TRNTYPE, MEMO, CHECKNUM, ...) as extra fields
+	for _, child := range stmtTrn.Children {
+		switch child.Name {
+		case "DTPOSTED", "TRNAMT", "FITID", "NAME":
+			continue
+		}
+		if child.Value != "" {
+			if transaction.Extra == nil {
+				transaction.Extra = make(ExtraFields)
+			}
+			transaction.Extra[child.Name] = child.Value
+		}
+	}
+
+	// Transfers between the user's own accounts are excluded like card payments
+	return &transaction, strings.EqualFold(stmtTrn.value("TRNTYPE"), "XFER"), nil
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// processorFormat is implemented by payment processor exports (Stripe,
+// Square, PayPal). Sales are imported at their gross amount with the
+// processing fee split off as a linked "Commissions and fees" expense, and
+// the file's payouts are kept so they can be matched to bank deposits.
+type processorFormat interface {
+	// Processor is the display name used as the fee's vendor, e.g. "Stripe"
+	Processor() string
+	// Fee returns the processing fee on a record: positive when charged,
+	// negative when a refund gave it back
+	Fee(record []string, headers []string) (Cents, error)
+	// Payout returns the payout a parsed record was paid out in, or nil
+	Payout(record []string, headers []string, transaction Transaction) (*Payout, error)
+}
+
+// Payout is money a processor sent to the bank. Once matched, the bank
+// deposit becomes a transfer so the sales behind it aren't counted twice.
+type Payout struct {
+	ID                 string     `json:"id" db:"id"`
+	Processor          string     `json:"processor" db:"processor"` // Format name: "stripe", "square" or "paypal"
+	PayoutID           string     `json:"payout_id" db:"payout_id"` // Processor's payout, deposit or withdrawal ID
+	Date               time.Time  `json:"date" db:"date"`
+	Amount             Cents      `json:"amount" db:"amount_cents"` // Net amount paid to the bank
+	SourceFile         string     `json:"source_file" db:"source_file"`
+	DepositID          string     `json:"deposit_id" db:"deposit_id"` // Bank deposit it was matched to
+	DepositDate        *time.Time `json:"deposit_date,omitempty"`
+	DepositCard        string     `json:"deposit_card,omitempty"`
+	DepositDescription string     `json:"deposit_description,omitempty"`
+
+	total bool // From the payout's own record, so Amount is its total rather than one sale's share
+}
+
+// payoutDepositWindow is how long after a payout's date its bank deposit can post
+const payoutDepositWindow = 5 * 24 * time.Hour
+
+func createPayoutsTable() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS payouts (
+			id TEXT PRIMARY KEY,
+			processor TEXT NOT NULL,
+			payout_id TEXT NOT NULL,
+			date DATETIME NOT NULL,
+			amount_cents INTEGER NOT NULL,
+			source_file TEXT,
+			deposit_id TEXT DEFAULT '',
+			deposit_type TEXT DEFAULT '',
+			UNIQUE(processor, payout_id)
+		);`)
+	if err != nil {
+		return fmt.Errorf("error creating payouts table: %v", err)
+	}
+	return nil
+}
+
+// splitProcessorRecord reads the fee and payout of a record parsed by a
+// processor format. A non-zero fee becomes an expense linked to the sale
+// through FeeOf. Other formats return nothing.
+func splitProcessorRecord(format FormatParser, record []string, headers []string, sale *Transaction) (*Transaction, *Payout, error) {
+	processor, ok := format.(processorFormat)
+	if !ok {
+		return nil, nil, nil
+	}
+
+	fee, err := processor.Fee(record, headers)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid fee: %v", err)
+	}
+	payout, err := processor.Payout(record, headers, *sale)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid payout: %v", err)
+	}
+	if payout != nil {
+		payout.Processor = format.Name()
+		payout.SourceFile = sale.SourceFile
+	}
+	if fee == 0 {
+		return nil, payout, nil
+	}
+
+	feeTx := Transaction{
+		ID:          uuid.New().String(),
+		Date:        sale.Date,
+		Card:        sale.Card,
+		SourceFile:  sale.SourceFile,
+		Description: fmt.Sprintf("%s fee: %s", processor.Processor(), sale.Description),
+		FeeOf:       sale.ID,
+		line:        sale.line,
+	}
+	platformFee(&feeTx, processor.Processor(), fee)
+	if sale.ExternalID != "" {
+		feeTx.ExternalID = sale.ExternalID + ":fee"
+	}
+	finishTransaction(&feeTx)
+	markRefund(&feeTx)
+	return &feeTx, payout, nil
+}
+
+// payoutSet totals a file's payouts by processor payout ID. A payout's own
+// record (Stripe "payout", PayPal withdrawal) gives its amount and date;
+// otherwise the net of the sales paid out in it is summed (Square deposits).
+type payoutSet struct {
+	payouts []Payout
+	index   map[string]int
+}
+
+func (s *payoutSet) add(part Payout) {
+	if s.index == nil {
+		s.index = make(map[string]int)
+	}
+
+	i, exists := s.index[part.PayoutID]
+	if !exists {
+		part.ID = uuid.New().String()
+		s.index[part.PayoutID] = len(s.payouts)
+		s.payouts = append(s.payouts, part)
+		return
+	}
+
+	payout := &s.payouts[i]
+	switch {
+	case payout.total:
+		// Already known in full
+	case part.total:
+		payout.Amount, payout.Date, payout.total = part.Amount, part.Date, true
+	default:
+		payout.Amount += part.Amount
+		if part.Date.After(payout.Date) {
+			payout.Date = part.Date
+		}
+	}
+}
+
+// savePayouts stores a file's payouts. Payouts already imported from an
+// overlapping export are skipped.
+func savePayouts(dbTx *sql.Tx, payouts []Payout) error {
+	if len(payouts) == 0 {
+		return nil
+	}
+
+	stmt, err := dbTx.Prepare(`
+		INSERT OR IGNORE INTO payouts (id, processor, payout_id, date, amount_cents, source_file)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare payout insert: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, payout := range payouts {
+		if _, err := stmt.Exec(payout.ID, payout.Processor, payout.PayoutID, payout.Date, payout.Amount, payout.SourceFile); err != nil {
+			return fmt.Errorf("failed to save payout %s: %v", payout.PayoutID, err)
+		}
+	}
+	return nil
+}
+
+// processorFormatNames lists the formats whose files hold processor activity
+// rather than bank deposits
+func processorFormatNames() []string {
+	var names []string
+	for _, format := range formatParsers {
+		if _, ok := format.(processorFormat); ok {
+			names = append(names, format.Name())
+		}
+	}
+	return names
+}
+
+// reconcilePayouts matches unmatched payouts to the bank deposit for the same
+// amount posted from a day before to payoutDepositWindow after the payout,
+// preferring deposits that name the processor and then the closest date.
+// Amounts are compared unsigned because bank formats sign deposits
+// differently on non-income uploads. The matched deposit becomes a transfer,
+// because the sales it pays out are already income. Returns how many
+// payouts were matched.
+func reconcilePayouts() (int, error) {
+	rows, err := db.Query("SELECT id, processor, date, amount_cents FROM payouts WHERE deposit_id = '' OR deposit_id IS NULL ORDER BY date")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query payouts: %v", err)
+	}
+
+	var payouts []Payout
+	for rows.Next() {
+		var payout Payout
+		if err := rows.Scan(&payout.ID, &payout.Processor, &payout.Date, &payout.Amount); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan payout: %v", err)
+		}
+		payouts = append(payouts, payout)
+	}
+	rows.Close()
+
+	processors := processorFormatNames()
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(processors)), ", ")
+	query := `
+		SELECT t.id, t.type
+		FROM transactions t
+		JOIN csv_files f ON f.id = t.source_file
+		WHERE t.type IN ('income', 'uncategorized', 'transfer')
+		  AND f.format NOT IN (` + placeholders + `)
+		  AND ABS(t.amount_cents) = ?
+		  AND t.date BETWEEN ? AND ?
+		  AND t.id NOT IN (SELECT deposit_id FROM payouts WHERE deposit_id <> '')
+		ORDER BY LOWER(t.description || ' ' || t.vendor) LIKE ? DESC, ABS(julianday(t.date) - julianday(?))
+		LIMIT 1
+	`
+
+	matched := 0
+	for _, payout := range payouts {
+		args := make([]interface{}, 0, len(processors)+5)
+		for _, name := range processors {
+			args = append(args, name)
+		}
+		args = append(args, payout.Amount.Abs(),
+			payout.Date.Add(-24*time.Hour), payout.Date.Add(payoutDepositWindow),
+			"%"+payout.Processor+"%", payout.Date)
+
+		var depositID, depositType string
+		err := db.QueryRow(query, args...).Scan(&depositID, &depositType)
+		if err == sql.ErrNoRows {
+			continue // Bank statement not uploaded yet, or the amounts differ
+		}
+		if err != nil {
+			return matched, fmt.Errorf("failed to find deposit for payout %s: %v", payout.ID, err)
+		}
+
+		if err := matchPayoutDeposit(payout.ID, depositID, depositType); err != nil {
+			return matched, err
+		}
+		matched++
+	}
+
+	if matched > 0 {
+		log.Printf("🏦 Reconciled %d payouts with bank deposits", matched)
+	}
+	return matched, nil
+}
+
+// matchPayoutDeposit links a payout to its deposit and turns the deposit into
+// a transfer, remembering its type so it can be restored
+func matchPayoutDeposit(payoutID, depositID, depositType string) error {
+	dbTx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer dbTx.Rollback()
+
+	if _, err := dbTx.Exec("UPDATE payouts SET deposit_id = ?, deposit_type = ? WHERE id = ?", depositID, depositType, payoutID); err != nil {
+		return fmt.Errorf("failed to link payout %s: %v", payoutID, err)
+	}
+	if _, err := dbTx.Exec("UPDATE transactions SET type = 'transfer' WHERE id = ?", depositID); err != nil {
+		return fmt.Errorf("failed to mark deposit %s: %v", depositID, err)
+	}
+	return dbTx.Commit()
+}
+
+// unlinkFilePayouts undoes reconciliation involving a file that's being
+// deleted: deposits matched to its payouts get their type back, and payouts
+// matched to its deposits become unmatched. Its payouts are then removed.
+func unlinkFilePayouts(dbTx *sql.Tx, fileID string) error {
+	_, err := dbTx.Exec(`
+		UPDATE transactions
+		SET type = (SELECT p.deposit_type FROM payouts p WHERE p.deposit_id = transactions.id)
+		WHERE id IN (SELECT deposit_id FROM payouts WHERE source_file = ? AND deposit_id <> '')
+	`, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to restore deposits: %v", err)
+	}
+
+	_, err = dbTx.Exec(`
+		UPDATE payouts SET deposit_id = '', deposit_type = ''
+		WHERE deposit_id IN (SELECT id FROM transactions WHERE source_file = ?)
+	`, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to unlink payouts: %v", err)
+	}
+
+	if _, err := dbTx.Exec("DELETE FROM payouts WHERE source_file = ?", fileID); err != nil {
+		return fmt.Errorf("failed to delete payouts: %v", err)
+	}
+	return nil
+}
+
+// getPayouts lists imported payouts with the bank deposit each was matched to
+func getPayouts(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`
+		SELECT p.id, p.processor, p.payout_id, p.date, p.amount_cents, COALESCE(p.source_file, ''), COALESCE(p.deposit_id, ''),
+		       t.date, COALESCE(t.card, ''), COALESCE(t.description, '')
+		FROM payouts p
+		LEFT JOIN transactions t ON t.id = p.deposit_id AND p.deposit_id <> ''
+		ORDER BY p.date DESC
+	`)
+	if err != nil {
+		log.Printf("Error querying payouts: %v", err)
+		http.Error(w, "Failed to fetch payouts", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	payouts := []Payout{}
+	unmatched := 0
+	for rows.Next() {
+		var payout Payout
+		var depositDate sql.NullTime
+		err := rows.Scan(&payout.ID, &payout.Processor, &payout.PayoutID, &payout.Date, &payout.Amount, &payout.SourceFile, &payout.DepositID,
+			&depositDate, &payout.DepositCard, &payout.DepositDescription)
+		if err != nil {
+			log.Printf("Error scanning payout: %v", err)
+			continue
+		}
+		if depositDate.Valid {
+			payout.DepositDate = &depositDate.Time
+		}
+		if payout.DepositID == "" {
+			unmatched++
+		}
+		payouts = append(payouts, payout)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"payouts":   payouts,
+		"count":     len(payouts),
+		"unmatched": unmatched,
+	})
+}
@@ -0,0 +1,703 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/ledongthuc/pdf"
+)
+
+// PDFTemplate describes where one bank's PDF statement keeps its
+// transactions. Text is extracted locally; rows are read either with a
+// regular expression per line or by the x-position of each column.
+type PDFTemplate struct {
+	ID             int         `json:"id" db:"id"`
+	Name           string      `json:"name" db:"name"`
+	Institution    string      `json:"institution" db:"institution"`         // Card name prefix, e.g. "Chase" gives "Chase ...1234"
+	MatchText      []string    `json:"match_text" db:"match_text"`           // Phrases that must all appear in the statement (case-insensitive)
+	StartPattern   string      `json:"start_pattern" db:"start_pattern"`     // Regex for the line above the transaction table; empty = from the first line
+	EndPattern     string      `json:"end_pattern" db:"end_pattern"`         // Regex for the line ending it; the next start line begins another table
+	PeriodPattern  string      `json:"period_pattern" db:"period_pattern"`   // Regex whose "closing" group is the closing date, for dates without a year
+	AccountPattern string      `json:"account_pattern" db:"account_pattern"` // Regex whose "account" group is the account number
+	LinePattern    string      `json:"line_pattern" db:"line_pattern"`       // Regex with date, description and amount (or debit/credit) groups
+	Columns        []PDFColumn `json:"columns,omitempty" db:"columns"`       // Or the columns' left edges, when there is no line_pattern
+	DateFormat     string      `json:"date_format" db:"date_format"`         // e.g. "MM/DD" or "MM/DD/YY"; empty = auto-detect
+	SignConvention string      `json:"sign_convention" db:"sign_convention"` // "expenses_positive" (card style) or "expenses_negative" (bank style)
+	CardName       string      `json:"card_name" db:"card_name"`             // Overrides the institution and account number
+	Builtin        bool        `json:"builtin"`
+	CreatedAt      string      `json:"created_at,omitempty" db:"created_at"`
+
+	start, end, period, account, line *regexp.Regexp
+}
+
+// PDFColumn is a table column starting at X points from the page's left
+// edge. Text is assigned to the rightmost column starting at or before it.
+type PDFColumn struct {
+	Field string  `json:"field"` // "date", "description", "amount", "debit", "credit" or "" to ignore
+	X     float64 `json:"x"`
+}
+
+// builtinPDFTemplates read Chase and American Express credit card
+// statements. A saved template with the same name replaces one.
+var builtinPDFTemplates = []PDFTemplate{
+	{
+		Name:           "chase",
+		Institution:    "Chase",
+		MatchText:      []string{"chase", "account activity"},
+		StartPattern:   `(?i)^account activity`,
+		EndPattern:     `(?i)^(totals year-to-date|interest charges)`,
+		PeriodPattern:  `(?i)opening/closing date\s*\d{1,2}/\d{1,2}/\d{2,4}\s*-\s*(?P<closing>\d{1,2}/\d{1,2}/\d{2,4})`,
+		AccountPattern: `(?i)account number:?\s*(?P<account>[\dX* ]*\d{4})`,
+		LinePattern:    `^(?P<date>\d{2}/\d{2})\s+(?P<description>.+?)\s+(?P<amount>-?[\d,]*\.\d{2})$`,
+		DateFormat:     "MM/DD",
+		SignConvention: "expenses_positive",
+		Builtin:        true,
+	},
+	{
+		Name:           "amex",
+		Institution:    "Amex",
+		MatchText:      []string{"american express", "closing date"},
+		StartPattern:   `(?i)^(payments and credits|new charges|detail)\b`,
+		EndPattern:     `(?i)^(fees|interest charged|total )`,
+		PeriodPattern:  `(?i)closing date\s*(?P<closing>\d{1,2}/\d{1,2}/\d{2,4})`,
+		AccountPattern: `(?i)account ending\s*(?P<account>[\d-]*\d{4,5})`,
+		Columns: []PDFColumn{
+			{Field: "date", X: 36},
+			{Field: "description", X: 100},
+			{Field: "amount", X: 470},
+		},
+		DateFormat:     "MM/DD/YY",
+		SignConvention: "expenses_positive",
+		Builtin:        true,
+	},
+}
+
+// pdfDatePattern recognizes a line that starts like a transaction, so lines
+// that don't fit the template can be reported instead of skipped as headings
+var pdfDatePattern = regexp.MustCompile(`^\d{1,2}[/.-]\d{1,2}`)
+
+func isPDFFilename(filename string) bool {
+	return strings.HasSuffix(strings.ToLower(filename), ".pdf")
+}
+
+func createPDFTemplatesTable() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS pdf_templates (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT UNIQUE NOT NULL,
+			institution TEXT DEFAULT '',
+			match_text TEXT DEFAULT '',
+			start_pattern TEXT DEFAULT '',
+			end_pattern TEXT DEFAULT '',
+			period_pattern TEXT DEFAULT '',
+			account_pattern TEXT DEFAULT '',
+			line_pattern TEXT DEFAULT '',
+			columns TEXT DEFAULT '',
+			date_format TEXT DEFAULT '',
+			sign_convention TEXT DEFAULT 'expenses_positive',
+			card_name TEXT DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`)
+	if err != nil {
+		return fmt.Errorf("error creating pdf_templates table: %v", err)
+	}
+	return nil
+}
+
+// compile checks the template and compiles its patterns
+func (t *PDFTemplate) compile() error {
+	patterns := []struct {
+		name   string
+		value  string
+		target **regexp.Regexp
+	}{
+		{"start_pattern", t.StartPattern, &t.start},
+		{"end_pattern", t.EndPattern, &t.end},
+		{"period_pattern", t.PeriodPattern, &t.period},
+		{"account_pattern", t.AccountPattern, &t.account},
+		{"line_pattern", t.LinePattern, &t.line},
+	}
+	for _, pattern := range patterns {
+		*pattern.target = nil
+		if pattern.value == "" {
+			continue
+		}
+		compiled, err := regexp.Compile(pattern.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", pattern.name, err)
+		}
+		*pattern.target = compiled
+	}
+
+	if t.period != nil && t.period.SubexpIndex("closing") == -1 {
+		return fmt.Errorf("period_pattern needs a (?P<closing>...) group")
+	}
+	if t.account != nil && t.account.SubexpIndex("account") == -1 {
+		return fmt.Errorf("account_pattern needs a (?P<account>...) group")
+	}
+
+	var fields []string
+	switch {
+	case t.line != nil && len(t.Columns) > 0:
+		return fmt.Errorf("use line_pattern or columns, not both")
+	case t.line != nil:
+		fields = t.line.SubexpNames()
+	case len(t.Columns) > 0:
+		for _, column := range t.Columns {
+			switch column.Field {
+			case "", "date", "description", "amount", "debit", "credit":
+			default:
+				return fmt.Errorf("unknown column field %q", column.Field)
+			}
+			fields = append(fields, column.Field)
+		}
+	default:
+		return fmt.Errorf("line_pattern or columns is required")
+	}
+
+	has := make(map[string]bool)
+	for _, field := range fields {
+		has[field] = true
+	}
+	if !has["date"] || !has["description"] {
+		return fmt.Errorf("the template needs date and description fields")
+	}
+	if !has["amount"] && !has["debit"] && !has["credit"] {
+		return fmt.Errorf("the template needs an amount field or debit/credit fields")
+	}
+	return nil
+}
+
+func validatePDFTemplate(template *PDFTemplate) error {
+	template.Name = strings.TrimSpace(template.Name)
+	if template.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(template.MatchText) == 0 {
+		return fmt.Errorf("match_text is required so the template can recognize its statements")
+	}
+	if template.SignConvention == "" {
+		template.SignConvention = "expenses_positive"
+	}
+	if template.SignConvention != "expenses_positive" && template.SignConvention != "expenses_negative" {
+		return fmt.Errorf("sign_convention must be expenses_positive or expenses_negative")
+	}
+	sort.Slice(template.Columns, func(i, j int) bool { return template.Columns[i].X < template.Columns[j].X })
+	template.Builtin = false
+	return template.compile()
+}
+
+// loadPDFTemplates returns the saved templates followed by the built-in ones
+// no saved template replaces, all compiled
+func loadPDFTemplates() ([]PDFTemplate, error) {
+	rows, err := db.Query(`
+		SELECT id, name, institution, match_text, start_pattern, end_pattern, period_pattern, account_pattern,
+		       line_pattern, columns, date_format, sign_convention, card_name, created_at
+		FROM pdf_templates
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying PDF templates: %v", err)
+	}
+	defer rows.Close()
+
+	var templates []PDFTemplate
+	saved := make(map[string]bool)
+	for rows.Next() {
+		var template PDFTemplate
+		var matchText, columns string
+		err := rows.Scan(&template.ID, &template.Name, &template.Institution, &matchText, &template.StartPattern,
+			&template.EndPattern, &template.PeriodPattern, &template.AccountPattern, &template.LinePattern, &columns,
+			&template.DateFormat, &template.SignConvention, &template.CardName, &template.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning PDF template: %v", err)
+		}
+		if matchText != "" {
+			json.Unmarshal([]byte(matchText), &template.MatchText)
+		}
+		if columns != "" {
+			json.Unmarshal([]byte(columns), &template.Columns)
+		}
+		if err := template.compile(); err != nil {
+			log.Printf("⚠️ Skipping PDF template %s: %v", template.Name, err)
+			continue
+		}
+		templates = append(templates, template)
+		saved[strings.ToLower(template.Name)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, template := range builtinPDFTemplates {
+		if saved[template.Name] {
+			continue
+		}
+		if err := template.compile(); err != nil {
+			return nil, fmt.Errorf("built-in PDF template %s: %v", template.Name, err)
+		}
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+// pdfCell is a run of text drawn at one position
+type pdfCell struct {
+	X    float64
+	Text string
+}
+
+// pdfLine is one row of text on a page, its cells left to right
+type pdfLine struct {
+	Page  int
+	Y     float64
+	Cells []pdfCell
+}
+
+func (l pdfLine) text() string {
+	parts := make([]string, len(l.Cells))
+	for i, cell := range l.Cells {
+		parts[i] = cell.Text
+	}
+	return strings.Join(strings.Fields(strings.Join(parts, " ")), " ")
+}
+
+// extractPDFLines reads the text of every page, top to bottom. Glyphs are
+// joined into cells while each continues where the last ended (or, in
+// files without glyph widths, at the same point), and cells whose baselines
+// are within two points form a line.
+func extractPDFLines(filePath string) (lines []pdfLine, err error) {
+	file, reader, err := pdf.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PDF: %v", err)
+	}
+	defer file.Close()
+
+	// The reader panics on malformed content streams
+	defer func() {
+		if r := recover(); r != nil {
+			lines, err = nil, fmt.Errorf("failed to read PDF: %v", r)
+		}
+	}()
+
+	const sameLine = 2.0
+	for pageNum := 1; pageNum <= reader.NumPage(); pageNum++ {
+		page := reader.Page(pageNum)
+		if page.V.IsNull() {
+			continue
+		}
+
+		var cells []pdfLine // One cell each, before grouping into lines
+		var current *pdfLine
+		var nextX, lastY float64
+		for _, glyph := range page.Content().Text {
+			continues := current != nil && math.Abs(glyph.Y-lastY) < 0.5 &&
+				(math.Abs(glyph.X-nextX) < 0.5 || (glyph.W == 0 && glyph.X == current.Cells[0].X))
+			if !continues {
+				cells = append(cells, pdfLine{Page: pageNum, Y: glyph.Y, Cells: []pdfCell{{X: glyph.X}}})
+				current = &cells[len(cells)-1]
+			}
+			current.Cells[0].Text += glyph.S
+			nextX, lastY = glyph.X+glyph.W, glyph.Y
+		}
+
+		sort.SliceStable(cells, func(i, j int) bool { return cells[i].Y > cells[j].Y })
+		var pageLines []pdfLine
+		for _, cell := range cells {
+			if strings.TrimSpace(cell.Cells[0].Text) == "" {
+				continue
+			}
+			if n := len(pageLines); n > 0 && pageLines[n-1].Y-cell.Y <= sameLine {
+				pageLines[n-1].Cells = append(pageLines[n-1].Cells, cell.Cells[0])
+				continue
+			}
+			pageLines = append(pageLines, cell)
+		}
+		for i := range pageLines {
+			sort.SliceStable(pageLines[i].Cells, func(a, b int) bool { return pageLines[i].Cells[a].X < pageLines[i].Cells[b].X })
+		}
+		lines = append(lines, pageLines...)
+	}
+	return lines, nil
+}
+
+// selectPDFTemplate returns the named template, or the first whose match
+// text all appears in the statement
+func selectPDFTemplate(templates []PDFTemplate, name, text string) (*PDFTemplate, error) {
+	lower := strings.ToLower(text)
+	for i := range templates {
+		template := &templates[i]
+		if name != "" {
+			if strings.EqualFold(template.Name, name) {
+				return template, nil
+			}
+			continue
+		}
+
+		matched := true
+		for _, phrase := range template.MatchText {
+			if !strings.Contains(lower, strings.ToLower(phrase)) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return template, nil
+		}
+	}
+
+	if name != "" {
+		return nil, fmt.Errorf("PDF template %q not found", name)
+	}
+	return nil, fmt.Errorf("no PDF template recognizes this statement; save one with POST /pdf-template or pass pdf_template")
+}
+
+// fields splits a line into the template's fields by pattern or by column
+func (t *PDFTemplate) fields(line pdfLine) (map[string]string, bool) {
+	fields := make(map[string]string)
+	if t.line != nil {
+		match := t.line.FindStringSubmatch(line.text())
+		if match == nil {
+			return nil, false
+		}
+		for i, name := range t.line.SubexpNames() {
+			if name != "" {
+				fields[name] = strings.TrimSpace(match[i])
+			}
+		}
+		return fields, true
+	}
+
+	for _, cell := range line.Cells {
+		column := -1
+		for i, c := range t.Columns {
+			if c.X <= cell.X+1 {
+				column = i
+			}
+		}
+		if column == -1 || t.Columns[column].Field == "" {
+			continue
+		}
+		field := t.Columns[column].Field
+		fields[field] = strings.TrimSpace(fields[field] + " " + strings.TrimSpace(cell.Text))
+	}
+	return fields, fields["date"] != ""
+}
+
+// parseDate reads a transaction date. Dates without a year take the closing
+// date's year, or the year before when that would put them after closing.
+func (t *PDFTemplate) parseDate(value string, closing time.Time) (time.Time, error) {
+	value = strings.TrimRight(value, "* ")
+	if t.DateFormat == "" {
+		return parseDate(value)
+	}
+
+	layout := profileDateLayout(t.DateFormat)
+	date, err := time.Parse(layout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to parse date: %s", value)
+	}
+	if strings.Contains(layout, "06") {
+		return date, nil
+	}
+
+	if closing.IsZero() {
+		return time.Time{}, fmt.Errorf("date %s has no year and the statement's closing date wasn't found", value)
+	}
+	date = time.Date(closing.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	if date.After(closing) {
+		date = date.AddDate(-1, 0, 0)
+	}
+	return date, nil
+}
+
+func (t *PDFTemplate) parseTransaction(fields map[string]string, closing time.Time, transaction Transaction) (*Transaction, error) {
+	date, err := t.parseDate(fields["date"], closing)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date: %v", err)
+	}
+	transaction.Date = date
+
+	description := fields["description"]
+	if description == "" {
+		return nil, fmt.Errorf("missing description")
+	}
+	transaction.Description = description
+	transaction.Vendor = extractVendorName(description)
+
+	var amount Cents
+	if value, ok := fields["amount"]; ok {
+		if amount, err = parseMoney(value, ""); err != nil {
+			return nil, fmt.Errorf("invalid amount: %v", err)
+		}
+		if t.SignConvention == "expenses_negative" {
+			amount = accountAmount(amount, transaction)
+		}
+	} else {
+		debit, err := parseAmountField(fields["debit"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid debit: %v", err)
+		}
+		credit, err := parseAmountField(fields["credit"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid credit: %v", err)
+		}
+		amount = accountAmount(credit.Abs()-debit.Abs(), transaction)
+	}
+	transaction.Amount = amount
+	transaction.bankAccount = t.SignConvention == "expenses_negative"
+
+	finishTransaction(&transaction)
+	markRefund(&transaction)
+	return &transaction, nil
+}
+
+func parsePDFFile(filePath, fileID, source, originalFilename string, options importOptions) (*ParsedCSVData, error) {
+	lines, err := extractPDFLines(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("PDF has no text; scanned statements aren't supported")
+	}
+
+	templates, err := loadPDFTemplates()
+	if err != nil {
+		return nil, err
+	}
+	exclusionRules, err := loadExclusionRules()
+	if err != nil {
+		return nil, err
+	}
+	vendorAliases, err := loadVendorAliases()
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePDFLines(lines, templates, exclusionRules, vendorAliases, fileID, source, originalFilename, options.PDFTemplate)
+}
+
+// parsePDFLines reads the transactions from a statement's extracted lines
+// with the named template, or the first one that recognizes it. Lines in
+// the transaction table that look like transactions but don't parse are
+// reported as rejected rows.
+func parsePDFLines(lines []pdfLine, templates []PDFTemplate, exclusionRules []ExclusionRule, vendorAliases []VendorAlias,
+	fileID, source, originalFilename, templateName string) (*ParsedCSVData, error) {
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = line.text()
+	}
+
+	template, err := selectPDFTemplate(templates, templateName, strings.Join(texts, "\n"))
+	if err != nil {
+		return nil, err
+	}
+
+	// The closing date and account number can be anywhere in the statement
+	var closing time.Time
+	var accountID string
+	for _, text := range texts {
+		if template.period != nil && closing.IsZero() {
+			if match := template.period.FindStringSubmatch(text); match != nil {
+				closing, _ = parseDate(match[template.period.SubexpIndex("closing")])
+			}
+		}
+		if template.account != nil && accountID == "" {
+			if match := template.account.FindStringSubmatch(text); match != nil {
+				accountID = strings.NewReplacer(" ", "", "-", "").Replace(match[template.account.SubexpIndex("account")])
+			}
+		}
+	}
+
+	card := template.CardName
+	if card == "" {
+		card = ofxCardName(template.Institution, accountID, originalFilename)
+	}
+
+	var transactions []Transaction
+	var excludedPayments, rejectedRows []RowIssue
+	inTable := template.start == nil
+
+	for i, line := range lines {
+		text := texts[i]
+		lineNum := i + 1
+		if !inTable {
+			inTable = template.start.MatchString(text)
+			continue
+		}
+		if template.end != nil && template.end.MatchString(text) {
+			inTable = false
+			continue
+		}
+
+		fields, ok := template.fields(line)
+		if !ok {
+			// Headings and totals; a line that starts with a date should have fit
+			if pdfDatePattern.MatchString(text) {
+				rejectedRows = append(rejectedRows, RowIssue{Line: lineNum, Reason: fmt.Sprintf("page %d: line doesn't match the %s template", line.Page, template.Name), Values: []string{text}})
+			}
+			continue
+		}
+
+		var transaction Transaction
+		transaction.ID = uuid.New().String()
+		transaction.SourceFile = fileID
+		transaction.Card = card
+		switch source {
+		case "income":
+			transaction.Type = "income"
+		case "expenses":
+			transaction.Type = "expense"
+		default:
+			transaction.Type = "uncategorized"
+		}
+
+		parsed, err := template.parseTransaction(fields, closing, transaction)
+		if err != nil {
+			if !pdfDatePattern.MatchString(fields["date"]) {
+				continue // A column heading such as "Date"
+			}
+			log.Printf("Error parsing PDF line %d: %v", lineNum, err)
+			rejectedRows = append(rejectedRows, RowIssue{Line: lineNum, Reason: fmt.Sprintf("page %d: %v", line.Page, err), Values: []string{text}})
+			continue
+		}
+
+		parsed.line = lineNum
+		applyVendorAliases(vendorAliases, parsed)
+		if reason := excludeTransfer(exclusionRules, parsed, false); reason != "" {
+			excludedPayments = append(excludedPayments, RowIssue{Line: lineNum, Reason: reason, Values: []string{text}})
+		}
+		transactions = append(transactions, *parsed)
+	}
+
+	if len(transactions) == 0 && len(rejectedRows) == 0 {
+		return nil, fmt.Errorf("no transactions found with the %s template", template.Name)
+	}
+
+	assignFingerprints(transactions)
+
+	return &ParsedCSVData{
+		Transactions:     transactions,
+		PaymentsExcluded: len(excludedPayments),
+		ParsedCount:      len(transactions) - len(excludedPayments),
+		Format:           "pdf:" + template.Name,
+		ExcludedPayments: excludedPayments,
+		RejectedRows:     rejectedRows,
+	}, nil
+}
+
+func createPDFTemplate(w http.ResponseWriter, r *http.Request) {
+	var template PDFTemplate
+	if err := json.NewDecoder(r.Body).Decode(&template); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := validatePDFTemplate(&template); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	matchText, _ := json.Marshal(template.MatchText)
+	columns := ""
+	if len(template.Columns) > 0 {
+		data, _ := json.Marshal(template.Columns)
+		columns = string(data)
+	}
+
+	query := `
+		INSERT INTO pdf_templates (name, institution, match_text, start_pattern, end_pattern, period_pattern, account_pattern,
+		                           line_pattern, columns, date_format, sign_convention, card_name)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			institution = excluded.institution,
+			match_text = excluded.match_text,
+			start_pattern = excluded.start_pattern,
+			end_pattern = excluded.end_pattern,
+			period_pattern = excluded.period_pattern,
+			account_pattern = excluded.account_pattern,
+			line_pattern = excluded.line_pattern,
+			columns = excluded.columns,
+			date_format = excluded.date_format,
+			sign_convention = excluded.sign_convention,
+			card_name = excluded.card_name
+	`
+
+	_, err := db.Exec(query, template.Name, template.Institution, string(matchText), template.StartPattern, template.EndPattern,
+		template.PeriodPattern, template.AccountPattern, template.LinePattern, columns, template.DateFormat,
+		template.SignConvention, template.CardName)
+	if err != nil {
+		log.Printf("Failed to save PDF template: %v", err)
+		http.Error(w, "Failed to save PDF template", http.StatusInternalServerError)
+		return
+	}
+
+	// LastInsertId isn't reliable for the upsert path, so look the row up by name
+	err = db.QueryRow("SELECT id, created_at FROM pdf_templates WHERE name = ?", template.Name).Scan(&template.ID, &template.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to load PDF template: %v", err)
+		http.Error(w, "Failed to save PDF template", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("📄 Saved PDF template: %s (ID: %d)", template.Name, template.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"message":  "PDF template saved successfully",
+		"template": template,
+	})
+}
+
+func getPDFTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := loadPDFTemplates()
+	if err != nil {
+		log.Printf("Error loading PDF templates: %v", err)
+		http.Error(w, "Failed to fetch PDF templates", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"templates": templates,
+		"count":     len(templates),
+	})
+}
+
+func deletePDFTemplate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid template ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM pdf_templates WHERE id = ?", id)
+	if err != nil {
+		log.Printf("Failed to delete PDF template: %v", err)
+		http.Error(w, "Failed to delete PDF template", http.StatusInternalServerError)
+		return
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		http.Error(w, "PDF template not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "PDF template deleted successfully",
+	})
+}
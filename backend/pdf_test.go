@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// compiledBuiltinTemplates returns the built-in templates as loadPDFTemplates
+// does when none has been saved
+func compiledBuiltinTemplates(t *testing.T) []PDFTemplate {
+	t.Helper()
+	templates := make([]PDFTemplate, len(builtinPDFTemplates))
+	for i, template := range builtinPDFTemplates {
+		if err := template.compile(); err != nil {
+			t.Fatalf("built-in template %s: %v", template.Name, err)
+		}
+		templates[i] = template
+	}
+	return templates
+}
+
+// pdfText is a run of text drawn at x points from the left edge
+type pdfText struct {
+	X    float64
+	Text string
+}
+
+// statementLines lays out a generated statement, one line per row of cells,
+// as extractPDFLines returns it
+func statementLines(rows ...[]pdfText) []pdfLine {
+	lines := make([]pdfLine, len(rows))
+	for i, row := range rows {
+		line := pdfLine{Page: 1, Y: 750 - float64(i)*12}
+		for _, text := range row {
+			line.Cells = append(line.Cells, pdfCell{X: text.X, Text: text.Text})
+		}
+		lines[i] = line
+	}
+	return lines
+}
+
+// textRow is a line of a statement read by a line pattern
+func textRow(text string) []pdfText {
+	return []pdfText{{X: 36, Text: text}}
+}
+
+// chaseStatement is a generated Chase statement. The smudged line starts
+// with a date but has no amount, and the line after the totals is outside
+// the transaction table.
+var chaseStatement = []string{
+	"CHASE SAPPHIRE PREFERRED",
+	"Opening/Closing Date 12/04/23 - 01/03/24",
+	"Account Number: XXXX XXXX XXXX 1234",
+	"ACCOUNT ACTIVITY",
+	"Date of Transaction Merchant Name or Transaction Description $ Amount",
+	"PAYMENTS AND OTHER CREDITS",
+	"12/15 Payment Thank You - Web -500.00",
+	"12/20 AMAZON MKTPLACE RETURN -23.99",
+	"PURCHASE",
+	"12/08 GITHUB INC SAN FRANCISCO CA 4.00",
+	"01/02 STAPLES 00123 1,054.99",
+	"12/22 SMUDGED LINE ??.??",
+	"Totals Year-to-Date",
+	"12/30 NOT IN THE TABLE 1.00",
+}
+
+type pdfWant struct {
+	date   string
+	vendor string
+	amount Cents
+	txType string
+}
+
+func checkPDFTransactions(t *testing.T, parsed *ParsedCSVData, want []pdfWant) {
+	t.Helper()
+	if len(parsed.Transactions) != len(want) {
+		t.Fatalf("parsed %d transactions, want %d: %+v", len(parsed.Transactions), len(want), parsed.Transactions)
+	}
+	for i, w := range want {
+		got := parsed.Transactions[i]
+		if date := got.Date.Format("2006-01-02"); date != w.date || got.Vendor != w.vendor || got.Amount != w.amount || got.Type != w.txType {
+			t.Errorf("transaction %d = %s %q %d %s, want %s %q %d %s", i+1,
+				date, got.Vendor, got.Amount, got.Type, w.date, w.vendor, w.amount, w.txType)
+		}
+	}
+}
+
+func TestChasePDFTemplate(t *testing.T) {
+	var rows [][]pdfText
+	for _, text := range chaseStatement {
+		rows = append(rows, textRow(text))
+	}
+
+	parsed, err := parsePDFLines(statementLines(rows...), compiledBuiltinTemplates(t), defaultExclusionRules(), nil,
+		"file", "expenses", "statement.pdf", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Format != "pdf:chase" {
+		t.Errorf("format = %s, want pdf:chase", parsed.Format)
+	}
+	checkPDFTransactions(t, parsed, []pdfWant{
+		{"2023-12-15", "Payment Thank You - Web", -50000, "transfer"},
+		{"2023-12-20", "AMAZON MKTPLACE RETURN", -2399, "refund"},
+		{"2023-12-08", "GITHUB INC", 400, "expense"},
+		{"2024-01-02", "STAPLES", 105499, "expense"}, // After the closing month, so the closing year
+	})
+	for _, tx := range parsed.Transactions {
+		if tx.Card != "Chase ...1234" {
+			t.Errorf("card = %q, want %q", tx.Card, "Chase ...1234")
+		}
+	}
+
+	if parsed.ParsedCount != 3 || parsed.PaymentsExcluded != 1 {
+		t.Errorf("parsed %d and excluded %d, want 3 and 1", parsed.ParsedCount, parsed.PaymentsExcluded)
+	}
+
+	// The unparsed-line report
+	if len(parsed.RejectedRows) != 1 {
+		t.Fatalf("rejected %d lines, want 1: %+v", len(parsed.RejectedRows), parsed.RejectedRows)
+	}
+	rejected := parsed.RejectedRows[0]
+	if rejected.Line != 12 || rejected.Values[0] != "12/22 SMUDGED LINE ??.??" || !strings.Contains(rejected.Reason, "chase template") {
+		t.Errorf("rejected line = %+v, want line 12 reported against the chase template", rejected)
+	}
+}
+
+func TestAmexPDFTemplate(t *testing.T) {
+	lines := statementLines(
+		textRow("American Express Business Gold Card"),
+		[]pdfText{{X: 36, Text: "Closing Date 01/15/24"}, {X: 300, Text: "Account Ending 1-23456"}},
+		textRow("Payments and Credits"),
+		[]pdfText{{X: 36, Text: "01/05/24*"}, {X: 100, Text: "ONLINE PAYMENT - THANK YOU"}, {X: 470, Text: "-$250.00"}},
+		[]pdfText{{X: 36, Text: "Total Payments and Credits"}, {X: 470, Text: "-$250.00"}},
+		textRow("New Charges"),
+		[]pdfText{{X: 36, Text: "Date"}, {X: 100, Text: "Description"}, {X: 470, Text: "Amount"}},
+		[]pdfText{{X: 36, Text: "01/10/24"}, {X: 100, Text: "UBER"}, {X: 130, Text: "TRIP"}, {X: 470, Text: "$24.50"}},
+		[]pdfText{{X: 36, Text: "01/11/24"}, {X: 100, Text: "DELTA AIR LINES"}, {X: 470, Text: "$1,200.00"}},
+		[]pdfText{{X: 36, Text: "01/12/24"}, {X: 100, Text: "TORN RECEIPT"}, {X: 470, Text: "n/a"}},
+		textRow("Fees"),
+		[]pdfText{{X: 36, Text: "01/13/24"}, {X: 100, Text: "LATE FEE"}, {X: 470, Text: "$40.00"}},
+	)
+
+	parsed, err := parsePDFLines(lines, compiledBuiltinTemplates(t), defaultExclusionRules(), nil,
+		"file", "expenses", "statement.pdf", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Format != "pdf:amex" {
+		t.Errorf("format = %s, want pdf:amex", parsed.Format)
+	}
+	checkPDFTransactions(t, parsed, []pdfWant{
+		{"2024-01-05", "ONLINE PAYMENT - THANK YOU", -25000, "transfer"},
+		{"2024-01-10", "UBER TRIP", 2450, "expense"},
+		{"2024-01-11", "DELTA AIR LINES", 120000, "expense"},
+	})
+	if card := parsed.Transactions[0].Card; card != "Amex ...3456" {
+		t.Errorf("card = %q, want %q", card, "Amex ...3456")
+	}
+
+	if len(parsed.RejectedRows) != 1 || parsed.RejectedRows[0].Line != 10 || !strings.Contains(parsed.RejectedRows[0].Reason, "invalid amount") {
+		t.Errorf("rejected = %+v, want line 10 with an invalid amount", parsed.RejectedRows)
+	}
+}
+
+func TestPDFTemplateSelection(t *testing.T) {
+	templates := compiledBuiltinTemplates(t)
+	lines := statementLines(textRow("Valley Credit Union"), textRow("03/01 DEPOSIT 100.00"))
+
+	if _, err := parsePDFLines(lines, templates, nil, nil, "file", "expenses", "cu.pdf", ""); err == nil {
+		t.Error("statement no template recognizes was parsed, want an error")
+	}
+	if _, err := parsePDFLines(lines, templates, nil, nil, "file", "expenses", "cu.pdf", "wells"); err == nil {
+		t.Error("unknown template name was accepted, want an error")
+	}
+
+	// Naming a template skips detection; this one has no rows it can read
+	_, err := parsePDFLines(lines, templates, nil, nil, "file", "expenses", "cu.pdf", "chase")
+	if err == nil || !strings.Contains(err.Error(), "no transactions") {
+		t.Errorf("err = %v, want no transactions found", err)
+	}
+}
+
+// writeTextPDF writes a one-page PDF that draws each line of text with the
+// standard Helvetica font, which has no embedded glyph widths
+func writeTextPDF(t *testing.T, path string, lines []string) {
+	t.Helper()
+
+	var content bytes.Buffer
+	for i, line := range lines {
+		escaped := strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`).Replace(line)
+		fmt.Fprintf(&content, "BT /F1 9 Tf 1 0 0 1 36 %d Tm (%s) Tj ET\n", 750-i*12, escaped)
+	}
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+	}
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	if err := os.WriteFile(path, pdf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The Chase statement drawn into a real PDF reads the same as its lines
+func TestExtractPDFLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chase.pdf")
+	writeTextPDF(t, path, chaseStatement)
+
+	lines, err := extractPDFLines(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != len(chaseStatement) {
+		t.Fatalf("extracted %d lines, want %d", len(lines), len(chaseStatement))
+	}
+	for i, line := range lines {
+		if line.text() != chaseStatement[i] {
+			t.Errorf("line %d = %q, want %q", i+1, line.text(), chaseStatement[i])
+		}
+	}
+
+	parsed, err := parsePDFLines(lines, compiledBuiltinTemplates(t), defaultExclusionRules(), nil,
+		"file", "expenses", "chase.pdf", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Transactions) != 4 || len(parsed.RejectedRows) != 1 {
+		t.Errorf("parsed %d transactions and rejected %d lines, want 4 and 1", len(parsed.Transactions), len(parsed.RejectedRows))
+	}
+}
+
+func TestExtractPDFLinesInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "junk.pdf")
+	if err := os.WriteFile(path, []byte("not a pdf"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := extractPDFLines(path); err == nil {
+		t.Error("extracted lines from a file that isn't a PDF, want an error")
+	}
+}
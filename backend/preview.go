@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RowIssue describes a source row that didn't become a transaction
+type RowIssue struct {
+	Line   int      `json:"line"` // 1-based line in the file (STMTTRN number for OFX, text line for PDF)
+	Reason string   `json:"reason"`
+	Values []string `json:"values"`
+}
+
+// UploadError is the body returned when an upload or commit fails. Nothing
+// from the file was saved and its stored copy has been removed.
+type UploadError struct {
+	Success    bool       `json:"success"` // Always false
+	Error      string     `json:"error"`
+	FailedRows []RowIssue `json:"failed_rows,omitempty"` // Rows the database refused, when that was the cause
+}
+
+// rowsError lists the rows of an upload that couldn't be inserted
+type rowsError struct {
+	Rows []RowIssue
+}
+
+func (e *rowsError) Error() string {
+	return fmt.Sprintf("%d rows could not be saved", len(e.Rows))
+}
+
+// writeUploadError sends an UploadError. When err is a *rowsError the
+// failing rows are listed and the status becomes 422.
+func writeUploadError(w http.ResponseWriter, status int, message string, err error) {
+	response := UploadError{Error: message}
+
+	var failed *rowsError
+	if errors.As(err, &failed) {
+		status = http.StatusUnprocessableEntity
+		response.Error = fmt.Sprintf("%s: %v", message, failed)
+		response.FailedRows = failed.Rows
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// pendingUpload is an upload that has been saved to uploads/ and parsed but
+// not yet written to the database
+type pendingUpload struct {
+	FileID    string
+	Filename  string
+	TempPath  string
+	Source    string
+	Profile   *MappingProfile
+	Data      *ParsedCSVData
+	CreatedAt time.Time
+}
+
+// previewTTL is how long a dry-run upload can be committed before its
+// token expires and the stored file is removed
+const previewTTL = 30 * time.Minute
+
+var (
+	pendingUploadsMu sync.Mutex
+	pendingUploads   = make(map[string]*pendingUpload)
+)
+
+// storePendingUpload keeps a dry-run upload for a later commit and returns its token
+func storePendingUpload(pending *pendingUpload) string {
+	pendingUploadsMu.Lock()
+	defer pendingUploadsMu.Unlock()
+
+	expirePendingUploadsLocked()
+
+	token := uuid.New().String()
+	pendingUploads[token] = pending
+	return token
+}
+
+// takePendingUpload removes and returns the upload for token, or nil if the
+// token is unknown or expired
+func takePendingUpload(token string) *pendingUpload {
+	pendingUploadsMu.Lock()
+	defer pendingUploadsMu.Unlock()
+
+	expirePendingUploadsLocked()
+
+	pending, ok := pendingUploads[token]
+	if !ok {
+		return nil
+	}
+	delete(pendingUploads, token)
+	return pending
+}
+
+func expirePendingUploadsLocked() {
+	for token, pending := range pendingUploads {
+		if time.Since(pending.CreatedAt) > previewTTL {
+			removeUpload(pending.TempPath)
+			delete(pendingUploads, token)
+		}
+	}
+}
+
+// persistUpload writes a parsed upload to the database in one transaction
+// covering its rows and file record, starts auto-categorization and builds
+// the upload response. On error nothing is saved. Progress is published as
+// import.* events.
+func persistUpload(pending *pendingUpload) (response *UploadResponse, err error) {
+	parsedData := pending.Data
+
+	event := ImportEvent{
+		FileID:             pending.FileID,
+		Filename:           pending.Filename,
+		Source:             pending.Source,
+		Format:             parsedData.Format,
+		TransactionsParsed: parsedData.ParsedCount,
+	}
+	publishEvent(EventImportStarted, event)
+	defer func() {
+		if err != nil {
+			event.Error = err.Error()
+			publishEvent(EventImportFailed, event)
+		}
+	}()
+
+	// Skip rows imported before and flag rows that resemble existing
+	// transactions so the user can review them. Most exact duplicates would
+	// also be ignored by the fingerprint index, but not rows keyed by a bank
+	// ID whose earlier import had no ID.
+	exact, near, err := checkDuplicates(parsedData.Transactions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check duplicates: %v", err)
+	}
+	duplicateOf := make(map[string]string)
+	for _, match := range near {
+		duplicateOf[match.TransactionID] = match.ExistingID
+	}
+	var transactions []Transaction
+	for _, tx := range parsedData.Transactions {
+		if exact[tx.ID] {
+			continue
+		}
+		tx.DuplicateOf = duplicateOf[tx.ID]
+		transactions = append(transactions, tx)
+	}
+
+	dbTx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer dbTx.Rollback()
+
+	// Save transactions to database
+	saved, err := saveTransactions(dbTx, transactions)
+	if err != nil {
+		return nil, err
+	}
+
+	duplicatesSkipped := len(parsedData.Transactions) - saved
+
+	// Driver mileage goes to the vehicle deduction, except miles imported before
+	businessMiles, milesSkipped := newBusinessMiles(parsedData, exact, near)
+	if businessMiles > 0 {
+		if err := addBusinessMiles(dbTx, businessMiles); err != nil {
+			return nil, err
+		}
+	}
+
+	// Save file record to database
+	if err := saveCSVFileRecord(dbTx, pending, duplicatesSkipped, businessMiles); err != nil {
+		return nil, err
+	}
+
+	if err := savePayouts(dbTx, parsedData.Payouts); err != nil {
+		return nil, err
+	}
+
+	// Remember which profile read this layout
+	if pending.Profile != nil {
+		if err := rememberProfileSignature(dbTx, pending.Profile.ID, parsedData.HeaderSignature); err != nil {
+			return nil, fmt.Errorf("failed to save mapping profile signature: %v", err)
+		}
+	}
+
+	if err := dbTx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit upload: %v", err)
+	}
+
+	// Match new refunds (and older ones whose purchase just arrived) to purchases
+	if _, err := linkRefunds(); err != nil {
+		log.Printf("Error linking refunds: %v", err)
+	}
+
+	// Match payouts to bank deposits, from either side of the pair
+	reconciled, err := reconcilePayouts()
+	if err != nil {
+		log.Printf("Error reconciling payouts: %v", err)
+	}
+
+	// Log successful upload
+	log.Printf("📤 File processed: %s (ID: %s, Source: %s, Transactions: %d, Payments excluded: %d, Rows rejected: %d, Duplicates skipped: %d, Near duplicates: %d)",
+		pending.Filename, pending.FileID, pending.Source, parsedData.ParsedCount, parsedData.PaymentsExcluded,
+		len(parsedData.RejectedRows), duplicatesSkipped, len(near))
+
+	// Trigger auto-categorization for newly uploaded transactions
+	job := startCategorizeJob("upload")
+
+	event.DuplicatesSkipped = duplicatesSkipped
+	event.NearDuplicates = len(near)
+	event.CategorizeJobID = job.ID
+	publishEvent(EventImportFinished, event)
+
+	response = newUploadResponse(pending)
+	response.CategorizeJobID = job.ID
+	response.Message = "File uploaded and processed successfully"
+	response.DuplicatesSkipped = duplicatesSkipped
+	response.NearDuplicates = near
+	response.PayoutsReconciled = reconciled
+	response.BusinessMiles = businessMiles
+	response.BusinessMilesSkipped = milesSkipped
+	return response, nil
+}
+
+// newUploadResponse fills in the fields shared by dry-run and committed uploads
+func newUploadResponse(pending *pendingUpload) *UploadResponse {
+	parsedData := pending.Data
+
+	response := &UploadResponse{
+		Success:            true,
+		FileID:             pending.FileID,
+		Filename:           pending.Filename,
+		TempPath:           pending.TempPath,
+		Source:             pending.Source,
+		TransactionsParsed: parsedData.ParsedCount,
+		PaymentsExcluded:   parsedData.PaymentsExcluded,
+		RowsRejected:       len(parsedData.RejectedRows),
+		RejectedRows:       parsedData.RejectedRows,
+		Format:             parsedData.Format,
+		DateOrder:          parsedData.DateOrder,
+		DateOrderAmbiguous: parsedData.DateOrderAmbiguous,
+		Sheet:              parsedData.Sheet,
+		Sheets:             parsedData.Sheets,
+		Payouts:            parsedData.Payouts,
+		BusinessMiles:      int(math.Round(parsedData.BusinessMiles)),
+	}
+
+	// Suggest a saved profile for layouts parsed without one
+	if pending.Profile == nil {
+		if suggested := findProfileBySignature(parsedData.HeaderSignature); suggested != nil {
+			response.SuggestedProfileID = suggested.ID
+			response.SuggestedProfileName = suggested.Name
+		}
+	}
+
+	return response
+}
+
+// commitUpload persists an upload previously parsed with dry_run=true
+func commitUpload(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		PreviewToken string `json:"preview_token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if request.PreviewToken == "" {
+		http.Error(w, "preview_token is required", http.StatusBadRequest)
+		return
+	}
+
+	pending := takePendingUpload(request.PreviewToken)
+	if pending == nil {
+		http.Error(w, "Preview not found or expired", http.StatusNotFound)
+		return
+	}
+
+	response, err := persistUpload(pending)
+	if err != nil {
+		log.Printf("Error committing upload: %v", err)
+		removeUpload(pending.TempPath) // The token is spent, so the preview can't be retried
+		writeUploadError(w, http.StatusInternalServerError, "Failed to save transactions", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// MappingProfile describes how to read a CSV export that none of the built-in
+// FormatParsers recognize. Column names are matched case-insensitively.
+type MappingProfile struct {
+	ID                int    `json:"id" db:"id"`
+	Name              string `json:"name" db:"name"`
+	DateColumn        string `json:"date_column" db:"date_column"`
+	DateFormat        string `json:"date_format" db:"date_format"` // e.g. "MM/DD/YYYY" or a Go layout; empty = auto-detect
+	DateOrder         string `json:"date_order" db:"date_order"`   // "month_first" or "day_first" when date_format is empty; empty = infer per file
+	DescriptionColumn string `json:"description_column" db:"description_column"`
+	AmountColumn      string `json:"amount_column" db:"amount_column"` // Single signed amount column
+	DebitColumn       string `json:"debit_column" db:"debit_column"`   // Or split debit/credit columns
+	CreditColumn      string `json:"credit_column" db:"credit_column"`
+	SignConvention    string `json:"sign_convention" db:"sign_convention"`     // "expenses_positive" (card style) or "expenses_negative" (bank style)
+	DecimalSeparator  string `json:"decimal_separator" db:"decimal_separator"` // "." or ","; empty = infer from each value
+	HeaderRow         int    `json:"header_row" db:"header_row"`               // Number of rows above the header row
+	CardName          string `json:"card_name" db:"card_name"`
+	HeaderSignature   string `json:"header_signature" db:"header_signature"` // First row of the file, used to suggest this profile
+	CreatedAt         string `json:"created_at" db:"created_at"`
+}
+
+// headerSignature normalizes a file's first row so the same export layout
+// produces the same signature regardless of case or spacing
+func headerSignature(headers []string) string {
+	normalized := make([]string, len(headers))
+	for i, header := range headers {
+		normalized[i] = normalizeHeader(header)
+	}
+	return strings.Join(normalized, "|")
+}
+
+// profileDateLayout turns friendly formats like "DD/MM/YYYY" or "MM/DD"
+// into a Go layout. Strings that are already Go layouts are returned unchanged.
+func profileDateLayout(format string) string {
+	upper := strings.ToUpper(format)
+	if !strings.Contains(upper, "YY") && !strings.Contains(upper, "MM") && !strings.Contains(upper, "DD") {
+		return format
+	}
+	replacer := strings.NewReplacer(
+		"YYYY", "2006", "yyyy", "2006",
+		"YY", "06", "yy", "06",
+		"MM", "01", "mm", "01",
+		"DD", "02", "dd", "02",
+		"M", "1", "D", "2", "d", "2",
+	)
+	return replacer.Replace(format)
+}
+
+// profileFormat adapts a saved MappingProfile to the FormatParser interface
+type profileFormat struct {
+	profile MappingProfile
+}
+
+func (p profileFormat) Name() string { return "profile:" + p.profile.Name }
+
+func (p profileFormat) Detect(headers []string) int {
+	row := newCSVRow(nil, headers)
+	for _, column := range []string{p.profile.DateColumn, p.profile.DescriptionColumn} {
+		if _, ok := row.columns[normalizeHeader(column)]; !ok {
+			return 0
+		}
+	}
+	return 100
+}
+
+func (p profileFormat) MappedColumns() []string {
+	var columns []string
+	for _, column := range []string{p.profile.DateColumn, p.profile.DescriptionColumn, p.profile.AmountColumn, p.profile.DebitColumn, p.profile.CreditColumn} {
+		if column != "" {
+			columns = append(columns, normalizeHeader(column))
+		}
+	}
+	return columns
+}
+
+func (p profileFormat) Parse(record []string, headers []string, transaction Transaction) (*Transaction, bool, error) {
+	profile := p.profile
+	row := newCSVRow(record, headers)
+
+	if profile.CardName != "" {
+		transaction.Card = profile.CardName
+	}
+
+	dateValue := row.get(normalizeHeader(profile.DateColumn))
+	var date time.Time
+	var err error
+	if profile.DateFormat != "" {
+		date, err = time.Parse(profileDateLayout(profile.DateFormat), dateValue)
+	} else {
+		date, err = parseDateInOrder(dateValue, transaction.dateOrder)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid date: %v", err)
+	}
+	transaction.Date = date
+
+	description := row.get(normalizeHeader(profile.DescriptionColumn))
+	if description == "" {
+		return nil, false, fmt.Errorf("missing description")
+	}
+	transaction.Description = description
+	transaction.Vendor = extractVendorName(description)
+
+	if profile.AmountColumn != "" {
+		amount, err := parseAmountFieldWith(row.get(normalizeHeader(profile.AmountColumn)), profile.DecimalSeparator)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid amount: %v", err)
+		}
+		if profile.SignConvention == "expenses_negative" {
+			amount = accountAmount(amount, transaction)
+		}
+		transaction.Amount = amount
+	} else {
+		debit, err := parseAmountFieldWith(row.get(normalizeHeader(profile.DebitColumn)), profile.DecimalSeparator)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid debit: %v", err)
+		}
+		credit, err := parseAmountFieldWith(row.get(normalizeHeader(profile.CreditColumn)), profile.DecimalSeparator)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid credit: %v", err)
+		}
+		// Some banks write debits as negative numbers in the debit column
+		transaction.Amount = accountAmount(credit.Abs()-debit.Abs(), transaction)
+	}
+	transaction.bankAccount = profile.SignConvention == "expenses_negative"
+
+	finishTransaction(&transaction)
+	return &transaction, false, nil
+}
+
+func validateMappingProfile(profile *MappingProfile) error {
+	profile.Name = strings.TrimSpace(profile.Name)
+	if profile.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if strings.TrimSpace(profile.DateColumn) == "" || strings.TrimSpace(profile.DescriptionColumn) == "" {
+		return fmt.Errorf("date_column and description_column are required")
+	}
+	if profile.AmountColumn == "" && profile.DebitColumn == "" && profile.CreditColumn == "" {
+		return fmt.Errorf("amount_column or debit_column/credit_column is required")
+	}
+	if profile.SignConvention == "" {
+		profile.SignConvention = "expenses_positive"
+	}
+	if profile.SignConvention != "expenses_positive" && profile.SignConvention != "expenses_negative" {
+		return fmt.Errorf("sign_convention must be expenses_positive or expenses_negative")
+	}
+	if profile.DecimalSeparator != "" && profile.DecimalSeparator != "." && profile.DecimalSeparator != "," {
+		return fmt.Errorf("decimal_separator must be \".\" or \",\"")
+	}
+	if !validDateOrder(profile.DateOrder) {
+		return fmt.Errorf("date_order must be month_first or day_first")
+	}
+	if profile.HeaderRow < 0 {
+		return fmt.Errorf("header_row must be non-negative")
+	}
+	return nil
+}
+
+func getMappingProfile(id int) (*MappingProfile, error) {
+	query := `
+		SELECT id, name, date_column, date_format, description_column, amount_column, debit_column, credit_column,
+		       sign_convention, decimal_separator, date_order, header_row, card_name, header_signature, created_at
+		FROM mapping_profiles
+		WHERE id = ?
+	`
+
+	var profile MappingProfile
+	err := db.QueryRow(query, id).Scan(&profile.ID, &profile.Name, &profile.DateColumn, &profile.DateFormat,
+		&profile.DescriptionColumn, &profile.AmountColumn, &profile.DebitColumn, &profile.CreditColumn,
+		&profile.SignConvention, &profile.DecimalSeparator, &profile.DateOrder, &profile.HeaderRow, &profile.CardName, &profile.HeaderSignature, &profile.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// findProfileBySignature returns the most recently used profile whose saved
+// header signature matches, or nil if there is none
+func findProfileBySignature(signature string) *MappingProfile {
+	if signature == "" {
+		return nil
+	}
+
+	var id int
+	err := db.QueryRow("SELECT id FROM mapping_profiles WHERE header_signature = ? ORDER BY updated_at DESC LIMIT 1", signature).Scan(&id)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error looking up mapping profile: %v", err)
+		}
+		return nil
+	}
+
+	profile, err := getMappingProfile(id)
+	if err != nil {
+		log.Printf("Error loading mapping profile %d: %v", id, err)
+		return nil
+	}
+	return profile
+}
+
+// rememberProfileSignature records the header signature of a file that was
+// imported with a profile so the profile is suggested for the next export
+func rememberProfileSignature(dbTx *sql.Tx, profileID int, signature string) error {
+	_, err := dbTx.Exec("UPDATE mapping_profiles SET header_signature = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", signature, profileID)
+	return err
+}
+
+func createMappingProfile(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		MappingProfile
+		Headers []string `json:"headers,omitempty"` // Optional sample first row, used as the header signature
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	profile := request.MappingProfile
+	if err := validateMappingProfile(&profile); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(request.Headers) > 0 {
+		profile.HeaderSignature = headerSignature(request.Headers)
+	}
+
+	query := `
+		INSERT INTO mapping_profiles (name, date_column, date_format, description_column, amount_column, debit_column, credit_column,
+		                              sign_convention, decimal_separator, date_order, header_row, card_name, header_signature)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			date_column = excluded.date_column,
+			date_format = excluded.date_format,
+			description_column = excluded.description_column,
+			amount_column = excluded.amount_column,
+			debit_column = excluded.debit_column,
+			credit_column = excluded.credit_column,
+			sign_convention = excluded.sign_convention,
+			decimal_separator = excluded.decimal_separator,
+			date_order = excluded.date_order,
+			header_row = excluded.header_row,
+			card_name = excluded.card_name,
+			header_signature = CASE WHEN excluded.header_signature <> '' THEN excluded.header_signature ELSE header_signature END,
+			updated_at = CURRENT_TIMESTAMP
+	`
+
+	_, err := db.Exec(query, profile.Name, profile.DateColumn, profile.DateFormat, profile.DescriptionColumn,
+		profile.AmountColumn, profile.DebitColumn, profile.CreditColumn, profile.SignConvention,
+		profile.DecimalSeparator, profile.DateOrder, profile.HeaderRow, profile.CardName, profile.HeaderSignature)
+	if err != nil {
+		log.Printf("Failed to save mapping profile: %v", err)
+		http.Error(w, "Failed to save mapping profile", http.StatusInternalServerError)
+		return
+	}
+
+	// LastInsertId isn't reliable for the upsert path, so look the row up by name
+	err = db.QueryRow("SELECT id FROM mapping_profiles WHERE name = ?", profile.Name).Scan(&profile.ID)
+	if err != nil {
+		log.Printf("Failed to load mapping profile: %v", err)
+		http.Error(w, "Failed to save mapping profile", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("🗺️ Saved mapping profile: %s (ID: %d)", profile.Name, profile.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Mapping profile saved successfully",
+		"profile": profile,
+	})
+}
+
+func getMappingProfiles(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT id, name, date_column, date_format, description_column, amount_column, debit_column, credit_column,
+		       sign_convention, decimal_separator, date_order, header_row, card_name, header_signature, created_at
+		FROM mapping_profiles
+		ORDER BY name
+	`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		log.Printf("Error querying mapping profiles: %v", err)
+		http.Error(w, "Failed to fetch mapping profiles", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var profiles []MappingProfile
+	for rows.Next() {
+		var profile MappingProfile
+		err := rows.Scan(&profile.ID, &profile.Name, &profile.DateColumn, &profile.DateFormat,
+			&profile.DescriptionColumn, &profile.AmountColumn, &profile.DebitColumn, &profile.CreditColumn,
+			&profile.SignConvention, &profile.DecimalSeparator, &profile.DateOrder, &profile.HeaderRow, &profile.CardName, &profile.HeaderSignature, &profile.CreatedAt)
+		if err != nil {
+			log.Printf("Error scanning mapping profile: %v", err)
+			continue
+		}
+		profiles = append(profiles, profile)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"profiles": profiles,
+		"count":    len(profiles),
+	})
+}
+
+func deleteMappingProfile(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid profile ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM mapping_profiles WHERE id = ?", id)
+	if err != nil {
+		log.Printf("Failed to delete mapping profile: %v", err)
+		http.Error(w, "Failed to delete mapping profile", http.StatusInternalServerError)
+		return
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		http.Error(w, "Mapping profile not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Mapping profile deleted successfully",
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// refundLinkWindow is how long after a purchase a refund can still be matched to it
+const refundLinkWindow = 180 * 24 * time.Hour
+
+// markRefund classifies credits on expense uploads. Card exports list returns
+// and merchant credits as negative amounts; they reduce the expense they came
+// from rather than being income or being ignored. A credit to a bank account
+// is usually a paycheck, transfer or payout deposit, so it's left
+// uncategorized unless linkRefunds matches it to a purchase.
+func markRefund(transaction *Transaction) {
+	if transaction.Type != "expense" || transaction.Amount >= 0 {
+		return
+	}
+	transaction.Expensable = false
+	if transaction.bankAccount {
+		transaction.Type = "uncategorized"
+		return
+	}
+	transaction.Type = "refund"
+}
+
+// linkRefunds matches unlinked refunds to the purchase they reverse: the most
+// recent expense on the same card from the same vendor, on or before the
+// refund date, that hasn't already been refunded in full. A purchase for the
+// exact amount is preferred. Bank account credits on expense uploads that
+// match a purchase become refunds. Returns how many refunds were linked.
+func linkRefunds() (int, error) {
+	rows, err := db.Query(`
+		SELECT t.id, t.date, t.vendor, t.amount_cents, t.card
+		FROM transactions t
+		LEFT JOIN csv_files f ON f.id = t.source_file
+		WHERE (t.type = 'refund' OR (t.type = 'uncategorized' AND t.amount_cents < 0 AND f.source = 'expenses'))
+		  AND (t.refund_of = '' OR t.refund_of IS NULL)
+		  AND t.id NOT IN (SELECT deposit_id FROM payouts WHERE deposit_id <> '')
+		ORDER BY t.date
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query refunds: %v", err)
+	}
+
+	var refunds []Transaction
+	for rows.Next() {
+		var tx Transaction
+		if err := rows.Scan(&tx.ID, &tx.Date, &tx.Vendor, &tx.Amount, &tx.Card); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan refund: %v", err)
+		}
+		refunds = append(refunds, tx)
+	}
+	rows.Close()
+
+	linked := 0
+	for _, refund := range refunds {
+		amount := refund.Amount.Abs()
+
+		var purchaseID string
+		err := db.QueryRow(`
+			SELECT p.id
+			FROM transactions p
+			WHERE p.type = 'expense' AND p.card = ? AND LOWER(p.vendor) = LOWER(?)
+			  AND p.date BETWEEN ? AND ?
+			  AND p.amount_cents - COALESCE((SELECT SUM(ABS(r.amount_cents)) FROM transactions r WHERE r.refund_of = p.id), 0) >= ?
+			ORDER BY p.amount_cents = ? DESC, p.date DESC
+			LIMIT 1
+		`, refund.Card, refund.Vendor, refund.Date.Add(-refundLinkWindow), refund.Date, amount, amount).Scan(&purchaseID)
+		if err != nil {
+			continue // No matching purchase; the refund still counts on its own line
+		}
+
+		if _, err := db.Exec("UPDATE transactions SET type = 'refund', expensable = false, refund_of = ? WHERE id = ?", purchaseID, refund.ID); err != nil {
+			return linked, fmt.Errorf("failed to link refund %s: %v", refund.ID, err)
+		}
+		linked++
+	}
+
+	if linked > 0 {
+		log.Printf("↩️ Linked %d refunds to their original purchases", linked)
+	}
+	return linked, nil
+}
+
+// expenseLinesQuery totals taxYear's expenses by Schedule C line with refunds
+// netted against the line of the purchase they were linked to. condition
+// filters the purchase (alias p), e.g. "p.expensable = true". Unlinked
+// refunds, and refunds whose purchase has since been deleted, use their own
+// line and count when the refund itself is marked business. Refunds count in
+// the year they were received, whatever the purchase's year.
+func expenseLinesQuery(condition string, taxYear int) string {
+	return fmt.Sprintf(`
+		SELECT line, SUM(amount)
+		FROM (
+			SELECT p.schedule_c_line AS line, ABS(p.amount_cents) AS amount
+			FROM transactions p
+			WHERE p.type = 'expense' AND %[1]s AND p.schedule_c_line > 0 AND strftime('%%Y', p.date) = '%[2]d'
+
+			UNION ALL
+
+			SELECT p.schedule_c_line AS line, -ABS(r.amount_cents) AS amount
+			FROM transactions r
+			JOIN transactions p ON p.id = r.refund_of
+			WHERE r.type = 'refund' AND r.refund_of <> '' AND %[1]s AND p.schedule_c_line > 0 AND strftime('%%Y', r.date) = '%[2]d'
+
+			UNION ALL
+
+			SELECT r.schedule_c_line AS line, -ABS(r.amount_cents) AS amount
+			FROM transactions r
+			WHERE r.type = 'refund' AND r.is_business = true AND r.schedule_c_line > 0 AND strftime('%%Y', r.date) = '%[2]d'
+			  AND NOT EXISTS (SELECT 1 FROM transactions p WHERE p.id = COALESCE(r.refund_of, ''))
+		)
+		GROUP BY line
+	`, condition, taxYear)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpenseLinesTaxYear(t *testing.T) {
+	openTestDB(t)
+	insertTransactions(t, []Transaction{
+		{ID: "laptop", Date: time.Date(2023, 12, 20, 0, 0, 0, 0, time.UTC), Vendor: "BEST BUY", Amount: 120000, Card: "amex", Type: "expense", Expensable: true, ScheduleCLine: 22},
+		{ID: "return", Date: time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC), Vendor: "BEST BUY", Amount: -20000, Card: "amex", Type: "refund", ScheduleCLine: 22},
+		{ID: "paper", Date: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), Vendor: "STAPLES", Amount: 5000, Card: "amex", Type: "expense", Expensable: true, ScheduleCLine: 22},
+	})
+	if linked, err := linkRefunds(); err != nil || linked != 1 {
+		t.Fatalf("linkRefunds() = %d, %v, want 1 link", linked, err)
+	}
+
+	lineTotal := func(taxYear int) Cents {
+		t.Helper()
+		rows, err := db.Query(expenseLinesQuery("p.expensable = true", taxYear))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var total Cents
+		for rows.Next() {
+			var line int
+			var amount Cents
+			if err := rows.Scan(&line, &amount); err != nil {
+				t.Fatal(err)
+			}
+			total += amount
+		}
+		return total
+	}
+
+	// A purchase counts in its year and a refund in the year it was received
+	if got := lineTotal(2023); got != 120000 {
+		t.Errorf("2023 expenses = %v, want 1200.00", got)
+	}
+	if got := lineTotal(2024); got != -15000 {
+		t.Errorf("2024 expenses = %v, want -150.00", got)
+	}
+}
+
+func TestBankAccountCredits(t *testing.T) {
+	openTestDB(t)
+	if _, err := db.Exec("INSERT INTO csv_files (id, filename, source) VALUES ('checking', 'checking.csv', 'expenses')"); err != nil {
+		t.Fatal(err)
+	}
+
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	credit := func(id, vendor string) Transaction {
+		tx := Transaction{ID: id, Date: date.AddDate(0, 0, 5), Vendor: vendor, Amount: -4000, Card: "checking", Type: "expense", SourceFile: "checking", bankAccount: true}
+		markRefund(&tx)
+		return tx
+	}
+
+	// A deposit isn't a refund just because it's on an expense upload
+	paycheck := credit("paycheck", "ACME PAYROLL")
+	if paycheck.Type != "uncategorized" {
+		t.Errorf("bank credit type = %q, want uncategorized", paycheck.Type)
+	}
+	card := Transaction{Amount: -4000, Type: "expense"}
+	if markRefund(&card); card.Type != "refund" {
+		t.Errorf("card credit type = %q, want refund", card.Type)
+	}
+
+	// unless it reverses a purchase
+	insertTransactions(t, []Transaction{
+		{ID: "boots", Date: date, Vendor: "REI", Amount: 4000, Card: "checking", Type: "expense", Expensable: true, SourceFile: "checking"},
+		credit("return", "REI"),
+		paycheck,
+	})
+	if linked, err := linkRefunds(); err != nil || linked != 1 {
+		t.Fatalf("linkRefunds() = %d, %v, want 1 link", linked, err)
+	}
+
+	for id, want := range map[string]string{"return": "refund", "paycheck": "uncategorized"} {
+		var got string
+		if err := db.QueryRow("SELECT type FROM transactions WHERE id = ?", id).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s type = %q, want %q", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// VendorAlias maps raw descriptors containing Pattern (case-insensitive) to a
+// canonical vendor name, e.g. "AMZN MKTP" -> "Amazon"
+type VendorAlias struct {
+	ID        int    `json:"id" db:"id"`
+	Pattern   string `json:"pattern" db:"pattern"`
+	Vendor    string `json:"vendor" db:"vendor"`
+	CreatedAt string `json:"created_at" db:"created_at"`
+}
+
+var (
+	// Masked card numbers: "XXXXXXXXXXXX1091", "****1091", "...1091", and the
+	// "null" Chase puts before them
+	cardMaskPattern = regexp.MustCompile(`(?i)\bnull\b|X{4,}\d*|\*{4,}\d*|\.{3}\d{4}\b`)
+
+	// Phone numbers and other long digit runs: "866-712-7753", "(800) 555-1234", "14156399034"
+	phonePattern = regexp.MustCompile(`\(?\b\d{3}\)?[-. ]?\d{3}[-. ]\d{4}\b|\b\d{7,}\b`)
+
+	// Payment processors and wallets that prefix the merchant: "SQ *", "TST* ", "AplPay "
+	processorPrefixPattern = regexp.MustCompile(`(?i)^((aplpay|tst|sqc|squ|sq|py|dd|pp|sp|in|cko|bt|fs|wpy|eb|pt|ott|iz|zettle|sumup|google|paypal)\s*\*\s*|aplpay\s+)`)
+
+	// Store numbers and other purely numeric words: "#15", "0000", "00-0803"
+	storeNumberPattern = regexp.MustCompile(`^#?\d[\d-]*$`)
+
+	// Invoice and terminal references: "INV140938464", "0J96NZVYJT"
+	referencePattern = regexp.MustCompile(`^[A-Z0-9]{6,}$`)
+
+	// Order references after a star: "AMAZON.COM*RT4GH2", "ONLYFANS.COM*A"
+	orderReferencePattern = regexp.MustCompile(`\*([A-Za-z0-9]*\d[A-Za-z0-9]*|[A-Za-z0-9]{1,2})$`)
+)
+
+// countryCodes are the ISO 3166 alpha-3 codes card networks append to
+// foreign merchants ("Dublin IRL")
+var countryCodes = map[string]bool{
+	"AUS": true, "AUT": true, "BEL": true, "BRA": true, "CAN": true, "CHE": true,
+	"DEU": true, "DNK": true, "ESP": true, "FIN": true, "FRA": true, "GBR": true,
+	"HKG": true, "IND": true, "IRL": true, "ISR": true, "ITA": true, "JPN": true,
+	"LUX": true, "MEX": true, "NLD": true, "NOR": true, "NZL": true, "POL": true,
+	"PRT": true, "SGP": true, "SWE": true,
+}
+
+// merchantCities are cities that commonly follow the merchant name. Chase cuts
+// the city to 13 characters ("SOUTH SAN FRA"), so prefixes of that length match.
+var merchantCities = []string{
+	"ALBUQUERQUE", "AMSTERDAM", "ARLINGTON", "ATLANTA", "AUSTIN", "BALTIMORE",
+	"BELLEVUE", "BERKELEY", "BOSTON", "BRIGHTON", "BROOKLYN", "BURLINGAME",
+	"CAMBRIDGE", "CHARLOTTE", "CHICAGO", "CLEVELAND", "COLUMBUS", "CUPERTINO",
+	"DALLAS", "DENVER", "DETROIT", "DOVER", "DUBLIN", "EL PASO", "FORT WORTH",
+	"FRESNO", "HARRISBURG", "HONOLULU", "HOUSTON", "INDIANAPOLIS", "JACKSONVILLE",
+	"JERSEY CITY", "KANSAS CITY", "LAS VEGAS", "LONDON", "LONG BEACH",
+	"LONG ISLAND CITY", "LOS ANGELES", "LOS GATOS", "LOUISVILLE", "MEMPHIS",
+	"MENLO PARK", "MESA", "MIAMI", "MILWAUKEE", "MINNEAPOLIS", "MOUNTAIN VIEW",
+	"NASHVILLE", "NEW ORLEANS", "NEW YORK", "NEWARK", "OAKLAND", "OKLAHOMA CITY",
+	"OMAHA", "ORLANDO", "PALO ALTO", "PHILADELPHIA", "PHOENIX", "PITTSBURGH",
+	"PORTLAND", "RALEIGH", "REDMOND", "REDWOOD CITY", "RICHMOND", "SACRAMENTO",
+	"SALT LAKE CITY", "SAN ANTONIO", "SAN DIEGO", "SAN FRANCISCO", "SAN JOSE",
+	"SAN MATEO", "SANTA CLARA", "SANTA MONICA", "SCOTTSDALE", "SEATTLE",
+	"SHERIDAN", "SOUTH SAN FRANCISCO", "ST LOUIS", "SUNNYVALE", "TAMPA",
+	"TORONTO", "TUCSON", "TULSA", "VANCOUVER", "WASHINGTON", "WILMINGTON",
+}
+
+// chaseCityWidth is how many characters of the city Chase keeps
+const chaseCityWidth = 13
+
+// extractVendorName reduces a raw bank descriptor to the merchant name:
+// location padding, masked card numbers, phone and store numbers, trailing
+// state/country codes and cities, and processor prefixes are removed.
+// "SQ *FILLUP COFFEE New York NY null XXXXXXXXXXXX1594" -> "FILLUP COFFEE"
+func extractVendorName(description string) string {
+	vendor := strings.TrimSpace(description)
+
+	// Amex pads merchant and city into fixed 20-character fields, so the
+	// location can be cut off by position: "AplPay NYCT PAYGO   NEW YORK   NY"
+	fixedWidth := utf8.RuneCountInString(vendor) >= 40 && (strings.Contains(vendor, "  ") || isAmexStateSuffix(vendor))
+	if fixedWidth {
+		vendor = truncateRunes(vendor, 20)
+	}
+
+	vendor = cardMaskPattern.ReplaceAllString(vendor, " ")
+	vendor = phonePattern.ReplaceAllString(vendor, " ")
+	vendor = strings.Join(strings.Fields(vendor), " ")
+
+	// Remove processor prefixes, which can be stacked ("AplPay TST* ...")
+	for {
+		trimmed := processorPrefixPattern.ReplaceAllString(vendor, "")
+		if trimmed == vendor || trimmed == "" {
+			break
+		}
+		vendor = trimmed
+	}
+
+	// Remove location suffixes (state or country code, then city)
+	words := strings.Fields(vendor)
+	if !fixedWidth && len(words) > 1 {
+		lastWord := words[len(words)-1]
+		if (len(lastWord) == 2 && strings.ToUpper(lastWord) == lastWord) || countryCodes[lastWord] {
+			words = words[:len(words)-1]
+		}
+		words = trimMerchantCity(words)
+	}
+
+	// A store number ends the merchant name ("DUANE READE #14146 NEW YORK");
+	// short numbers are dropped on their own
+	var kept []string
+	for i, word := range words {
+		if i > 0 && isReference(word) {
+			break
+		}
+		if !storeNumberPattern.MatchString(word) {
+			kept = append(kept, word)
+			continue
+		}
+		if i > 0 && len(strings.Trim(word, "#-")) >= 3 {
+			break
+		}
+	}
+	if len(kept) > 0 {
+		vendor = strings.Join(kept, " ")
+	}
+
+	if trimmed := orderReferencePattern.ReplaceAllString(vendor, ""); strings.TrimSpace(trimmed) != "" {
+		vendor = trimmed
+	}
+	vendor = strings.Trim(vendor, " ,-*")
+
+	return strings.TrimSpace(truncateRunes(vendor, 50))
+}
+
+// truncateRunes cuts s to at most n characters, never inside a UTF-8 sequence
+func truncateRunes(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+// isAmexStateSuffix reports whether a 42-character descriptor ends in a state
+// or country code straight after a full city field
+func isAmexStateSuffix(description string) bool {
+	runes := []rune(description)
+	if len(runes) != 42 {
+		return false
+	}
+	for _, r := range runes[40:] {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// isReference reports whether a word looks like an invoice or terminal
+// number rather than part of a name: six or more capitals and digits, with
+// at least two digits
+func isReference(word string) bool {
+	if !referencePattern.MatchString(word) {
+		return false
+	}
+	digits := 0
+	for _, r := range word {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	return digits >= 2
+}
+
+// trimMerchantCity removes a known city from the end of the words, keeping at
+// least one word
+func trimMerchantCity(words []string) []string {
+	upper := strings.ToUpper(strings.Join(words, " "))
+	for _, city := range merchantCities {
+		candidates := []string{city}
+		if len(city) > chaseCityWidth {
+			candidates = append(candidates, city[:chaseCityWidth])
+		}
+		for _, candidate := range candidates {
+			if !strings.HasSuffix(upper, " "+candidate) {
+				continue
+			}
+			n := len(strings.Fields(candidate))
+			if n < len(words) {
+				return words[:len(words)-n]
+			}
+		}
+	}
+	return words
+}
+
+// loadVendorAliases returns aliases longest pattern first, so the most
+// specific alias wins when several match
+func loadVendorAliases() ([]VendorAlias, error) {
+	rows, err := db.Query("SELECT id, pattern, vendor, created_at FROM vendor_aliases")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query vendor aliases: %v", err)
+	}
+	defer rows.Close()
+
+	var aliases []VendorAlias
+	for rows.Next() {
+		var alias VendorAlias
+		if err := rows.Scan(&alias.ID, &alias.Pattern, &alias.Vendor, &alias.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan vendor alias: %v", err)
+		}
+		alias.Pattern = strings.ToLower(alias.Pattern)
+		aliases = append(aliases, alias)
+	}
+
+	sort.SliceStable(aliases, func(i, j int) bool {
+		return len(aliases[i].Pattern) > len(aliases[j].Pattern)
+	})
+	return aliases, rows.Err()
+}
+
+// applyVendorAliases replaces the normalized vendor with the canonical name of
+// the first alias found in the raw description or the vendor
+func applyVendorAliases(aliases []VendorAlias, transaction *Transaction) {
+	description := strings.ToLower(transaction.Description)
+	vendor := strings.ToLower(transaction.Vendor)
+	for _, alias := range aliases {
+		if strings.Contains(description, alias.Pattern) || strings.Contains(vendor, alias.Pattern) {
+			transaction.Vendor = alias.Vendor
+			return
+		}
+	}
+}
+
+// createVendorAlias saves an alias (replacing one with the same pattern) and
+// renames the vendor on existing transactions whose description matches
+func createVendorAlias(w http.ResponseWriter, r *http.Request) {
+	var alias VendorAlias
+	if err := json.NewDecoder(r.Body).Decode(&alias); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	alias.Pattern = strings.TrimSpace(alias.Pattern)
+	alias.Vendor = strings.TrimSpace(alias.Vendor)
+	if alias.Pattern == "" || alias.Vendor == "" {
+		http.Error(w, "pattern and vendor are required", http.StatusBadRequest)
+		return
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO vendor_aliases (pattern, vendor) VALUES (?, ?)
+		ON CONFLICT(pattern) DO UPDATE SET vendor = excluded.vendor
+	`, alias.Pattern, alias.Vendor)
+	if err != nil {
+		log.Printf("Failed to save vendor alias: %v", err)
+		http.Error(w, "Failed to save vendor alias", http.StatusInternalServerError)
+		return
+	}
+
+	err = db.QueryRow("SELECT id, created_at FROM vendor_aliases WHERE pattern = ?", alias.Pattern).Scan(&alias.ID, &alias.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to load vendor alias: %v", err)
+		http.Error(w, "Failed to save vendor alias", http.StatusInternalServerError)
+		return
+	}
+
+	// instr matches the pattern literally, so % and _ in it aren't wildcards
+	result, err := db.Exec(`
+		UPDATE transactions SET vendor = ?
+		WHERE instr(lower(COALESCE(description, '')), lower(?)) > 0 OR instr(lower(vendor), lower(?)) > 0
+	`, alias.Vendor, alias.Pattern, alias.Pattern)
+	if err != nil {
+		log.Printf("Failed to apply vendor alias: %v", err)
+		http.Error(w, "Failed to apply vendor alias", http.StatusInternalServerError)
+		return
+	}
+	updated, _ := result.RowsAffected()
+
+	log.Printf("🏷️ Saved vendor alias: %q -> %s (%d transactions)", alias.Pattern, alias.Vendor, updated)
+	if updated > 0 {
+		publishEvent(EventRuleApplied, RuleAppliedEvent{
+			RuleType: "vendor_alias",
+			Rule:     alias.Pattern,
+			Vendor:   alias.Vendor,
+			Count:    int(updated),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":              true,
+		"message":              "Vendor alias saved successfully",
+		"alias":                alias,
+		"transactions_updated": updated,
+	})
+}
+
+func getVendorAliases(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query("SELECT id, pattern, vendor, created_at FROM vendor_aliases ORDER BY vendor, pattern")
+	if err != nil {
+		log.Printf("Error querying vendor aliases: %v", err)
+		http.Error(w, "Failed to fetch vendor aliases", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var aliases []VendorAlias
+	for rows.Next() {
+		var alias VendorAlias
+		if err := rows.Scan(&alias.ID, &alias.Pattern, &alias.Vendor, &alias.CreatedAt); err != nil {
+			log.Printf("Error scanning vendor alias: %v", err)
+			continue
+		}
+		aliases = append(aliases, alias)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"aliases": aliases,
+		"count":   len(aliases),
+	})
+}
+
+func deleteVendorAlias(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid alias ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM vendor_aliases WHERE id = ?", id)
+	if err != nil {
+		log.Printf("Failed to delete vendor alias: %v", err)
+		http.Error(w, "Failed to delete vendor alias", http.StatusInternalServerError)
+		return
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		http.Error(w, "Vendor alias not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Vendor alias deleted successfully",
+	})
+}
+
+// normalizeVendors recomputes every transaction's vendor from its raw
+// description, so rows imported before a pipeline or alias change group with
+// new ones. Vendor rules written against the old names may need updating.
+func normalizeVendors(w http.ResponseWriter, r *http.Request) {
+	aliases, err := loadVendorAliases()
+	if err != nil {
+		log.Printf("Error loading vendor aliases: %v", err)
+		http.Error(w, "Failed to normalize vendors", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Query("SELECT id, vendor, COALESCE(description, '') FROM transactions")
+	if err != nil {
+		log.Printf("Error querying transactions: %v", err)
+		http.Error(w, "Failed to normalize vendors", http.StatusInternalServerError)
+		return
+	}
+
+	var transactions []Transaction
+	for rows.Next() {
+		var tx Transaction
+		if err := rows.Scan(&tx.ID, &tx.Vendor, &tx.Description); err != nil {
+			log.Printf("Error scanning transaction: %v", err)
+			continue
+		}
+		transactions = append(transactions, tx)
+	}
+	rows.Close()
+
+	updated := 0
+	for _, tx := range transactions {
+		original := tx.Vendor
+		raw := tx.Description
+		if raw == "" {
+			raw = tx.Vendor // Imported before the raw description was kept
+		}
+		tx.Vendor = extractVendorName(raw)
+		applyVendorAliases(aliases, &tx)
+		if tx.Vendor == original {
+			continue
+		}
+
+		if _, err := db.Exec("UPDATE transactions SET vendor = ? WHERE id = ?", tx.Vendor, tx.ID); err != nil {
+			log.Printf("Failed to update vendor for %s: %v", tx.ID, err)
+			continue
+		}
+		updated++
+	}
+
+	// Refunds match purchases by vendor, so more of them may link now
+	if _, err := linkRefunds(); err != nil {
+		log.Printf("Error linking refunds: %v", err)
+	}
+
+	log.Printf("🏷️ Normalized vendor names for %d transactions", updated)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":              true,
+		"message":              "Vendor names normalized",
+		"transactions_updated": updated,
+	})
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestExtractVendorName(t *testing.T) {
+	tests := []struct {
+		description string
+		want        string
+	}{
+		{"SQ *FILLUP COFFEE New York NY null XXXXXXXXXXXX1594", "FILLUP COFFEE"},
+		{"AplPay NYCT PAYGO   NEW YORK            NY", "NYCT PAYGO"},
+		// Amex fixed-width fields are counted in characters, not bytes
+		{"CAFÉ CRÈME BRÛLÉE ÀÉ PARÍS              FR", "CAFÉ CRÈME BRÛLÉE ÀÉ"},
+		{strings.Repeat("Ü", 60), strings.Repeat("Ü", 50)},
+	}
+
+	for _, tt := range tests {
+		got := extractVendorName(tt.description)
+		if got != tt.want {
+			t.Errorf("extractVendorName(%q) = %q, want %q", tt.description, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("extractVendorName(%q) cut a character in half: %q", tt.description, got)
+		}
+	}
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func isXLSXFilename(filename string) bool {
+	return strings.HasSuffix(strings.ToLower(filename), ".xlsx")
+}
+
+// xlsxWorkbook is an open .xlsx package. Shared strings and cell styles are
+// loaded up front; worksheet rows are streamed by xlsxRecords.
+type xlsxWorkbook struct {
+	zip           *zip.ReadCloser
+	files         map[string]*zip.File
+	sheets        []xlsxSheet
+	sharedStrings []string
+	styleKinds    []string // Per cell style index: "date", "time" or "" for other number formats
+	date1904      bool     // Serial dates count from 1904 (older Mac workbooks)
+}
+
+type xlsxSheet struct {
+	Name string
+	Path string // Package path, e.g. "xl/worksheets/sheet1.xml"
+}
+
+// xlsxText is a shared or inline string: plain text, or rich text runs.
+// Phonetic hints (rPh) are not part of the value.
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	text := t.T
+	for _, run := range t.Runs {
+		text += run.T
+	}
+	return text
+}
+
+type xlsxCell struct {
+	Ref    string   `xml:"r,attr"` // e.g. "C5"; may be omitted for consecutive cells
+	Type   string   `xml:"t,attr"` // "s" shared string, "inlineStr", "str", "b", "e", "d", or "n"/"" for numbers
+	Style  int      `xml:"s,attr"`
+	Value  string   `xml:"v"`
+	Inline xlsxText `xml:"is"`
+}
+
+type xlsxRow struct {
+	Num   int        `xml:"r,attr"` // 1-based row number
+	Cells []xlsxCell `xml:"c"`
+}
+
+// xlsxMerge is a merged cell range; every cell in it takes the value of its
+// top-left cell
+type xlsxMerge struct {
+	firstRow, firstCol, lastRow, lastCol int // Rows 1-based, columns 0-based
+	value                                string
+}
+
+func openXLSXWorkbook(filePath string) (*xlsxWorkbook, error) {
+	z, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open XLSX: %v", err)
+	}
+
+	workbook := &xlsxWorkbook{zip: z, files: make(map[string]*zip.File)}
+	for _, f := range z.File {
+		workbook.files[f.Name] = f
+	}
+
+	if err := workbook.load(); err != nil {
+		z.Close()
+		return nil, err
+	}
+	return workbook, nil
+}
+
+func (wb *xlsxWorkbook) Close() error {
+	return wb.zip.Close()
+}
+
+// readXML decodes a package part into v. Missing parts return found=false.
+func (wb *xlsxWorkbook) readXML(name string, v interface{}) (bool, error) {
+	f, ok := wb.files[name]
+	if !ok {
+		return false, nil
+	}
+	r, err := f.Open()
+	if err != nil {
+		return true, fmt.Errorf("failed to open %s: %v", name, err)
+	}
+	defer r.Close()
+
+	if err := xml.NewDecoder(r).Decode(v); err != nil {
+		return true, fmt.Errorf("failed to read %s: %v", name, err)
+	}
+	return true, nil
+}
+
+func (wb *xlsxWorkbook) load() error {
+	var workbook struct {
+		Properties struct {
+			Date1904 string `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []struct {
+			Name  string `xml:"name,attr"`
+			RelID string `xml:"id,attr"` // r:id
+		} `xml:"sheets>sheet"`
+	}
+	found, err := wb.readXML("xl/workbook.xml", &workbook)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("not an XLSX workbook (no xl/workbook.xml)")
+	}
+	wb.date1904 = workbook.Properties.Date1904 == "1" || workbook.Properties.Date1904 == "true"
+
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if _, err := wb.readXML("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return err
+	}
+	targets := make(map[string]string)
+	for _, rel := range rels.Relationships {
+		// Targets are relative to xl/ unless they start with "/"
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = "xl/" + rel.Target
+		}
+	}
+	for _, sheet := range workbook.Sheets {
+		if target, ok := targets[sheet.RelID]; ok {
+			wb.sheets = append(wb.sheets, xlsxSheet{Name: sheet.Name, Path: target})
+		}
+	}
+	if len(wb.sheets) == 0 {
+		return fmt.Errorf("workbook has no worksheets")
+	}
+
+	var sst struct {
+		Items []xlsxText `xml:"si"`
+	}
+	if _, err := wb.readXML("xl/sharedStrings.xml", &sst); err != nil {
+		return err
+	}
+	for _, item := range sst.Items {
+		wb.sharedStrings = append(wb.sharedStrings, item.String())
+	}
+
+	var styles struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		CellXfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if _, err := wb.readXML("xl/styles.xml", &styles); err != nil {
+		return err
+	}
+	customFormats := make(map[int]string)
+	for _, format := range styles.NumFmts {
+		customFormats[format.ID] = format.Code
+	}
+	for _, xf := range styles.CellXfs {
+		wb.styleKinds = append(wb.styleKinds, numberFormatKind(xf.NumFmtID, customFormats[xf.NumFmtID]))
+	}
+
+	return nil
+}
+
+// numberFormatKind reports whether a number format shows a date, a time of
+// day, or a plain number. Built-in IDs are fixed by the spec; custom format
+// codes are checked for date and time tokens outside quotes and brackets.
+func numberFormatKind(id int, code string) string {
+	switch {
+	case id >= 14 && id <= 17, id == 22, id >= 27 && id <= 36, id >= 50 && id <= 58:
+		return "date"
+	case id >= 18 && id <= 21, id >= 45 && id <= 47:
+		return "time"
+	case code == "":
+		return ""
+	}
+
+	var tokens strings.Builder
+	inQuote, inBracket := false, false
+	for i := 0; i < len(code); i++ {
+		c := code[i]
+		switch {
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == '[':
+			inBracket = true
+		case c == ']':
+			inBracket = false
+		case inBracket:
+		case c == '\\' || c == '_' || c == '*':
+			i++ // Escaped or padding character
+		default:
+			tokens.WriteByte(c)
+		}
+	}
+
+	lower := strings.ToLower(tokens.String())
+	if strings.ContainsAny(lower, "yd") {
+		return "date"
+	}
+	if strings.ContainsAny(lower, "hs") {
+		return "time"
+	}
+	return ""
+}
+
+// sheet finds a worksheet by name or 1-based number; empty means the first
+func (wb *xlsxWorkbook) sheet(name string) (xlsxSheet, error) {
+	if name == "" {
+		return wb.sheets[0], nil
+	}
+	for _, sheet := range wb.sheets {
+		if strings.EqualFold(sheet.Name, name) {
+			return sheet, nil
+		}
+	}
+	if n, err := strconv.Atoi(name); err == nil && n >= 1 && n <= len(wb.sheets) {
+		return wb.sheets[n-1], nil
+	}
+	return xlsxSheet{}, fmt.Errorf("sheet %q not found; the workbook has: %s", name, strings.Join(wb.sheetNames(), ", "))
+}
+
+func (wb *xlsxWorkbook) sheetNames() []string {
+	names := make([]string, len(wb.sheets))
+	for i, sheet := range wb.sheets {
+		names[i] = sheet.Name
+	}
+	return names
+}
+
+// mergedCells reads a worksheet's merged ranges. They're listed after the
+// rows, so this is a separate pass before the rows are streamed.
+func (wb *xlsxWorkbook) mergedCells(sheet xlsxSheet) ([]xlsxMerge, error) {
+	f, ok := wb.files[sheet.Path]
+	if !ok {
+		return nil, fmt.Errorf("worksheet %s is missing from the workbook", sheet.Name)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open worksheet %s: %v", sheet.Name, err)
+	}
+	defer r.Close()
+
+	var merges []xlsxMerge
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read worksheet %s: %v", sheet.Name, err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local == "sheetData" {
+			decoder.Skip()
+			continue
+		}
+		if start.Name.Local != "mergeCell" {
+			continue
+		}
+		for _, attr := range start.Attr {
+			if attr.Name.Local != "ref" {
+				continue
+			}
+			first, last, _ := strings.Cut(attr.Value, ":")
+			firstCol, firstRow := splitCellRef(first)
+			lastCol, lastRow := splitCellRef(last)
+			if firstRow > 0 && lastRow >= firstRow && lastCol >= firstCol {
+				merges = append(merges, xlsxMerge{firstRow: firstRow, firstCol: firstCol, lastRow: lastRow, lastCol: lastCol})
+			}
+		}
+	}
+	return merges, nil
+}
+
+// splitCellRef turns "C5" into column 2 (0-based) and row 5. Missing parts
+// are returned as -1 (column) and 0 (row).
+func splitCellRef(ref string) (col, row int) {
+	col = -1
+	i := 0
+	for ; i < len(ref); i++ {
+		c := ref[i]
+		if c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		col = (col+1)*26 + int(c-'A')
+	}
+	row, _ = strconv.Atoi(ref[i:])
+	return col, row
+}
+
+// cellValue renders a cell as the text a CSV export of the sheet would hold.
+// Numbers formatted as dates become YYYY-MM-DD (with the time when there is
+// one), so they don't depend on the file's day/month order.
+func (wb *xlsxWorkbook) cellValue(cell xlsxCell) string {
+	switch cell.Type {
+	case "s":
+		index, err := strconv.Atoi(strings.TrimSpace(cell.Value))
+		if err != nil || index < 0 || index >= len(wb.sharedStrings) {
+			return ""
+		}
+		return wb.sharedStrings[index]
+	case "inlineStr":
+		return cell.Inline.String()
+	case "b":
+		if strings.TrimSpace(cell.Value) == "1" {
+			return "TRUE"
+		}
+		return "FALSE"
+	case "str", "e", "d":
+		return cell.Value
+	}
+
+	value := strings.TrimSpace(cell.Value)
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value
+	}
+
+	kind := ""
+	if cell.Style >= 0 && cell.Style < len(wb.styleKinds) {
+		kind = wb.styleKinds[cell.Style]
+	}
+	switch kind {
+	case "date":
+		return wb.serialDate(number)
+	case "time":
+		return serialTime(number).Format("15:04:05")
+	}
+
+	// Rounding to 9 decimals drops binary noise such as 0.30000000000000004
+	return strconv.FormatFloat(math.Round(number*1e9)/1e9, 'f', -1, 64)
+}
+
+// serialDate converts an Excel serial day number. The 1900 system counts from
+// 1899-12-30, which absorbs Excel's phantom 1900-02-29 for every later date.
+func (wb *xlsxWorkbook) serialDate(serial float64) string {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if wb.date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	days := math.Floor(serial)
+	date := epoch.AddDate(0, 0, int(days))
+	clock := serialTime(serial - days)
+	if clock.Hour() == 0 && clock.Minute() == 0 && clock.Second() == 0 {
+		return date.Format("2006-01-02")
+	}
+	return date.Format("2006-01-02") + " " + clock.Format("15:04:05")
+}
+
+// serialTime converts the fractional part of a serial number to a time of day
+func serialTime(serial float64) time.Time {
+	fraction := serial - math.Floor(serial)
+	seconds := int(math.Round(fraction * 86400))
+	return time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(seconds) * time.Second)
+}
+
+// xlsxRecords streams a worksheet's rows as records. Row numbers stand in
+// for line numbers in error reports; empty rows are skipped as in CSV.
+type xlsxRecords struct {
+	workbook *xlsxWorkbook
+	file     io.ReadCloser
+	decoder  *xml.Decoder
+	merges   []xlsxMerge
+	width    int // Widest row so far; shorter rows are padded to it
+	lastRow  int
+}
+
+func (wb *xlsxWorkbook) openRows(sheet xlsxSheet, merges []xlsxMerge) (*xlsxRecords, error) {
+	f, ok := wb.files[sheet.Path]
+	if !ok {
+		return nil, fmt.Errorf("worksheet %s is missing from the workbook", sheet.Name)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open worksheet %s: %v", sheet.Name, err)
+	}
+
+	return &xlsxRecords{
+		workbook: wb,
+		file:     r,
+		decoder:  xml.NewDecoder(r),
+		merges:   append([]xlsxMerge(nil), merges...), // Values are filled in per pass
+	}, nil
+}
+
+func (x *xlsxRecords) next() ([]string, int, error) {
+	for {
+		token, err := x.decoder.Token()
+		if err == io.EOF {
+			return nil, 0, err
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read worksheet: %v", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+
+		var row xlsxRow
+		if err := x.decoder.DecodeElement(&row, &start); err != nil {
+			return nil, 0, fmt.Errorf("failed to read worksheet row: %v", err)
+		}
+		if row.Num == 0 {
+			row.Num = x.lastRow + 1
+		}
+		x.lastRow = row.Num
+
+		var record []string
+		col := -1
+		for _, cell := range row.Cells {
+			if ref, _ := splitCellRef(cell.Ref); ref >= 0 {
+				col = ref
+			} else {
+				col++
+			}
+			for len(record) <= col {
+				record = append(record, "")
+			}
+			record[col] = x.workbook.cellValue(cell)
+		}
+
+		record = x.fillMerges(record, row.Num)
+
+		empty := true
+		for _, value := range record {
+			if strings.TrimSpace(value) != "" {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			continue
+		}
+
+		if len(record) > x.width {
+			x.width = len(record)
+		}
+		for len(record) < x.width {
+			record = append(record, "")
+		}
+		return record, row.Num, nil
+	}
+}
+
+// fillMerges copies each merged range's top-left value into the range's
+// other cells on this row, e.g. a header spanning two columns names both
+func (x *xlsxRecords) fillMerges(record []string, rowNum int) []string {
+	for i := range x.merges {
+		merge := &x.merges[i]
+		if rowNum < merge.firstRow || rowNum > merge.lastRow {
+			continue
+		}
+		if rowNum == merge.firstRow && merge.firstCol < len(record) {
+			merge.value = record[merge.firstCol]
+		}
+		for len(record) <= merge.lastCol {
+			record = append(record, "")
+		}
+		for col := merge.firstCol; col <= merge.lastCol; col++ {
+			record[col] = merge.value
+		}
+	}
+	return record
+}
+
+func (x *xlsxRecords) Close() error {
+	return x.file.Close()
+}
+
+// parseXLSXFile reads one worksheet of an Excel workbook through the same
+// format detection and column mapping as parseCSVFile. options.Sheet picks
+// the worksheet and options.HeaderRow the rows above its header.
+func parseXLSXFile(filePath, fileID, source, originalFilename string, profile *MappingProfile, options importOptions) (*ParsedCSVData, error) {
+	workbook, err := openXLSXWorkbook(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer workbook.Close()
+
+	sheet, err := workbook.sheet(options.Sheet)
+	if err != nil {
+		return nil, err
+	}
+	merges, err := workbook.mergedCells(sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	open := func() (recordReader, error) {
+		return workbook.openRows(sheet, merges)
+	}
+	parsedData, err := parseRecords(open, fileID, source, originalFilename, profile, options)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedData.Sheet = sheet.Name
+	parsedData.Sheets = workbook.sheetNames()
+	return parsedData, nil
+}